@@ -26,6 +26,7 @@ import (
 	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/zc"
 	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/zipx"
 )
 
 var (
@@ -35,14 +36,15 @@ var (
 )
 
 type Config struct {
-	ShowPath string            `json:"f2show"`  // 显示 www 文件夹资源
-	IsNative bool              `json:"native"`  // 使用原生文件服务
-	Index    string            `json:"index"`   // 默认首页文件名, index.html
-	Indexs   map[string]string `json:"indexs"`  // index map, 多索引系统，不能已 / 结尾
-	Routers  map[string]string `json:"routers"` // 路由表
-	TmplRoot string            `json:"tproot"`  // 根目录, /ROOT_PATH, 构建时可以在运行时替换，用于静态资源路径替换
-	TmplFile []string          `json:"tpfile"`  // 替换文件, ^app. ^umi. ^runtime. .html .htm .css .map .js // ^ 开头是前缀匹配, 否则是后缀匹配
-	Change   bool              `json:"change"`  // 支持文件变动
+	ShowPath string            `json:"f2show"`   // 显示 www 文件夹资源
+	IsNative bool              `json:"native"`   // 使用原生文件服务
+	Index    string            `json:"index"`    // 默认首页文件名, index.html
+	Indexs   map[string]string `json:"indexs"`   // index map, 多索引系统，不能已 / 结尾
+	Routers  map[string]string `json:"routers"`  // 路由表
+	TmplRoot string            `json:"tproot"`   // 根目录, /ROOT_PATH, 构建时可以在运行时替换，用于静态资源路径替换
+	TmplFile []string          `json:"tpfile"`   // 替换文件, ^app. ^umi. ^runtime. .html .htm .css .map .js // ^ 开头是前缀匹配, 否则是后缀匹配
+	Change   bool              `json:"change"`   // 支持文件变动
+	Compress bool              `json:"compress"` // 启用响应压缩, 存在 .gz 预压缩文件时优先使用
 }
 
 // 初始化方法， 处理 hdl 的而外配置接口
@@ -59,6 +61,7 @@ func Init3(www fs.FS, ifn InitFunc) {
 	flag.StringVar(&G.Front2.TmplRoot, "f2troot", "/ROOT_PATH", "root path, empty is disabled")
 	flag.Var(z.NewStrArr(&G.Front2.TmplFile, []string{"^app.", "^umi.", "^runtime.", ".html", ".htm", ".css", ".map", ".js", ".json"}), "f2tfile", "replace tmpl file")
 	flag.BoolVar(&G.Front2.Change, "f2change", false, "change file when file change")
+	flag.BoolVar(&G.Front2.Compress, "f2compress", false, "compress response")
 
 	z.Register("41-front2", func(zgg *z.Zgg) z.Closed {
 		hdl := NewHandler(www, G.Front2, "[_front2_]")
@@ -86,6 +89,9 @@ func (hdl *FrontHandler) Init(www fs.FS) *FrontHandler {
 			hdl.ServeFS = http.FileServer(hdl.HttpFS)
 		}
 	}
+	if hdl.Config.Compress && hdl.Zipper == nil {
+		hdl.Zipper = zipx.New(0, 1024, nil)
+	}
 	if hdl.Actions == nil {
 		hdl.Actions = map[string]ActionFunc{}
 		maps.Copy(hdl.Actions, ActionOpts)
@@ -131,6 +137,7 @@ type FrontHandler struct {
 	_map_lock sync.RWMutex
 	ServeFS   http.Handler // 直接服务, 优先级高，用于自定义配置
 	Actions   map[string]ActionFunc
+	Zipper    *zipx.Compress // 响应压缩
 }
 
 func (aa *FrontHandler) GetProxy(kk string) http.Handler {
@@ -186,6 +193,15 @@ func (aa *FrontHandler) Serve(zrc *z.Ctx) {
 // 路由规则复杂：需要支持动态参数、通配符或前缀匹配。
 // 路由频繁更新：TrieTree 的插入和删除操作效率更高（O(k) vs O(n)）
 func (aa *FrontHandler) ServeHTTP(rw http.ResponseWriter, rr *http.Request) {
+	// 响应压缩
+	if aa.Zipper != nil {
+		zipx.AddVary(rw.Header())
+		if enc := aa.Zipper.Accept(rr); enc != "" {
+			zw := aa.Zipper.NewWriter(rw, enc)
+			defer zw.Close()
+			rw = zw
+		}
+	}
 	// 代理路由服务
 	for _, kk := range aa.RouterKey {
 		if len(kk) > 0 && kk[0] == '@' {
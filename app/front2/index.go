@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/zipx"
 )
 
 // IndexWithTRY，依赖FileFS，不支持文件变动
@@ -57,6 +58,8 @@ func (aa *FrontHandler) IndexWithTRY(rw http.ResponseWriter, rr *http.Request, r
 			return // 处理文件内容错误
 		}
 		http.ServeContent(rw, rr, stat.Name(), stat.ModTime(), bytes.NewReader(tbts))
+	} else if aa.ServeGzip(rw, rr, fpath, stat.Name()) {
+		// 预压缩文件
 	} else {
 		http.ServeContent(rw, rr, stat.Name(), stat.ModTime(), file)
 	}
@@ -81,6 +84,8 @@ func (aa *FrontHandler) IndexWithCHG(rw http.ResponseWriter, rr *http.Request, r
 			return
 		}
 		http.ServeContent(rw, rr, stat.Name(), stat.ModTime(), bytes.NewReader(tbts))
+	} else if aa.ServeGzip(rw, rr, rr.URL.Path, stat.Name()) {
+		// 预压缩文件
 	} else {
 		// 正常返回文件
 		http.ServeContent(rw, rr, stat.Name(), stat.ModTime(), file)
@@ -118,8 +123,39 @@ func (aa *FrontHandler) IndexWithCHG(rw http.ResponseWriter, rr *http.Request, r
 				return
 			}
 			http.ServeContent(rw, rr, stat.Name(), stat.ModTime(), bytes.NewReader(tbts))
+		} else if aa.ServeGzip(rw, rr, index, stat.Name()) {
+			// 预压缩文件
 		} else {
 			http.ServeContent(rw, rr, stat.Name(), stat.ModTime(), file)
 		}
 	}
 }
+
+// ServeGzip, 启用压缩， 客户端支持 gzip 且存在 fpath.gz 预压缩文件时， 直接返回预压缩文件
+func (aa *FrontHandler) ServeGzip(rw http.ResponseWriter, rr *http.Request, fpath, name string) bool {
+	if !aa.Config.Compress || zipx.Negotiate(rr.Header.Get("Accept-Encoding")) != "gzip" {
+		return false
+	}
+	if len(fpath) == 0 || fpath[0] != '/' {
+		fpath = "/" + fpath
+	}
+	if !aa.Config.Change {
+		if _, exist := aa.FileFS[fpath[1:]+".gz"]; !exist {
+			return false // 依赖 FileFS 判断， 避免每次打开文件
+		}
+	}
+	file, err := aa.HttpFS.Open(fpath + ".gz")
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		return false
+	}
+	// Content-Type 根据原文件名称确定
+	zipx.AddVary(rw.Header())
+	rw.Header().Set("Content-Encoding", "gzip")
+	http.ServeContent(rw, rr, name, stat.ModTime(), file)
+	return true
+}
@@ -0,0 +1,35 @@
+package front2_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/suisrc/zgg/app/front2"
+)
+
+// go test -v app/front2/index_test.go -run Test_gzip
+
+func Test_gzip(t *testing.T) {
+	www := fstest.MapFS{
+		"app.js":    {Data: []byte("console.log('zgg')")},
+		"app.js.gz": {Data: []byte("gzip")},
+	}
+	serve := func(hdl *front2.FrontHandler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Vary", "Accept-Encoding")
+		hdl.ServeGzip(rec, req, "/app.js", "app.js")
+		return rec
+	}
+	// 未启用压缩， 不返回预压缩文件
+	if rec := serve(front2.NewHandler(www, front2.Config{}, "")); rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("compress off: %v", rec.Header())
+	}
+	rec := serve(front2.NewHandler(www, front2.Config{Compress: true}, ""))
+	if rec.Header().Get("Content-Encoding") != "gzip" || len(rec.Header().Values("Vary")) != 1 || rec.Body.String() != "gzip" {
+		t.Fatalf("compress on: %v %s", rec.Header(), rec.Body.String())
+	}
+}
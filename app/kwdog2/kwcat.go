@@ -11,6 +11,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/ze/tlsx"
@@ -21,6 +22,10 @@ type KwcatConfig struct {
 	AddrPort string            `json:"addr"`
 	Routers  map[string]string `json:"routers"`               // 其他路由
 	MaxConn  int               `json:"maxconn" default:"100"` // 最大并发数
+	// PROXY protocol, 接收上游 L4 代理的头部， 向后端发送 v2 头部
+	ProxyProto bool     `json:"proxyproto"`
+	ProxyFrom  []string `json:"proxyfrom"` // 可信来源 CIDR, "*" 信任所有来源, 为空不信任任何来源
+	ProxyV2    bool     `json:"proxyv2"`   // 向后端发送 PROXY v2 头部
 }
 
 // 初始化方法， 处理 hdl 的而外配置接口 443
@@ -31,6 +36,9 @@ func InitKwcat(ifn InitKwcatFunc) {
 	flag.BoolVar(&G.Kwcat2.Disabled, "c2disabled", true, "是否禁用kwcat2")
 	flag.StringVar(&G.Kwcat2.AddrPort, "c2addr", "0.0.0.0:443", "代理服务器地址和端口")
 	flag.Var(z.NewStrMap(&G.Kwcat2.Routers, z.HM{}), "c2rmap", "其他服务转发")
+	flag.BoolVar(&G.Kwcat2.ProxyProto, "c2proxypp", false, "是否接收 PROXY protocol 头部")
+	flag.Var(z.NewStrArr(&G.Kwcat2.ProxyFrom, []string{}), "c2proxyfrom", "PROXY protocol 可信来源 CIDR, '*' 信任所有来源")
+	flag.BoolVar(&G.Kwcat2.ProxyV2, "c2proxyv2", false, "是否向后端发送 PROXY v2 头部")
 
 	z.Register("13-kwcat2", func(zgg *z.Zgg) z.Closed {
 		if G.Kwcat2.Disabled {
@@ -41,6 +49,14 @@ func InitKwcat(ifn InitKwcatFunc) {
 			Address: G.Kwcat2.AddrPort,
 			Routers: G.Kwcat2.Routers,
 			MaxConn: G.Kwcat2.MaxConn,
+			ProxyV2: G.Kwcat2.ProxyV2,
+		}
+		if G.Kwcat2.ProxyProto {
+			pp, err := z.NewProxyProtocol(G.Kwcat2.ProxyFrom, 10*time.Second)
+			if err != nil {
+				z.Exit("[_kwcat2_]: proxy protocol config error: ", err)
+			}
+			hdl.Proxy = pp
 		}
 		zgg.Servers.Add(hdl)
 
@@ -59,6 +75,8 @@ type KwcatHandler struct {
 	Routers map[string]string
 	MaxConn int
 	Error   error
+	Proxy   *z.ProxyProtocol // 接收 PROXY protocol 头部， 为空不解析
+	ProxyV2 bool             // 向后端发送 PROXY v2 头部
 
 	sem      chan z.Sem
 	listener net.Listener
@@ -78,6 +96,9 @@ func (hdl *KwcatHandler) RunServe() {
 		z.Logn("[_kwcat2_]: http server listen failed: ", hdl.Error)
 		return
 	}
+	if hdl.Proxy != nil {
+		hdl.listener = hdl.Proxy.Listener(hdl.listener)
+	}
 	if hdl.MaxConn <= 0 {
 		hdl.MaxConn = 100
 	}
@@ -147,6 +168,13 @@ func (hdl *KwcatHandler) handle(src net.Conn) {
 		return
 	}
 	defer dst.Close()
+	// 发送 PROXY v2 头部， 传递客户端真实地址
+	if hdl.ProxyV2 {
+		if _, err := dst.Write(z.NewProxyHeader(src.RemoteAddr(), src.LocalAddr()).Format()); err != nil {
+			z.Logn("[_kwcat2_]: write proxy header failed: ", err)
+			return
+		}
+	}
 	// 将客户端发送的 ClientHello 消息转发到目标服务器, 透传
 	if _, err := dst.Write(buf); err != nil {
 		z.Logn("[_kwcat2_]: write client hello failed: ", err)
@@ -14,28 +14,60 @@ import (
 
 	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/zc"
+	"github.com/suisrc/zgg/z/ze/cachex"
 	"github.com/suisrc/zgg/z/ze/gte"
 	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/hmacx"
+	"github.com/suisrc/zgg/z/ze/jwtx"
+	"github.com/suisrc/zgg/z/ze/limit"
+	"github.com/suisrc/zgg/z/ze/policy"
 )
 
 // 反向代理服务配置规则
 // value: def+ 前缀表示共享默认网关配置， 其他的表示独立网关配置
+// value: h2c+ 前缀表示使用 h2c(HTTP/2 cleartext) 访问后端服务, 在 def+ 之后
 // key: @ 前缀表示多域名路由，格式为 @domain/path
+// value: 包含 | 或 ; 时表示上游服务池， 参考 gtw.ParseUpstreamPool, 路径使用第一个地址的路径
 
 type KwdogConfig struct {
-	Disabled bool              `json:"disabled"`
-	AddrPort string            `json:"addr" default:"0.0.0.0:12006"`
-	NextAddr string            `json:"next"`    // 默认 127.0.0.1:80
-	AuthAddr string            `json:"authz"`   // ??
-	AuthSkip bool              `json:"askip"`   // 默认不跳过, 可以忽略鉴权
-	Routers  map[string]string `json:"routers"` // 其他路由
-	Rtrack   bool              `json:"rtrack"`  // 追踪路由
-	Rauthz   string            `json:"rauthz"`  // 鉴权路由
-	Sites    []string          `json:"sites"`   // 站点列表， 用于标记 _xc
-	Logger   string            `json:"logger"`  // 日志发送地址
-	LogBody  bool              `json:"logBody"` // 记录日志中的Body
-	LogTty   bool              `json:"logTty"`  // 日志是否输出到终端
-	Record   int               `json:"record"`
+	Disabled   bool              `json:"disabled"`
+	AddrPort   string            `json:"addr" default:"0.0.0.0:12006"`
+	NextAddr   string            `json:"next"`    // 默认 127.0.0.1:80
+	AuthAddr   string            `json:"authz"`   // ??
+	AuthSkip   bool              `json:"askip"`   // 默认不跳过, 可以忽略鉴权
+	AuthCache  string            `json:"acache"`  // 鉴权结果缓存, 参考 gte.ParseAuthzCache
+	Routers    map[string]string `json:"routers"` // 其他路由
+	Rtrack     bool              `json:"rtrack"`  // 追踪路由
+	Rauthz     string            `json:"rauthz"`  // 鉴权路由
+	Sites      []string          `json:"sites"`   // 站点列表， 用于标记 _xc
+	Logger     string            `json:"logger"`  // 日志发送地址
+	LogBody    bool              `json:"logBody"` // 记录日志中的Body
+	LogTty     bool              `json:"logTty"`  // 日志是否输出到终端
+	Record     int               `json:"record"`
+	Limit      string            `json:"limit"`      // 限流规则, 参考 limit.Parse
+	NextH2c    bool              `json:"h2c"`        // 使用 h2c 访问后端服务
+	Jwks       string            `json:"jwks"`       // JWT 验证密钥, JWKS 文件或者地址, 配置后替代 authz
+	JwtIss     string            `json:"jwtiss"`     // JWT 签发者
+	JwtAud     []string          `json:"jwtaud"`     // JWT 受众
+	JwtMap     map[string]string `json:"jwtmap"`     // JWT 声明映射到请求头， claim = header
+	Oidc       string            `json:"oidc"`       // OIDC 签发者, 配置后网关完成登录, 替代 authz
+	OidcID     string            `json:"oidcid"`     // OIDC 客户端 ID
+	OidcKey    string            `json:"oidckey"`    // OIDC 客户端密钥
+	OidcSec    string            `json:"oidcsecret"` // 登录状态 cookie 加密密钥, 为空随机生成
+	Policy     string            `json:"policy"`     // 授权策略文件, toml 或者 json, 在鉴权之后进行授权
+	PolicyExp  bool              `json:"policyexp"`  // 授权过程记录到日志
+	PolicyApi  string            `json:"policyapi"`  // 授权测试接口路径, 为空不启用
+	AdminTkn   string            `json:"admintoken"` // 管理接口令牌, Authorization: Token xxx, 为空不注册授权测试接口
+	Hmac       string            `json:"hmac"`       // HMAC 签名验证密钥, kid:secret,kid:secret, 配置后替代 authz
+	HmacSign   string            `json:"hmacsign"`   // 对转发到后端的请求签名, kid:secret
+	Resil      string            `json:"resil"`      // 默认网关上游调用策略, 超时, 重试和熔断, 参考 gtw.ParseResilientPolicy
+	Resils     map[string]string `json:"resils"`     // 其他路由上游调用策略, key 与 routers 相同
+	Cache      string            `json:"cache"`      // 响应缓存, 参考 cachex.ParseCache, def+ 路由共享
+	CacheApi   string            `json:"cacheapi"`   // 清除缓存接口路径, 为空不启用
+	Transform  string            `json:"transform"`  // 默认网关请求和响应转换规则, 参考 gtw.ParseTransform
+	Transforms map[string]string `json:"transforms"` // 其他路由转换规则, key 与 routers 相同
+	Mirror     string            `json:"mirror"`     // 默认网关流量镜像, 参考 gtw.ParseMirror
+	Mirrors    map[string]string `json:"mirrors"`    // 其他路由流量镜像, key 与 routers 相同
 }
 
 // 初始化方法， 处理 hdl 的而外配置接口
@@ -48,6 +80,7 @@ func InitKwdog(ifn InitKwdogFunc) {
 	flag.StringVar(&G.Kwdog2.NextAddr, "k2next", "http://127.0.0.1:80", "后端服务地址")
 	flag.StringVar(&G.Kwdog2.AuthAddr, "k2auth", "", "认证服务地址， 默认只支持 f1kin 服务")
 	flag.BoolVar(&G.Kwdog2.AuthSkip, "k2askip", false, "在存在鉴权头部信息时，是否跳过鉴权")
+	flag.StringVar(&G.Kwdog2.AuthCache, "k2acache", "", "鉴权结果缓存， 如: ttl=10s;neg=2s;stale=30s;fail=open")
 	flag.Var(z.NewStrMap(&G.Kwdog2.Routers, z.HM{}), "k2rmap", "其他服务转发")
 	flag.BoolVar(&G.Kwdog2.Rtrack, "k2track", false, "是否记录其他路由的日志")
 	flag.StringVar(&G.Kwdog2.Rauthz, "k2rauth", "", "其他路由是否进行鉴权")
@@ -55,6 +88,30 @@ func InitKwdog(ifn InitKwdogFunc) {
 	flag.StringVar(&G.Kwdog2.Logger, "k2logger", "", "日志发送地址， none: 表示不记录日志")
 	flag.BoolVar(&G.Kwdog2.LogBody, "k2logbody", false, "记录日志中的Body")
 	flag.IntVar(&G.Kwdog2.Record, "k2record", -1, "记录级别")
+	flag.BoolVar(&G.Kwdog2.NextH2c, "k2h2c", false, "使用 h2c 访问后端服务")
+	flag.StringVar(&G.Kwdog2.Limit, "k2limit", "", "限流规则， 如: key=ip;rate=10/s;burst=20;conc=50")
+	flag.StringVar(&G.Kwdog2.Jwks, "k2jwks", "", "JWT 验证密钥, JWKS 文件或者地址")
+	flag.StringVar(&G.Kwdog2.JwtIss, "k2jwtiss", "", "JWT 签发者")
+	flag.Var(z.NewStrArr(&G.Kwdog2.JwtAud, []string{}), "k2jwtaud", "JWT 受众")
+	flag.Var(z.NewStrMap(&G.Kwdog2.JwtMap, z.HM{}), "k2jwtmap", "JWT 声明映射到请求头")
+	flag.StringVar(&G.Kwdog2.Oidc, "k2oidc", "", "OIDC 签发者地址")
+	flag.StringVar(&G.Kwdog2.OidcID, "k2oidcid", "", "OIDC 客户端 ID")
+	flag.StringVar(&G.Kwdog2.OidcKey, "k2oidckey", "", "OIDC 客户端密钥")
+	flag.StringVar(&G.Kwdog2.OidcSec, "k2oidcsecret", "", "OIDC 登录状态 cookie 加密密钥")
+	flag.StringVar(&G.Kwdog2.Policy, "k2policy", "", "授权策略文件， 文件修改后自动加载")
+	flag.BoolVar(&G.Kwdog2.PolicyExp, "k2policyexp", false, "授权过程记录到日志")
+	flag.StringVar(&G.Kwdog2.PolicyApi, "k2policyapi", "", "授权测试接口路径， 如: api/policy/check")
+	flag.StringVar(&G.Kwdog2.AdminTkn, "k2admintoken", "", "管理接口令牌， 授权测试接口需要")
+	flag.StringVar(&G.Kwdog2.Hmac, "k2hmac", "", "HMAC 签名验证密钥， 如: k1:secret1,k2:secret2")
+	flag.StringVar(&G.Kwdog2.HmacSign, "k2hmacsign", "", "对转发到后端的请求签名， 如: k1:secret1")
+	flag.StringVar(&G.Kwdog2.Resil, "k2resil", "", "上游调用策略， 如: connect=2s;response=30s;retries=2;breaker=5")
+	flag.Var(z.NewStrMap(&G.Kwdog2.Resils, z.HM{}), "k2resils", "其他路由上游调用策略")
+	flag.StringVar(&G.Kwdog2.Cache, "k2cache", "", "响应缓存， 如: store=mem;size=64m;entry=1m;stale=60s")
+	flag.StringVar(&G.Kwdog2.CacheApi, "k2cacheapi", "", "清除缓存接口路径， 如: api/cache/purge")
+	flag.StringVar(&G.Kwdog2.Transform, "k2transform", "", "请求和响应转换规则， 如: req.set X-Trace ${trace}; resp.del Server")
+	flag.Var(z.NewStrMap(&G.Kwdog2.Transforms, z.HM{}), "k2transforms", "其他路由转换规则")
+	flag.StringVar(&G.Kwdog2.Mirror, "k2mirror", "", "流量镜像， 如: http://127.0.0.1:82;percent=10;diff=true")
+	flag.Var(z.NewStrMap(&G.Kwdog2.Mirrors, z.HM{}), "k2mirrors", "其他路由流量镜像")
 
 	z.Register("11-kwdog2", func(zgg *z.Zgg) z.Closed {
 		if G.Kwdog2.Disabled {
@@ -82,10 +139,30 @@ func InitKwdog(ifn InitKwdogFunc) {
 		z.Logn("[_kwdog2_]: routers", hdl.RouterKey, "domains", hdl.DomainMap)
 		zgg.Servers.Add(z.NewServer("(KWDOG)", hdl, G.Kwdog2.AddrPort, nil))
 
+		closes := []func(){hdl.StopPools}
+		if hdl.Policy != nil {
+			closes = append(closes, hdl.Policy.Watch(5*time.Second))
+			if G.Kwdog2.PolicyApi != "" && G.Kwdog2.AdminTkn == "" {
+				z.Logn("[_kwdog2_]: policy api is disabled, admin token is empty")
+			} else if G.Kwdog2.PolicyApi != "" {
+				zgg.AddRouter("POST "+G.Kwdog2.PolicyApi, z.TokenAuth(&G.Kwdog2.AdminTkn, hdl.Policy.Check))
+			}
+			z.Logn("[_kwdog2_]: policy", G.Kwdog2.Policy, "rules", len(hdl.Policy.Policy().Rules))
+		}
+		if hdl.Cache != nil && G.Kwdog2.CacheApi != "" {
+			zgg.AddRouter("POST "+G.Kwdog2.CacheApi, hdl.Cache.Purge)
+		}
 		if ifn != nil {
 			ifn(hdl, zgg) // 初始化方法
 		}
-		return nil
+		if hdl.Limit != nil {
+			closes = append(closes, hdl.Limit.Close)
+		}
+		return func() {
+			for _, fn := range closes {
+				fn()
+			}
+		}
 	})
 
 }
@@ -102,17 +179,107 @@ func (hdl *KwdogHandler) Init(cfg KwdogConfig) error {
 			RecordReverseFunc,
 		)
 	}
-	hdl.GtwDefault, err = gtw.NewTargetGatewayV2(cfg.NextAddr)
+	next, pool, err := hdl.newPool(cfg.NextAddr)
+	if err != nil {
+		return err
+	}
+	hdl.GtwDefault, err = gtw.NewTargetGatewayV2(next)
 	if err != nil {
 		return err
 	}
+	hdl.GtwDefault.Upstreams = pool
+	if cfg.Resil != "" {
+		if hdl.GtwDefault.Resilience, err = gtw.ParseResilientPolicy(cfg.Resil); err != nil {
+			return err
+		}
+	}
+	if cfg.Cache != "" {
+		if hdl.Cache, err = cachex.ParseCache(cfg.Cache); err != nil {
+			return err
+		}
+		hdl.GtwDefault.Cacher = hdl.Cache
+	}
+	if cfg.Transform != "" {
+		if hdl.GtwDefault.Transform, err = gtw.ParseTransform("default", cfg.Transform); err != nil {
+			return err
+		}
+	}
+	if cfg.Mirror != "" {
+		if hdl.GtwDefault.Mirror, err = gtw.ParseMirror(cfg.Mirror); err != nil {
+			return err
+		}
+	}
 	hdl.GtwDefault.ProxyName = "kwdog2-gateway"
+	if cfg.NextH2c {
+		hdl.GtwDefault.Transport = gtw.TransportH2c
+	}
 	hdl.GtwDefault.RecordPool = rsp
-	hdl.GtwDefault.Authorizer = AuthzDefaultFunc(
-		cfg.Sites,
-		cfg.AuthAddr,
-		cfg.AuthSkip,
-	)
+	if cfg.HmacSign != "" {
+		keys, err := hmacx.ParseSecrets(cfg.HmacSign)
+		if err != nil {
+			return err
+		} else if len(keys) != 1 {
+			return fmt.Errorf("hmacsign must be one key, got %d", len(keys))
+		}
+		base := hdl.GtwDefault.Transport
+		if base == nil {
+			base = gtw.TransportGtw
+		}
+		for kid, secret := range keys {
+			hdl.GtwDefault.Transport = hmacx.NewTransport(hmacx.NewSigner(kid, secret), base)
+		}
+	}
+	if cfg.Hmac != "" {
+		keys, err := hmacx.ParseSecrets(cfg.Hmac)
+		if err != nil {
+			return err
+		}
+		hdl.GtwDefault.Authorizer = gte.NewAuthzHmacx(cfg.Sites, hmacx.NewVerifier(keys))
+	} else if cfg.Jwks != "" {
+		keys, err := jwtx.LoadKeys(cfg.Jwks)
+		if err != nil {
+			return err
+		}
+		vv := jwtx.NewValidator(keys)
+		vv.Issuer, vv.Audience, vv.Headers = cfg.JwtIss, cfg.JwtAud, cfg.JwtMap
+		hdl.GtwDefault.Authorizer = gte.NewAuthzJwtx(cfg.Sites, vv)
+	} else if cfg.Oidc != "" {
+		secret := []byte(cfg.OidcSec)
+		if len(secret) == 0 {
+			secret = []byte(jwtx.NewVerifier())
+			z.Logn("[_kwdog2_]: oidc secret is empty, use random secret")
+		}
+		az, err := gte.NewAuthzOidcx(cfg.Sites, cfg.Oidc, cfg.OidcID, cfg.OidcKey, secret)
+		if err != nil {
+			return err
+		}
+		az.Validator.Headers = cfg.JwtMap
+		hdl.GtwDefault.Authorizer = az
+	} else {
+		hdl.GtwDefault.Authorizer = AuthzDefaultFunc(
+			cfg.Sites,
+			cfg.AuthAddr,
+			cfg.AuthSkip,
+		)
+	}
+	if cfg.AuthCache != "" {
+		if err := setAuthzCache(hdl.GtwDefault.Authorizer, cfg.AuthCache); err != nil {
+			return err
+		}
+	}
+	if cfg.Policy != "" {
+		if hdl.Policy, err = policy.LoadEngine(cfg.Policy); err != nil {
+			return err
+		}
+		hdl.Policy.Explain = cfg.PolicyExp
+		hdl.GtwDefault.Authorizer = gte.NewAuthzPolicy(cfg.Sites, hdl.GtwDefault.Authorizer, hdl.Policy)
+	}
+	if cfg.Limit != "" {
+		if hdl.Limit, err = limit.Parse(cfg.Limit); err != nil {
+			return err
+		}
+		hdl.GtwDefault.Limiter = hdl.Limit.Start()
+	}
 	if cfg.Rtrack {
 		hdl.RecordPool = rsp
 	}
@@ -131,10 +298,36 @@ func (hdl *KwdogHandler) Init(cfg KwdogConfig) error {
 				cfg.Rauthz,
 				cfg.AuthSkip,
 			)
+			if cfg.AuthCache != "" {
+				if err := setAuthzCache(hdl.Authorizer, cfg.AuthCache); err != nil {
+					return err
+				}
+			}
+			if hdl.Policy != nil {
+				hdl.Authorizer = gte.NewAuthzPolicy(cfg.Sites, hdl.Authorizer, hdl.Policy)
+			}
 		}
 	}
 	// 特殊的多域名路由情况， 以 @ 开头， 格式为 @domain/path, 其中 path 可省略， 默认根路径
 	hdl.Routers = make(map[string]string)
+	hdl.Resils = make(map[string]*gtw.ResilientPolicy)
+	for kk, vv := range cfg.Resils {
+		if hdl.Resils[kk], err = gtw.ParseResilientPolicy(vv); err != nil {
+			return err
+		}
+	}
+	hdl.Transforms = make(map[string]*gtw.Transform)
+	for kk, vv := range cfg.Transforms {
+		if hdl.Transforms[kk], err = gtw.ParseTransform(kk, vv); err != nil {
+			return err
+		}
+	}
+	hdl.Mirrors = make(map[string]*gtw.Mirror)
+	for kk, vv := range cfg.Mirrors {
+		if hdl.Mirrors[kk], err = gtw.ParseMirror(vv); err != nil {
+			return err
+		}
+	}
 	hdl.DomainMap = make(map[string][]string)
 	// 解析所有路由
 	for kk, vv := range cfg.Routers {
@@ -172,17 +365,65 @@ func (hdl *KwdogHandler) Init(cfg KwdogConfig) error {
 	return nil
 }
 
+// 为 f1kin 鉴权器增加结果缓存
+func setAuthzCache(az gtw.Authorizer, rule string) error {
+	f1, ok := az.(*gte.AuthzF1kin)
+	if !ok {
+		return nil
+	}
+	cache, err := gte.ParseAuthzCache(rule)
+	if err != nil {
+		return err
+	}
+	f1.Cache = cache
+	return nil
+}
+
 type KwdogHandler struct {
 	Routers  map[string]string // 路由配置
 	NextAddr string            // 后端服务地址
 	// ----------------------------------------
-	RecordPool gtw.RecordPool          // 记录池
-	GtwDefault *gtw.GatewayProxy       // 默认网关
-	Authorizer gtw.Authorizer          // 默认记录
-	RouterMap  map[string]gtw.IGateway // 路由网关
-	RouterKey  []string                // 目录网关
-	DomainMap  map[string][]string     // 域名网关
+	RecordPool gtw.RecordPool                  // 记录池
+	GtwDefault *gtw.GatewayProxy               // 默认网关
+	Authorizer gtw.Authorizer                  // 默认记录
+	Limit      *limit.Limit                    // 限流规则
+	Policy     *policy.Engine                  // 授权策略
+	Resils     map[string]*gtw.ResilientPolicy // 路由上游调用策略
+	Cache      *cachex.Cache                   // 响应缓存
+	Transforms map[string]*gtw.Transform       // 路由转换规则
+	Mirrors    map[string]*gtw.Mirror          // 路由流量镜像
+	RouterMap  map[string]gtw.IGateway         // 路由网关
+	RouterKey  []string                        // 目录网关
+	DomainMap  map[string][]string             // 域名网关
 	_svc_lock  sync.RWMutex
+	_pool_stop []func() // 上游服务池健康检查
+}
+
+// 解析上游服务池并启动健康检查， 返回第一个上游地址用于路径处理， 不是服务池时原样返回
+func (aa *KwdogHandler) newPool(target string) (string, *gtw.UpstreamPool, error) {
+	prefix := ""
+	if strings.HasPrefix(target, "domain+") || strings.HasPrefix(target, "domain-") {
+		prefix, target = target[:7], target[7:]
+	}
+	if !strings.ContainsAny(target, "|;") {
+		return prefix + target, nil, nil
+	}
+	pool, err := gtw.ParseUpstreamPool(target)
+	if err != nil {
+		return "", nil, err
+	}
+	aa._pool_stop = append(aa._pool_stop, pool.Start())
+	return prefix + pool.Upstreams[0].URL.String(), pool, nil
+}
+
+// 停止上游服务池健康检查
+func (aa *KwdogHandler) StopPools() {
+	aa._svc_lock.Lock()
+	defer aa._svc_lock.Unlock()
+	for _, stop := range aa._pool_stop {
+		stop()
+	}
+	aa._pool_stop = nil
 }
 
 func (aa *KwdogHandler) GetProxy(kk string) gtw.IGateway {
@@ -207,8 +448,16 @@ func (aa *KwdogHandler) NewProxy(kk string) (gtw.IGateway, error) {
 		share = true
 		vv = vv[4:]
 	}
+	h2c := false
+	if strings.HasPrefix(vv, "h2c+") {
+		h2c = true
+		vv = vv[4:]
+	}
+	vv, pool, err := aa.newPool(vv)
+	if err != nil {
+		return nil, err
+	}
 	var gw *gtw.GatewayProxy
-	var err error
 	if strings.HasPrefix(vv, "domain+") {
 		gw, err = gtw.NewCustomGatewayV2(vv[7:], "", nil)
 	} else if strings.HasPrefix(vv, "domain-") {
@@ -223,10 +472,25 @@ func (aa *KwdogHandler) NewProxy(kk string) (gtw.IGateway, error) {
 		aa.RouterMap = make(map[string]gtw.IGateway)
 	}
 	gw.ProxyName = strings.ReplaceAll(kk, "/", "_") + "-gateway"
+	gw.Upstreams = pool
+	gw.Resilience = aa.Resils[kk]
+	gw.Transform = aa.Transforms[kk]
+	gw.Mirror = aa.Mirrors[kk] // 镜像与后端服务相关， 不使用默认网关的配置
+	if h2c && gw.Transport == nil {
+		gw.Transport = gtw.TransportH2c
+	}
 	if share {
 		// 共享默认网关配置
 		gw.RecordPool = aa.GtwDefault.RecordPool
 		gw.Authorizer = aa.GtwDefault.Authorizer
+		gw.Limiter = aa.GtwDefault.Limiter
+		gw.Cacher = aa.GtwDefault.Cacher
+		if gw.Resilience == nil {
+			gw.Resilience = aa.GtwDefault.Resilience
+		}
+		if gw.Transform == nil {
+			gw.Transform = aa.GtwDefault.Transform
+		}
 	} else {
 		// 记录其他网关日志
 		gw.RecordPool = aa.RecordPool
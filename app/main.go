@@ -7,8 +7,10 @@ import (
 	_ "github.com/suisrc/zgg/cmd"
 	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/zc"
+	_ "github.com/suisrc/zgg/z/ze/cors"
 	_ "github.com/suisrc/zgg/z/ze/log"
 	_ "github.com/suisrc/zgg/z/ze/rdx"
+	// _ "github.com/suisrc/zgg/z/ze/grace" // 平滑升级(unix), -grace 启用, kill -USR2 <pid>
 	// _ "github.com/suisrc/zgg/app/zhe" // 测试模块
 	// _ "github.com/suisrc/zgg/app/ebpfgo" // 监控模块
 )
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"flag"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,6 +20,7 @@ func init() {
 	z.CMD["certca"] = CertCA // 创建一个根证书
 	z.CMD["certsa"] = CertSA // 创建一个中间证书
 	z.CMD["certce"] = CertCE // 通过中间证书创建一个证书
+	z.CMD["certcc"] = CertCC // 通过中间证书创建一个客户端证书
 	z.CMD["certex"] = CertEX // 验证生疏的过期时间
 }
 
@@ -219,6 +221,72 @@ func CertCE() {
 // -------------------------------------------------------------------
 // -------------------------------------------------------------------
 
+func CertCC() {
+	var (
+		sacn  string
+		path  string
+		cname string
+		uri   string
+		email string
+	)
+	flag.StringVar(&sacn, "cacn", "sa", "cert sa common name")
+	flag.StringVar(&path, "path", "", "cert folder path")
+	flag.StringVar(&cname, "cname", "client", "cert common name")
+	flag.StringVar(&uri, "uri", "", "cert uri san, e.g. spiffe://example.com/ns/default/sa/app, use ',' to split")
+	flag.StringVar(&email, "email", "", "cert email san, use ',' to split")
+	flag.Parse() // flag.CommandLine.Parse(os.Args[2:])
+	if path == "" {
+		println("cert path is empty")
+		return
+	}
+	uris := []*url.URL{}
+	for str := range strings.SplitSeq(uri, ",") {
+		if str = strings.TrimSpace(str); str == "" {
+			continue
+		}
+		uu, err := url.Parse(str)
+		if err != nil {
+			z.Exit(err)
+		}
+		uris = append(uris, uu)
+	}
+
+	crtSaBts, err := os.ReadFile(filepath.Join(path, sacn+".crt"))
+	if err != nil {
+		z.Exit(err)
+	}
+	keySaBts, err := os.ReadFile(filepath.Join(path, sacn+".key"))
+	if err != nil {
+		z.Exit(err)
+	}
+
+	emails := []string{}
+	for str := range strings.SplitSeq(email, ",") {
+		if str = strings.TrimSpace(str); str != "" {
+			emails = append(emails, str)
+		}
+	}
+	crt, err := tlsx.CreateCC(nil, cname, uris, emails, crtSaBts, keySaBts)
+	if err != nil {
+		z.Exit(err)
+		return
+	}
+
+	// ------------------------------------------------------------------------
+	println("write files: " + filepath.Join(path, cname+".crt | key | b64(crt)"))
+	os.MkdirAll(path, 0755)
+	os.WriteFile(filepath.Join(path, cname+".crt"), []byte(crt.Crt+string(crtSaBts)), 0644)
+	os.WriteFile(filepath.Join(path, cname+".key"), []byte(crt.Key), 0600)
+
+	b64 := base64.StdEncoding.EncodeToString([]byte(crt.Crt))
+	os.WriteFile(filepath.Join(path, cname+".b64"), []byte(b64), 0644)
+	println("================ cert create success ================")
+}
+
+// -------------------------------------------------------------------
+// -------------------------------------------------------------------
+// -------------------------------------------------------------------
+
 func CertEX() {
 	// /etc/kubernetes/pki
 	// /var/lib/rancher/k3s/server/tls
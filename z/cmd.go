@@ -96,10 +96,22 @@ func Initializ() {
 	flag.IntVar(&(G.Server.Port), "port", 80, "http server Port")
 	flag.IntVar(&(G.Server.Ptls), "ptls", 443, "https server Port")
 	flag.BoolVar(&(G.Server.Dual), "dual", false, "running http and https server")
+	flag.Var(NewStrArr(&(G.Server.Listen), []string{}), "listen", "http server listen, tcp://host:port, unix:///path.sock, fd://3")
 	flag.StringVar(&(G.Server.Engine), "eng", "map", "http server router engine")
 	flag.StringVar(&(G.Server.ApiRoot), "api", "", "http server api root")
 	flag.StringVar(&(G.Server.TplPath), "tpl", "", "templates folder path")
 	flag.StringVar(&(G.Server.ReqXrtd), "xrt", "", "X-Request-Rt default value")
+	flag.BoolVar(&(G.Server.H2c), "h2c", false, "http server enable h2c(HTTP/2 cleartext)")
+	flag.IntVar(&(G.Server.H2Stream), "h2stream", 0, "http/2 max concurrent streams")
+	flag.IntVar(&(G.Server.H2PingTm), "h2pingtm", 0, "http/2 idle connection ping interval(s)")
+	flag.IntVar(&(G.Server.RTimeout), "rtimeout", 0, "http server read timeout(s)")
+	flag.IntVar(&(G.Server.WTimeout), "wtimeout", 0, "http server write timeout(s)")
+	flag.IntVar(&(G.Server.ITimeout), "itimeout", 120, "http server idle timeout(s)")
+	flag.IntVar(&(G.Server.HTimeout), "htimeout", 10, "http server read header timeout(s)")
+	flag.StringVar(&(G.Server.AltSvc), "altsvc", "", "http response header Alt-Svc")
+	flag.BoolVar(&(G.Server.ProxyProto), "proxyproto", false, "http server accept PROXY protocol v1/v2 header")
+	flag.Var(NewStrArr(&(G.Server.ProxyFrom), []string{}), "proxyfrom", "PROXY protocol trusted source cidr, '*' trust all, empty trust none")
+	flag.Var(NewStrArr(&(G.Server.Trusted), TrustedProxyDef), "trusted", "trusted proxy cidr for X-Forwarded-*, '*' trust all")
 
 	//  register default serve
 	Register("90-server", RegisterHttpServe)
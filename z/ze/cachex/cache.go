@@ -0,0 +1,601 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 网关响应缓存(RFC 9111 共享缓存)， 支持内存和磁盘存储
+
+package cachex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// 缓存状态， 记录在 Record0.Expand["cache"]
+const (
+	StatusHit         = "HIT"
+	StatusMiss        = "MISS"
+	StatusRevalidated = "REVALIDATED"
+	StatusStale       = "STALE"
+	StatusBypass      = "BYPASS"
+)
+
+// 默认可以缓存的状态码， 没有明确过期时间时使用启发式过期时间
+var heuristicStatus = []int{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// 不保存的响应头
+var skipHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Set-Cookie", "Cache-Status",
+}
+
+var _ gtw.Cacher = (*Cache)(nil)
+
+type Cache struct {
+	Store        Store
+	MaxEntry     int64         // 单个响应最大缓存大小
+	StaleIfError time.Duration // 上游错误时可以使用过期缓存的时间， 响应或者请求中的 stale-if-error 优先
+	Heuristic    time.Duration // 启发式过期时间上限(Last-Modified 的 10%)， 0 不使用
+	Name         string        // Cache-Status 中的缓存名称
+	Wait         time.Duration // 合并请求等待的最长时间， 超时后直接请求上游， 0 不限制
+	flights      map[string]chan struct{}
+	lock         sync.Mutex
+}
+
+func NewCache(store Store) *Cache {
+	return &Cache{
+		Store:     store,
+		MaxEntry:  1 << 20,
+		Heuristic: time.Hour,
+		Name:      "zgg",
+		Wait:      5 * time.Second,
+		flights:   map[string]chan struct{}{},
+	}
+}
+
+// 解析缓存配置, 格式: store=mem;size=64m;entry=1m;stale=60s;heuristic=1h;wait=5s, 磁盘存储: store=disk:/var/cache/zgg
+func ParseCache(str string) (*Cache, error) {
+	cc := NewCache(nil)
+	store, size := "mem", int64(64<<20)
+	for kv := range strings.SplitSeq(str, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		var err error
+		switch key {
+		case "":
+			continue
+		case "store":
+			store = val
+		case "size":
+			size, err = ParseSize(val)
+		case "entry":
+			cc.MaxEntry, err = ParseSize(val)
+		case "stale":
+			cc.StaleIfError, err = time.ParseDuration(val)
+		case "heuristic":
+			cc.Heuristic, err = time.ParseDuration(val)
+		case "name":
+			cc.Name = val
+		case "wait":
+			cc.Wait, err = time.ParseDuration(val)
+		default:
+			err = errors.New("unknow field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cache parse %s error: %v", kv, err)
+		}
+	}
+	switch kind, dir, _ := strings.Cut(store, ":"); kind {
+	case "mem", "memory":
+		cc.Store = NewMemoryStore(size)
+	case "disk":
+		if dir == "" {
+			return nil, errors.New("cache disk store dir is empty")
+		}
+		ds, err := NewDiskStore(dir, size)
+		if err != nil {
+			return nil, err
+		}
+		cc.Store = ds
+	default:
+		return nil, fmt.Errorf("cache store unknow: %s", store)
+	}
+	return cc, nil
+}
+
+// 解析大小, 如: 1024, 512k, 64m, 1g
+func ParseSize(str string) (int64, error) {
+	str = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(str)), "b")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(str, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(str, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(str, "g"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		str = str[:len(str)-1]
+	}
+	num, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || num < 0 {
+		return 0, fmt.Errorf("invalid size: %s", str)
+	}
+	return num * unit, nil
+}
+
+// -----------------------------------------------------------------------------------
+
+// 缓存 key, host + uri， Vary 的变体在 key 之后追加请求头
+func (cc *Cache) Key(req *http.Request) string {
+	return strings.ToLower(req.Host) + req.URL.RequestURI()
+}
+
+func (cc *Cache) Cache(gw gtw.IGateway, req, outreq *http.Request, rt gtw.IRecord, fetch gtw.FetchFunc) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		res, err := fetch(outreq)
+		if err == nil && res.StatusCode < 400 && !slices.Contains([]string{"HEAD", "OPTIONS", "TRACE"}, req.Method) {
+			cc.invalidate(req, res) // 不安全的方法， 清除缓存
+		}
+		return res, err
+	}
+	qd := requestDirectives(req.Header)
+	if qd.has("no-store") {
+		res, err := fetch(outreq)
+		return cc.mark(res, rt, StatusBypass, "fwd=bypass"), err
+	}
+	key, now := cc.Key(req), time.Now()
+	entry := cc.lookup(key, req)
+	if entry != nil && cc.fresh(entry, qd, now) {
+		return cc.response(req, rt, entry, now, StatusHit, "hit"), nil
+	}
+	if qd.has("only-if-cached") {
+		return cc.mark(cc.build(req, http.StatusGatewayTimeout, http.Header{}, nil), rt, StatusMiss, "fwd=miss; detail=only-if-cached"), nil
+	}
+	// 合并请求， 等待正在进行的请求完成后重新查找， 仍然没有可用缓存时直接请求上游
+	// 正在进行的请求在响应体读取完成后才结束， 等待时间需要限制， 避免慢速的响应阻塞其他请求
+	wait, leave := cc.acquire(key)
+	if wait != nil {
+		var timeout <-chan time.Time
+		if cc.Wait > 0 {
+			timer := time.NewTimer(cc.Wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-wait:
+		case <-timeout:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		now = time.Now()
+		if entry = cc.lookup(key, req); entry != nil && cc.fresh(entry, qd, now) {
+			return cc.response(req, rt, entry, now, StatusHit, "hit; detail=coalesced"), nil
+		}
+		leave = func() {}
+	}
+	return cc.forward(req, outreq, rt, fetch, key, entry, qd, leave)
+}
+
+// 请求上游服务， 重新验证或者保存响应， leave 在保存完成后调用
+func (cc *Cache) forward(req, outreq *http.Request, rt gtw.IRecord, fetch gtw.FetchFunc, key string, entry *Entry, qd directives, leave func()) (*http.Response, error) {
+	// 使用缓存的验证器， 客户端自己的条件请求直接转发
+	validate := false
+	if entry != nil && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			outreq.Header.Set("If-None-Match", etag)
+			validate = true
+		}
+		if lm := entry.Header.Get("Last-Modified"); lm != "" {
+			outreq.Header.Set("If-Modified-Since", lm)
+			validate = true
+		}
+	}
+	reqTime := time.Now()
+	res, err := fetch(outreq)
+	respTime := time.Now()
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		if entry != nil && cc.staleIfError(entry, qd, respTime) {
+			if res != nil {
+				res.Body.Close()
+			}
+			leave()
+			return cc.response(req, rt, entry, respTime, StatusStale, "hit; detail=stale-if-error"), nil
+		}
+		leave()
+		return cc.mark(res, rt, StatusMiss, "fwd=miss"), err
+	}
+	if validate && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		// 使用 304 响应更新缓存的响应头
+		updated := *entry
+		updated.Header = entry.Header.Clone()
+		for kk, vv := range res.Header {
+			if kk != "Content-Length" && !slices.Contains(skipHeaders, kk) {
+				updated.Header[kk] = vv
+			}
+		}
+		updated.ReqTime, updated.RespTime = reqTime, respTime
+		cc.Store.Set(&updated)
+		leave()
+		return cc.response(req, rt, &updated, respTime, StatusRevalidated, "fwd=stale; fwd-status=304"), nil
+	}
+	fwd := "fwd=uri-miss"
+	if entry != nil {
+		fwd = "fwd=stale"
+	}
+	fwd += "; fwd-status=" + strconv.Itoa(res.StatusCode)
+	if !cc.storable(outreq, res, qd) {
+		leave()
+		return cc.mark(res, rt, StatusMiss, fwd), nil
+	}
+	stored := &Entry{Status: res.StatusCode, Header: res.Header.Clone(), ReqTime: reqTime, RespTime: respTime}
+	for _, kk := range append(skipHeaders, stored.Header.Values("Connection")...) {
+		stored.Header.Del(kk)
+	}
+	// 响应体读取完成后保存
+	res.Body = gtw.NewTeeBody(res.Body, cc.MaxEntry, func(body []byte, full bool) {
+		if full {
+			stored.Body = body
+			cc.store(key, req, stored)
+		}
+		leave()
+	})
+	return cc.mark(res, rt, StatusMiss, fwd+"; stored"), nil
+}
+
+// 标记缓存状态
+func (cc *Cache) mark(res *http.Response, rt gtw.IRecord, code, status string) *http.Response {
+	if res != nil {
+		res.Header.Set("Cache-Status", cc.Name+"; "+status)
+	}
+	gtw.RecordExpand(rt, "cache", code)
+	return res
+}
+
+// 使用缓存构建响应， 处理客户端的条件请求
+func (cc *Cache) response(req *http.Request, rt gtw.IRecord, entry *Entry, now time.Time, code, status string) *http.Response {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(entry.age(now)/time.Second), 10))
+	if entry.Status == http.StatusOK && notModified(req, entry) {
+		header.Del("Content-Length")
+		return cc.mark(cc.build(req, http.StatusNotModified, header, nil), rt, code, status)
+	}
+	return cc.mark(cc.build(req, entry.Status, header, entry.Body), rt, code, status)
+}
+
+func (cc *Cache) build(req *http.Request, code int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(code) + " " + http.StatusText(code),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// -----------------------------------------------------------------------------------
+
+// 查找缓存， 处理 Vary 索引
+func (cc *Cache) lookup(key string, req *http.Request) *Entry {
+	entry := cc.Store.Get(key)
+	if entry != nil && len(entry.Vary) > 0 {
+		entry = cc.Store.Get(variant(key, entry.Vary, req))
+	}
+	return entry
+}
+
+func (cc *Cache) store(key string, req *http.Request, entry *Entry) {
+	entry.Key = key
+	if names := varyNames(entry.Header); len(names) > 0 {
+		cc.Store.Set(&Entry{Key: key, Vary: names, RespTime: entry.RespTime})
+		entry.Key = variant(key, names, req)
+	}
+	cc.Store.Set(entry)
+}
+
+func variant(key string, names []string, req *http.Request) string {
+	sbr := strings.Builder{}
+	sbr.WriteString(key)
+	for _, name := range names {
+		sbr.WriteString("\n" + name + ": " + strings.Join(req.Header.Values(name), ","))
+	}
+	return sbr.String()
+}
+
+func varyNames(header http.Header) []string {
+	names := []string{}
+	for _, vv := range header.Values("Vary") {
+		for name := range strings.SplitSeq(vv, ",") {
+			if name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// 响应是否可以保存， req 为转发的请求， 包含鉴权器添加的用户信息
+func (cc *Cache) storable(req *http.Request, res *http.Response, qd directives) bool {
+	rd := parseDirectives(res.Header)
+	switch {
+	case res.StatusCode < 200 || res.StatusCode == http.StatusPartialContent || res.StatusCode == http.StatusNotModified:
+		return false
+	case qd.has("no-store") || rd.has("no-store") || rd.has("private"):
+		return false
+	case slices.Contains(varyNames(res.Header), "*"):
+		return false
+	case res.Header.Get("Set-Cookie") != "":
+		return false // 共享缓存不保存用户相关的 Cookie
+	case req.Header.Get("Authorization") != "" && !rd.has("public") && !rd.has("s-maxage") && !rd.has("must-revalidate"):
+		return false
+	case identity(req.Header) && !rd.has("public") && !rd.has("s-maxage"):
+		return false // 带有用户身份的请求， 响应可能与用户相关
+	case cc.MaxEntry > 0 && res.ContentLength > cc.MaxEntry:
+		return false
+	}
+	if rd.has("max-age") || rd.has("s-maxage") || rd.has("public") || res.Header.Get("Expires") != "" {
+		return true
+	}
+	if !slices.Contains(heuristicStatus, res.StatusCode) {
+		return false
+	}
+	// 没有过期时间， 需要有验证器， 启发式过期时间为 0 时每次重新验证
+	return res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != ""
+}
+
+// 请求是否带有用户身份， Cookie 或者网关认证后的 X-Request-Sky-*
+func identity(header http.Header) bool {
+	if header.Get("Cookie") != "" {
+		return true
+	}
+	for kk := range header {
+		if gtw.HasPrefixFold(kk, "X-Request-Sky-") {
+			return true
+		}
+	}
+	return false
+}
+
+// 缓存是否可以直接使用
+func (cc *Cache) fresh(entry *Entry, qd directives, now time.Time) bool {
+	rd := parseDirectives(entry.Header)
+	if rd.has("no-cache") || qd.has("no-cache") {
+		return false
+	}
+	age, life := entry.age(now), cc.lifetime(entry, rd)
+	if val, ok := qd.seconds("max-age"); ok && age > val {
+		return false
+	}
+	if val, ok := qd.seconds("min-fresh"); ok && life-age < val {
+		return false
+	}
+	if life > age {
+		return true
+	}
+	// 已经过期， 请求允许使用过期的缓存， 并且响应没有要求重新验证
+	if qd.has("max-stale") && !rd.has("must-revalidate") && !rd.has("proxy-revalidate") && !rd.has("s-maxage") {
+		val, ok := qd.seconds("max-stale")
+		return !ok || age-life <= val
+	}
+	return false
+}
+
+// 上游错误时是否可以使用过期的缓存
+func (cc *Cache) staleIfError(entry *Entry, qd directives, now time.Time) bool {
+	rd := parseDirectives(entry.Header)
+	if rd.has("must-revalidate") || rd.has("proxy-revalidate") || rd.has("s-maxage") {
+		return false
+	}
+	limit := cc.StaleIfError
+	if val, ok := rd.seconds("stale-if-error"); ok {
+		limit = val
+	}
+	if val, ok := qd.seconds("stale-if-error"); ok {
+		limit = val
+	}
+	return limit > 0 && entry.age(now)-cc.lifetime(entry, rd) <= limit
+}
+
+// 过期时间， s-maxage > max-age > Expires > 启发式
+func (cc *Cache) lifetime(entry *Entry, rd directives) time.Duration {
+	if val, ok := rd.seconds("s-maxage"); ok {
+		return val
+	}
+	if val, ok := rd.seconds("max-age"); ok {
+		return val
+	}
+	if exp := entry.Header.Get("Expires"); exp != "" {
+		tt, err := http.ParseTime(exp)
+		if err != nil {
+			return 0 // 无效的 Expires 视为已经过期
+		}
+		return tt.Sub(entry.date())
+	}
+	if cc.Heuristic > 0 && slices.Contains(heuristicStatus, entry.Status) {
+		if lm, err := http.ParseTime(entry.Header.Get("Last-Modified")); err == nil {
+			return min(cc.Heuristic, entry.date().Sub(lm)/10)
+		}
+	}
+	return 0
+}
+
+func (e *Entry) date() time.Time {
+	if tt, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return tt
+	}
+	return e.RespTime
+}
+
+// 当前年龄， RFC 9111 4.2.3
+func (e *Entry) age(now time.Time) time.Duration {
+	apparent := max(0, e.RespTime.Sub(e.date()))
+	value := time.Duration(0)
+	if num, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && num > 0 {
+		value = time.Duration(num) * time.Second
+	}
+	corrected := max(apparent, value+e.RespTime.Sub(e.ReqTime))
+	return corrected + now.Sub(e.RespTime)
+}
+
+// 客户端的条件请求是否匹配缓存
+func notModified(req *http.Request, entry *Entry) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(entry.Header.Get("ETag"), "W/")
+		for tag := range strings.SplitSeq(inm, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || etag != "" && strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil {
+		lm, err := http.ParseTime(entry.Header.Get("Last-Modified"))
+		return err == nil && !lm.After(ims)
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------
+
+// 加入请求合并， 已经有请求在进行时返回等待的 chan， 否则返回完成函数
+func (cc *Cache) acquire(key string) (chan struct{}, func()) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	if cc.flights == nil {
+		cc.flights = map[string]chan struct{}{}
+	}
+	if wait, ok := cc.flights[key]; ok {
+		return wait, nil
+	}
+	done := make(chan struct{})
+	cc.flights[key] = done
+	return nil, sync.OnceFunc(func() {
+		cc.lock.Lock()
+		delete(cc.flights, key)
+		cc.lock.Unlock()
+		close(done)
+	})
+}
+
+// 不安全的方法成功后清除请求地址， Location 和 Content-Location 的缓存
+func (cc *Cache) invalidate(req *http.Request, res *http.Response) {
+	cc.remove(cc.Key(req), false)
+	for _, name := range []string{"Location", "Content-Location"} {
+		if loc := res.Header.Get(name); loc != "" {
+			if uu, err := req.URL.Parse(loc); err == nil && (uu.Host == "" || strings.EqualFold(uu.Host, req.Host)) {
+				cc.remove(strings.ToLower(req.Host)+uu.RequestURI(), false)
+			}
+		}
+	}
+}
+
+// 清除缓存， target 为地址, 以 * 结尾时按前缀清除， 返回清除的数量
+func (cc *Cache) Remove(target string) int {
+	target, prefix := strings.CutSuffix(target, "*")
+	if uu, err := url.Parse(target); err == nil && uu.Host != "" {
+		target = strings.ToLower(uu.Host) + uu.RequestURI()
+		if prefix && uu.Path == "" && uu.RawQuery == "" {
+			target = strings.TrimSuffix(target, "/") // 按域名清除
+		}
+	}
+	return cc.remove(target, prefix)
+}
+
+func (cc *Cache) remove(key string, prefix bool) int {
+	count := 0
+	if !prefix && cc.Store.Del(key) {
+		count++
+	}
+	if !prefix {
+		key += "\n" // Vary 的变体
+	}
+	for _, kk := range cc.Store.Keys(key) {
+		if cc.Store.Del(kk) {
+			count++
+		}
+	}
+	return count
+}
+
+type PurgeInput struct {
+	Urls []string `json:"urls"`
+}
+
+// 清除缓存接口, POST {"urls": ["http://example.com/api/*"]}
+func (cc *Cache) Purge(ctx *z.Ctx) {
+	in, err := z.ReadBody(ctx.Request, &PurgeInput{})
+	if err != nil || len(in.Urls) == 0 {
+		ctx.JSON(&z.Result{ErrCode: "invalid-input", Message: "urls is empty", Status: http.StatusBadRequest})
+		return
+	}
+	count := 0
+	for _, target := range in.Urls {
+		count += cc.Remove(target)
+	}
+	z.Logn("[_cachex_]: purge", in.Urls, "count", count)
+	z.JSON(ctx, &z.Result{Success: true, Data: map[string]any{"purged": count, "size": cc.Store.Size()}})
+}
+
+// -----------------------------------------------------------------------------------
+
+type directives map[string]string
+
+func parseDirectives(header http.Header) directives {
+	dd := directives{}
+	for _, line := range header.Values("Cache-Control") {
+		for part := range strings.SplitSeq(line, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+				dd[key] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+	}
+	return dd
+}
+
+// 请求指令， 没有 Cache-Control 时兼容 Pragma: no-cache
+func requestDirectives(header http.Header) directives {
+	dd := parseDirectives(header)
+	if len(dd) == 0 && strings.Contains(strings.ToLower(header.Get("Pragma")), "no-cache") {
+		dd["no-cache"] = ""
+	}
+	return dd
+}
+
+func (dd directives) has(key string) bool {
+	_, ok := dd[key]
+	return ok
+}
+
+func (dd directives) seconds(key string) (time.Duration, bool) {
+	val, ok := dd[key]
+	if !ok {
+		return 0, false
+	}
+	num, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || num < 0 {
+		return 0, false
+	}
+	return time.Duration(num) * time.Second, true
+}
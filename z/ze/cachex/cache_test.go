@@ -0,0 +1,219 @@
+package cachex_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/cachex"
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// go test -v z/ze/cachex/cache_test.go -run TestCache
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+	var broken atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		calls.Add(1)
+		if broken.Load() {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch rr.URL.Path {
+		case "/fresh":
+			rw.Header().Set("Cache-Control", "max-age=60")
+			rw.Header().Set("ETag", `"v1"`)
+		case "/vary":
+			rw.Header().Set("Cache-Control", "max-age=60")
+			rw.Header().Set("Vary", "Accept-Language")
+		case "/etag":
+			rw.Header().Set("Cache-Control", "no-cache, stale-if-error=60")
+			rw.Header().Set("ETag", `"v2"`)
+			if rr.Header.Get("If-None-Match") == `"v2"` {
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+			rw.Header().Set("Cache-Control", "max-age=60")
+		case "/private":
+			rw.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		rw.Write([]byte(rr.URL.Path + rr.Header.Get("Accept-Language")))
+	}))
+	defer srv.Close()
+
+	cache, err := cachex.ParseCache("store=mem;size=1m;entry=64k;stale=0s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, _ := gtw.NewTargetGatewayV2(srv.URL)
+	gw.Cacher = cache
+	call := func(method, path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		return rec
+	}
+	expect := func(rec *httptest.ResponseRecorder, code int, status string, count int32) {
+		t.Helper()
+		if rec.Code != code || !strings.Contains(rec.Header().Get("Cache-Status"), status) || calls.Load() != count {
+			t.Fatal(rec.Code, rec.Header().Get("Cache-Status"), calls.Load(), rec.Body.String())
+		}
+	}
+
+	// 缓存命中， 客户端条件请求
+	expect(call("GET", "/fresh"), 200, "fwd=uri-miss; fwd-status=200; stored", 1)
+	expect(call("GET", "/fresh"), 200, "zgg; hit", 1)
+	expect(call("GET", "/fresh", "If-None-Match", `"v1"`), 304, "hit", 1)
+	expect(call("GET", "/fresh", "Cache-Control", "no-cache"), 200, "fwd=stale", 2)
+	// Vary 变体
+	expect(call("GET", "/vary", "Accept-Language", "en"), 200, "stored", 3)
+	expect(call("GET", "/vary", "Accept-Language", "zh"), 200, "stored", 4)
+	if rec := call("GET", "/vary", "Accept-Language", "en"); rec.Body.String() != "/varyen" {
+		t.Fatal("vary", rec.Body.String())
+	}
+	expect(call("GET", "/vary", "Accept-Language", "zh"), 200, "hit", 4)
+	// 重新验证， 上游错误时使用过期缓存
+	expect(call("GET", "/etag"), 200, "stored", 5)
+	if rec := call("GET", "/etag"); rec.Code != 200 || rec.Body.String() != "/etag" {
+		t.Fatal("revalidate", rec.Code, rec.Body.String())
+	}
+	expect(call("GET", "/etag"), 200, "fwd-status=304", 7)
+	broken.Store(true)
+	expect(call("GET", "/etag"), 200, "stale-if-error", 8)
+	expect(call("GET", "/private"), 500, "fwd=miss", 9)
+	broken.Store(false)
+	expect(call("GET", "/private"), 200, "fwd=uri-miss; fwd-status=200", 10)
+	expect(call("GET", "/private"), 200, "fwd=uri-miss", 11)
+	// 不安全的方法清除缓存
+	call("POST", "/fresh")
+	expect(call("GET", "/fresh"), 200, "stored", 13)
+	// 合并请求
+	calls.Store(0)
+	wg := sync.WaitGroup{}
+	for range 5 {
+		wg.Go(func() { call("GET", "/slow") })
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatal("coalesce", calls.Load())
+	}
+	// 清除缓存
+	if n := cache.Remove("http://example.com/vary*"); n != 3 {
+		t.Fatal("purge", n)
+	}
+	expect(call("GET", "/vary", "Accept-Language", "en"), 200, "stored", 2)
+	// 只使用缓存
+	expect(call("GET", "/none", "Cache-Control", "only-if-cached"), 504, "only-if-cached", 2)
+}
+
+// go test -v z/ze/cachex/cache_test.go -run TestCacheIdentity
+func TestCacheIdentity(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		calls.Add(1)
+		switch rr.URL.Path {
+		case "/public":
+			rw.Header().Set("Cache-Control", "public, max-age=60")
+		case "/stream":
+			rw.Header().Set("Cache-Control", "max-age=60")
+			rw.WriteHeader(http.StatusOK)
+			rw.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		default:
+			rw.Header().Set("Cache-Control", "max-age=60")
+		}
+		rw.Write([]byte(rr.URL.Path))
+	}))
+	defer srv.Close()
+
+	cache, err := cachex.ParseCache("store=mem;size=1m;wait=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, _ := gtw.NewTargetGatewayV2(srv.URL)
+	gw.Cacher = cache
+	call := func(path string, header ...string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		return rec.Header().Get("Cache-Status")
+	}
+	// 带有用户身份的请求， 只保存 public 的响应
+	if status := call("/private", "Cookie", "sid=1"); strings.Contains(status, "stored") {
+		t.Fatal("expect not stored", status)
+	}
+	if status := call("/public?cookie", "Cookie", "sid=1"); !strings.Contains(status, "stored") {
+		t.Fatal("expect stored", status)
+	}
+	// 鉴权器在转发的请求中添加的用户信息
+	gw.Authorizer = &skyAuthz{}
+	if status := call("/private?sky"); strings.Contains(status, "stored") {
+		t.Fatal("expect not stored", status)
+	}
+	if status := call("/public?sky"); !strings.Contains(status, "stored") {
+		t.Fatal("expect stored", status)
+	}
+	gw.Authorizer = nil
+	// 合并请求等待超时后直接请求上游
+	calls.Store(0)
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for range 3 {
+		wg.Go(func() { call("/stream") })
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	if calls.Load() != 3 || time.Since(start) > 500*time.Millisecond {
+		t.Fatal("wait", calls.Load(), time.Since(start))
+	}
+}
+
+// 鉴权器， 在转发的请求中添加用户信息
+type skyAuthz struct{}
+
+func (skyAuthz) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
+	rr.Header.Set("X-Request-Sky-Cert-Cn", "u1")
+	return true
+}
+
+// go test -v z/ze/cachex/cache_test.go -run TestStore
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	ds, err := cachex.NewDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.Set(&cachex.Entry{Key: "example.com/a", Status: 200, Header: http.Header{"Etag": {`"a"`}}, Body: []byte("hello")})
+	// 重新加载索引
+	if ds, err = cachex.NewDiskStore(dir, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if ee := ds.Get("example.com/a"); ee == nil || string(ee.Body) != "hello" || ee.Header.Get("ETag") != `"a"` {
+		t.Fatal("disk", ee)
+	}
+	if keys := ds.Keys("example.com/"); len(keys) != 1 || !ds.Del(keys[0]) || ds.Get(keys[0]) != nil {
+		t.Fatal("disk del", keys)
+	}
+
+	// LRU 淘汰
+	ms := cachex.NewMemoryStore(250)
+	for _, key := range []string{"a", "b", "c"} {
+		ms.Set(&cachex.Entry{Key: key, Body: make([]byte, 100)})
+		ms.Get("a")
+	}
+	if ms.Get("a") == nil || ms.Get("b") != nil || ms.Get("c") == nil || ms.Size() > 250 {
+		t.Fatal("lru", ms.Size())
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package cachex
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// 缓存条目， Vary 不为空时为索引条目， 只记录 Vary 的请求头
+type Entry struct {
+	Key      string      `json:"key"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Vary     []string    `json:"vary,omitempty"`
+	ReqTime  time.Time   `json:"reqTime"`  // 请求时间
+	RespTime time.Time   `json:"respTime"` // 响应时间
+	Body     []byte      `json:"-"`
+}
+
+func (e *Entry) Size() int64 {
+	size := len(e.Key) + len(e.Body)
+	for kk, vv := range e.Header {
+		for _, v := range vv {
+			size += len(kk) + len(v) + 4
+		}
+	}
+	return int64(size)
+}
+
+// 缓存存储， 需要支持并发
+type Store interface {
+	Get(key string) *Entry
+	Set(entry *Entry)
+	Del(key string) bool
+	Keys(prefix string) []string
+	Size() int64
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Store = (*MemoryStore)(nil)
+
+// 内存存储， LRU 淘汰
+type MemoryStore struct {
+	MaxSize int64 // 最大缓存大小
+	lru     *lru
+}
+
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	return &MemoryStore{MaxSize: maxSize, lru: newLru()}
+}
+
+func (ms *MemoryStore) Get(key string) *Entry {
+	if val, ok := ms.lru.get(key); ok {
+		return val.(*Entry)
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Set(entry *Entry) {
+	ms.lru.set(entry.Key, entry, entry.Size(), ms.MaxSize, nil)
+}
+
+func (ms *MemoryStore) Del(key string) bool {
+	_, ok := ms.lru.del(key)
+	return ok
+}
+
+func (ms *MemoryStore) Keys(prefix string) []string {
+	return ms.lru.keys(prefix)
+}
+
+func (ms *MemoryStore) Size() int64 {
+	return ms.lru.total()
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Store = (*DiskStore)(nil)
+
+// 磁盘存储， 每个条目一个文件， 第一行为 json 元数据， 之后为响应体， 索引在内存中， 启动时加载
+type DiskStore struct {
+	Dir     string
+	MaxSize int64
+	lru     *lru
+}
+
+func NewDiskStore(dir string, maxSize int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ds := &DiskStore{Dir: dir, MaxSize: maxSize, lru: newLru()}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type item struct {
+		key  string
+		size int64
+		mod  time.Time
+	}
+	items := []item{}
+	for _, ff := range files {
+		if ff.IsDir() {
+			continue
+		} else if strings.HasSuffix(ff.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, ff.Name())) // 写入中断的临时文件
+			continue
+		}
+		info, err := ff.Info()
+		if err != nil {
+			continue
+		}
+		entry, err := ds.read(ff.Name(), false)
+		if err != nil || ds.file(entry.Key) != ff.Name() {
+			os.Remove(filepath.Join(dir, ff.Name())) // 损坏的文件
+			continue
+		}
+		items = append(items, item{entry.Key, info.Size(), info.ModTime()})
+	}
+	// 按修改时间加载， 最近修改的在最前
+	slices.SortFunc(items, func(a, b item) int { return a.mod.Compare(b.mod) })
+	for _, it := range items {
+		ds.lru.set(it.key, nil, it.size, ds.MaxSize, ds.remove)
+	}
+	return ds, nil
+}
+
+func (ds *DiskStore) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (ds *DiskStore) remove(key string, _ any) {
+	os.Remove(filepath.Join(ds.Dir, ds.file(key)))
+}
+
+func (ds *DiskStore) read(name string, body bool) (*Entry, error) {
+	ff, err := os.Open(filepath.Join(ds.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+	rd := bufio.NewReader(ff)
+	line, err := rd.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(line, entry); err != nil {
+		return nil, err
+	}
+	if body {
+		if entry.Body, err = io.ReadAll(rd); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (ds *DiskStore) Get(key string) *Entry {
+	if _, ok := ds.lru.get(key); !ok {
+		return nil
+	}
+	entry, err := ds.read(ds.file(key), true)
+	if err != nil {
+		z.Logn("[_cachex_]: read", key, "error,", err)
+		ds.Del(key)
+		return nil
+	}
+	return entry
+}
+
+func (ds *DiskStore) Set(entry *Entry) {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	name := ds.file(entry.Key)
+	// 先写临时文件， 避免读取到不完整的内容， 并发写入相同的 key 使用不同的临时文件
+	tmp, err := os.CreateTemp(ds.Dir, name+".*.tmp")
+	if err != nil {
+		z.Logn("[_cachex_]: write", entry.Key, "error,", err)
+		return
+	}
+	_, err = tmp.Write(slices.Concat(meta, []byte{'\n'}, entry.Body))
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(ds.Dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		z.Logn("[_cachex_]: write", entry.Key, "error,", err)
+		return
+	}
+	ds.lru.set(entry.Key, nil, int64(len(meta)+1+len(entry.Body)), ds.MaxSize, ds.remove)
+}
+
+func (ds *DiskStore) Del(key string) bool {
+	if _, ok := ds.lru.del(key); !ok {
+		return false
+	}
+	ds.remove(key, nil)
+	return true
+}
+
+func (ds *DiskStore) Keys(prefix string) []string {
+	return ds.lru.keys(prefix)
+}
+
+func (ds *DiskStore) Size() int64 {
+	return ds.lru.total()
+}
+
+// -----------------------------------------------------------------------------------
+
+type lruItem struct {
+	key  string
+	val  any
+	size int64
+}
+
+// LRU 索引， 按大小淘汰
+type lru struct {
+	items map[string]*list.Element
+	order *list.List // 最近使用的在最前
+	size  int64
+	lock  sync.Mutex
+}
+
+func newLru() *lru {
+	return &lru{items: map[string]*list.Element{}, order: list.New()}
+}
+
+func (l *lru) get(key string) (any, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if el, ok := l.items[key]; ok {
+		l.order.MoveToFront(el)
+		return el.Value.(*lruItem).val, true
+	}
+	return nil, false
+}
+
+// 加入条目， 超过 max 时淘汰最久未使用的条目， evict 在持有锁时调用
+func (l *lru) set(key string, val any, size, max int64, evict func(string, any)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if el, ok := l.items[key]; ok {
+		it := el.Value.(*lruItem)
+		l.size += size - it.size
+		it.val, it.size = val, size
+		l.order.MoveToFront(el)
+	} else {
+		l.items[key] = l.order.PushFront(&lruItem{key: key, val: val, size: size})
+		l.size += size
+	}
+	for max > 0 && l.size > max && l.order.Len() > 0 {
+		it := l.order.Remove(l.order.Back()).(*lruItem)
+		delete(l.items, it.key)
+		l.size -= it.size
+		if evict != nil {
+			evict(it.key, it.val) // 包括条目本身超过最大值
+		}
+	}
+}
+
+func (l *lru) del(key string) (any, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	it := l.order.Remove(el).(*lruItem)
+	delete(l.items, key)
+	l.size -= it.size
+	return it.val, true
+}
+
+func (l *lru) keys(prefix string) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	keys := []string{}
+	for key := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (l *lru) total() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.size
+}
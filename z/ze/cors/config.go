@@ -0,0 +1,68 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package cors
+
+import (
+	"flag"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var (
+	G = struct {
+		Server ServerConfig
+	}{}
+)
+
+// 跨域配置， 合并到 [server] 配置中
+type ServerConfig struct {
+	CorsOrigins []string          `json:"corsorigins"` // 允许的来源, 为空且无路由组时禁用
+	CorsMethods []string          `json:"corsmethods"` // 允许的方法
+	CorsHeaders []string          `json:"corsheaders"` // 允许的请求头
+	CorsExpose  []string          `json:"corsexpose"`  // 暴露的响应头
+	CorsCreds   bool              `json:"corscreds"`   // 是否允许携带凭证
+	CorsMaxAge  int               `json:"corsmaxage"`  // 预检缓存时间(秒)
+	CorsGroups  map[string]string `json:"corsgroups"`  // 路由组策略, 路径前缀 = 策略, 参考 ParsePolicy
+}
+
+func init() {
+	z.Config(&G)
+	flag.Var(z.NewStrArr(&G.Server.CorsOrigins, []string{}), "corsorigins", "cors allowed origins")
+	flag.Var(z.NewStrArr(&G.Server.CorsMethods, []string{}), "corsmethods", "cors allowed methods")
+	flag.Var(z.NewStrArr(&G.Server.CorsHeaders, []string{}), "corsheaders", "cors allowed headers")
+	flag.Var(z.NewStrArr(&G.Server.CorsExpose, []string{}), "corsexpose", "cors expose headers")
+	flag.BoolVar(&G.Server.CorsCreds, "corscreds", false, "cors allow credentials")
+	flag.IntVar(&G.Server.CorsMaxAge, "corsmaxage", 600, "cors preflight max age")
+	flag.Var(z.NewStrMap(&G.Server.CorsGroups, z.HM{}), "corsgroups", "cors route group policy")
+
+	z.Register("08-cors", func(zgg *z.Zgg) z.Closed {
+		if len(G.Server.CorsOrigins) == 0 && len(G.Server.CorsGroups) == 0 {
+			return nil
+		}
+		def := Policy{
+			Origins:     G.Server.CorsOrigins,
+			Methods:     G.Server.CorsMethods,
+			Headers:     G.Server.CorsHeaders,
+			Expose:      G.Server.CorsExpose,
+			Credentials: G.Server.CorsCreds,
+			MaxAge:      G.Server.CorsMaxAge,
+		}
+		var dcc *Cors
+		if len(def.Origins) > 0 {
+			dcc = New(def)
+		}
+		groups := map[string]*Cors{}
+		for path, str := range G.Server.CorsGroups {
+			if z.IsDebug() {
+				z.Logn("[_cors___]: group", path, "=", str)
+			}
+			groups[path] = New(ParsePolicy(str, def))
+		}
+		zgg.Filters.Add(NewGroups(dcc, groups).Filter)
+		z.Logn("[_cors___]: cors filter enabled, origins=", G.Server.CorsOrigins)
+		return nil
+	})
+}
@@ -0,0 +1,277 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 跨域资源共享(CORS)策略， 支持按路由组配置
+
+package cors
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/zc"
+)
+
+var (
+	DefMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+)
+
+// 跨域策略
+type Policy struct {
+	Origins     []string // 允许的来源, *: 所有, https://a.com: 精确, *.a.com: 子域名, ~^https://.*$: 正则
+	Methods     []string // 允许的方法, 为空使用 DefMethods
+	Headers     []string // 允许的请求头, *: 回显请求头
+	Expose      []string // 暴露的响应头
+	Credentials bool     // 是否允许携带凭证
+	MaxAge      int      // 预检结果缓存时间(秒), 0: 不设置, <0: 禁用缓存
+}
+
+// 解析路由组策略, 未指定的字段继承 def
+// origins=https://a.com|*.b.com;methods=GET|POST;headers=*;expose=X-Id;creds=true;maxage=600
+func ParsePolicy(str string, def Policy) Policy {
+	pp := def
+	for kv := range strings.SplitSeq(str, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "origins", "origin":
+			pp.Origins = splitVals(val)
+		case "methods":
+			pp.Methods = splitVals(val)
+		case "headers":
+			pp.Headers = splitVals(val)
+		case "expose":
+			pp.Expose = splitVals(val)
+		case "creds", "credentials":
+			pp.Credentials, _ = strconv.ParseBool(strings.TrimSpace(val))
+		case "maxage":
+			pp.MaxAge, _ = strconv.Atoi(strings.TrimSpace(val))
+		}
+	}
+	return pp
+}
+
+func splitVals(val string) []string {
+	vals := []string{}
+	for v := range strings.SplitSeq(val, "|") {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// -----------------------------------------------------------------------------------
+
+// 编译后的跨域策略
+type Cors struct {
+	Policy
+	anyOrigin bool
+	anyHeader bool
+	exacts    []string
+	suffixs   []string // .a.com
+	regexps   []*regexp.Regexp
+	methods   string
+	headers   string
+	exposes   string
+	maxage    string
+}
+
+func New(pp Policy) *Cors {
+	cc := &Cors{Policy: pp}
+	for _, origin := range pp.Origins {
+		switch {
+		case origin == "*":
+			cc.anyOrigin = true
+		case strings.HasPrefix(origin, "~"):
+			if rex, err := regexp.Compile(origin[1:]); err != nil {
+				z.Logn("[_cors___]: origin regexp error:", origin, err)
+			} else {
+				cc.regexps = append(cc.regexps, rex)
+			}
+		case strings.Contains(origin, "*."):
+			// https://*.a.com or *.a.com
+			cc.suffixs = append(cc.suffixs, strings.ToLower(strings.Replace(origin, "*.", ".", 1)))
+		default:
+			cc.exacts = append(cc.exacts, strings.ToLower(origin))
+		}
+	}
+	cc.Methods = slices.Clone(pp.Methods)
+	if len(cc.Methods) == 0 {
+		cc.Methods = slices.Clone(DefMethods)
+	}
+	for i, m := range cc.Methods {
+		cc.Methods[i] = strings.ToUpper(m)
+	}
+	cc.methods = strings.Join(cc.Methods, ", ")
+	cc.anyHeader = slices.Contains(pp.Headers, "*")
+	cc.headers = strings.Join(pp.Headers, ", ")
+	cc.exposes = strings.Join(pp.Expose, ", ")
+	if pp.MaxAge != 0 {
+		cc.maxage = strconv.Itoa(max(pp.MaxAge, -1))
+	}
+	return cc
+}
+
+// 判断来源是否允许
+func (cc *Cors) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if cc.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if slices.Contains(cc.exacts, lower) {
+		return true
+	}
+	for _, sfx := range cc.suffixs {
+		if sfx[0] == '.' {
+			// *.a.com, 忽略协议
+			if _, host, ok := strings.Cut(lower, "://"); ok && strings.HasSuffix(host, sfx) {
+				return true
+			}
+		} else if idx := strings.Index(sfx, "://."); idx > 0 {
+			// https://*.a.com
+			if strings.HasPrefix(lower, sfx[:idx+3]) && strings.HasSuffix(lower, sfx[idx+3:]) {
+				return true
+			}
+		}
+	}
+	for _, rex := range cc.regexps {
+		if rex.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断请求头是否允许
+func (cc *Cors) AllowHeaders(headers string) bool {
+	if cc.anyHeader || headers == "" {
+		return true
+	}
+	for hdr := range strings.SplitSeq(headers, ",") {
+		if hdr = strings.TrimSpace(hdr); hdr == "" {
+			continue
+		}
+		if !slices.ContainsFunc(cc.Headers, func(s string) bool { return zc.EqualFold(s, hdr) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// 处理跨域请求， 返回 true 表示请求已经处理完成(预检请求)
+func (cc *Cors) Handle(rw http.ResponseWriter, rr *http.Request) bool {
+	origin := rr.Header.Get("Origin")
+	preflight := rr.Method == http.MethodOptions && rr.Header.Get("Access-Control-Request-Method") != ""
+	header := rw.Header()
+	header.Add("Vary", "Origin")
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin == "" {
+		return false
+	}
+	if !cc.AllowOrigin(origin) {
+		if preflight {
+			rw.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false // 非预检请求， 不增加跨域头， 由浏览器拦截
+	}
+	if cc.anyOrigin && !cc.Credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if cc.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if cc.exposes != "" {
+			header.Set("Access-Control-Expose-Headers", cc.exposes)
+		}
+		return false
+	}
+	// 预检请求
+	method := strings.ToUpper(rr.Header.Get("Access-Control-Request-Method"))
+	reqHeaders := rr.Header.Get("Access-Control-Request-Headers")
+	if !slices.Contains(cc.Methods, method) || !cc.AllowHeaders(reqHeaders) {
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+		rw.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	header.Set("Access-Control-Allow-Methods", cc.methods)
+	if cc.anyHeader {
+		if reqHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+	} else if cc.headers != "" {
+		header.Set("Access-Control-Allow-Headers", cc.headers)
+	}
+	if cc.maxage != "" {
+		header.Set("Access-Control-Max-Age", cc.maxage)
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// -----------------------------------------------------------------------------------
+
+// 路由组策略， 按照路径前缀匹配, 最长前缀优先
+type Groups struct {
+	Default *Cors
+	Prefixs []string
+	Polices map[string]*Cors
+}
+
+func NewGroups(def *Cors, groups map[string]*Cors) *Groups {
+	gs := &Groups{Default: def, Polices: groups}
+	for key := range groups {
+		gs.Prefixs = append(gs.Prefixs, key)
+	}
+	// 最长前缀优先
+	slices.SortFunc(gs.Prefixs, func(a, b string) int { return len(b) - len(a) })
+	return gs
+}
+
+// 获取路径对应的策略
+func (gs *Groups) Match(path string) *Cors {
+	for _, prefix := range gs.Prefixs {
+		if z.HasPathPrefix(path, prefix) {
+			return gs.Polices[prefix]
+		}
+	}
+	return gs.Default
+}
+
+// 跨域过滤器， 对所有路由引擎生效
+func (gs *Groups) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		if cc := gs.Match(rr.URL.Path); cc != nil && cc.Handle(rw, rr) {
+			return
+		}
+		next.ServeHTTP(rw, rr)
+	})
+}
+
+// 对单个路由增加跨域处理， 注意， 预检请求(OPTIONS)需要路由可以匹配
+func Wrap(pp Policy, handle z.HandleFunc) z.HandleFunc {
+	cc := New(pp)
+	return func(ctx *z.Ctx) {
+		if cc.Handle(ctx.Writer, ctx.Request) {
+			ctx.Abort()
+			return
+		}
+		handle(ctx)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/suisrc/zgg/z/ze/cors"
+)
+
+// go test -v z/ze/cors/cors_test.go -run Test_origin
+
+func Test_origin(t *testing.T) {
+	cc := cors.New(cors.Policy{Origins: []string{"https://a.com", "*.b.com", "https://*.c.com", `~^http://d\d+\.com$`}})
+	for origin, want := range map[string]bool{
+		"https://a.com":     true,
+		"https://A.com":     true,
+		"http://a.com":      false,
+		"https://x.b.com":   true,
+		"http://x.y.b.com":  true,
+		"https://b.com":     false,
+		"https://x.c.com":   true,
+		"http://x.c.com":    false,
+		"http://d12.com":    true,
+		"http://dx.com":     false,
+		"https://evilb.com": false,
+	} {
+		if got := cc.AllowOrigin(origin); got != want {
+			t.Errorf("origin %s: got %v, want %v", origin, got, want)
+		}
+	}
+}
+
+// go test -v z/ze/cors/cors_test.go -run Test_preflight
+
+func Test_preflight(t *testing.T) {
+	def := cors.Policy{Origins: []string{"https://a.com"}, Headers: []string{"Content-Type"}, MaxAge: 600}
+	groups := cors.NewGroups(cors.New(def), map[string]*cors.Cors{
+		"/api/open": cors.New(cors.ParsePolicy("origins=*;methods=GET;headers=*", def)),
+	})
+	next := http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) { rw.WriteHeader(http.StatusTeapot) })
+	handler := groups.Filter(next)
+
+	// 预检通过
+	req := httptest.NewRequest(http.MethodOptions, "/api/user", nil)
+	req.Header.Set("Origin", "https://a.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://a.com" ||
+		rec.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("preflight: %d %v", rec.Code, rec.Header())
+	}
+
+	// 请求头不允许
+	req.Header.Set("Access-Control-Request-Headers", "X-Other")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("preflight headers: %d", rec.Code)
+	}
+
+	// 路由组策略
+	req = httptest.NewRequest(http.MethodOptions, "/api/open/list", nil)
+	req.Header.Set("Origin", "https://x.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Any")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" ||
+		rec.Header().Get("Access-Control-Allow-Headers") != "X-Any" {
+		t.Fatalf("group preflight: %d %v", rec.Code, rec.Header())
+	}
+
+	// 简单请求
+	req = httptest.NewRequest(http.MethodGet, "/api/user", nil)
+	req.Header.Set("Origin", "https://a.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTeapot || rec.Header().Get("Access-Control-Allow-Origin") != "https://a.com" {
+		t.Fatalf("simple: %d %v", rec.Code, rec.Header())
+	}
+}
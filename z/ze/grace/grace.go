@@ -0,0 +1,175 @@
+//go:build unix
+
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 平滑升级， 收到 SIGUSR2 信号后， 启动新的进程并传递监听器， 新进程就绪后， 旧进程停止接收请求并退出
+
+package grace
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/proc"
+)
+
+var (
+	G = struct {
+		Server ServerConfig
+	}{}
+)
+
+// 平滑升级配置， 合并到 [server] 配置中
+type ServerConfig struct {
+	Grace   bool `json:"grace"`   // 启用平滑升级, 收到 SIGUSR2 信号后升级
+	GraceTm int  `json:"gracetm"` // 等待子进程就绪的超时时间(秒)
+}
+
+// 子进程就绪通知的文件描述符
+const EnvReadyFd = "ZGG_READY_FD"
+
+func init() {
+	z.Config(&G)
+	flag.BoolVar(&G.Server.Grace, "grace", false, "graceful upgrade by SIGUSR2")
+	flag.IntVar(&G.Server.GraceTm, "gracetm", 30, "graceful upgrade ready timeout(s)")
+
+	z.Register("92-grace", func(zgg *z.Zgg) z.Closed {
+		// 子进程， 启动后通知父进程
+		if fd := os.Getenv(EnvReadyFd); fd != "" {
+			os.Unsetenv(EnvReadyFd)
+			zgg.Started.Add(func() { NotifyReady(fd) })
+		}
+		if !G.Server.Grace {
+			return nil
+		}
+		if zgg.Signals == nil {
+			zgg.Signals = map[os.Signal]z.SignalFunc{}
+		}
+		zgg.Signals[syscall.SIGUSR2] = func(sig os.Signal) bool {
+			z.Logn("[_grace__]: upgrade by signal", sig)
+			if err := Upgrade(zgg, time.Duration(G.Server.GraceTm)*time.Second); err != nil {
+				z.Logn("[_grace__]: upgrade error:", err)
+				return false // 继续服务
+			}
+			z.Logn("[_grace__]: upgrade succ, shutting down the old process")
+			return true
+		}
+		return nil
+	})
+}
+
+// 通知父进程已经就绪
+func NotifyReady(fd string) {
+	num, err := strconv.Atoi(fd)
+	if err != nil {
+		z.Logn("[_grace__]: ready fd error:", fd)
+		return
+	}
+	file := os.NewFile(uintptr(num), "ready")
+	if file == nil {
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte("ready")); err != nil {
+		z.Logn("[_grace__]: notify ready error:", err)
+	}
+}
+
+// 启动新的进程， 传递监听器， 等待新进程就绪
+func Upgrade(zgg *z.Zgg, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	files := []*os.File{}
+	fdmap := []string{}
+	unixs := []*net.UnixListener{}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, srv := range zgg.Servers {
+		ls, ok := srv.(z.Listeners)
+		if !ok {
+			continue
+		}
+		for spec, ln := range ls.Listeners() {
+			fl, ok := ln.(interface{ File() (*os.File, error) })
+			if !ok {
+				return fmt.Errorf("listener not support file: %s", spec)
+			}
+			file, err := fl.File()
+			if err != nil {
+				return err
+			}
+			if ul, ok := ln.(*net.UnixListener); ok {
+				unixs = append(unixs, ul)
+			}
+			fdmap = append(fdmap, spec+"="+strconv.Itoa(3+len(files)))
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return errors.New("no listener to upgrade")
+	}
+	slices.Sort(fdmap)
+	// 就绪通知管道
+	rp, wp, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rp.Close()
+	files = append(files, wp)
+	env := []string{
+		z.EnvListenFds + "=" + strings.Join(fdmap, ";"),
+		EnvReadyFd + "=" + strconv.Itoa(2+len(files)),
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	child := proc.NewProcessOpts(os.Stdout, proc.Options{Env: env, ExtraFiles: files, Detached: true}, exe, os.Args[1:]...)
+	if err := child.Start(); err != nil {
+		return err
+	}
+	z.Logn("[_grace__]: child process started, pid:", child.Pid(), "listeners:", fdmap)
+	wp.Close() // 父进程关闭写端， 子进程异常退出时读端可以收到 EOF
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(rp, buf)
+		if err == nil && string(buf) != "ready" {
+			err = errors.New("unexpected ready message")
+		}
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = errors.New("wait child ready timeout")
+	}
+	if err != nil {
+		child.Stop(5 * time.Second)
+		return err
+	}
+	// socket 文件由子进程继续使用， 关闭时不能删除
+	for _, ul := range unixs {
+		ul.SetUnlinkOnClose(false)
+	}
+	return nil
+}
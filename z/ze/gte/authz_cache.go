@@ -0,0 +1,288 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gte
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// 验证结果
+type AuthzDecision struct {
+	Allow   bool
+	Status  int
+	Header  http.Header // 通过: X- 请求头和 Set-Cookie, 拒绝: 响应头
+	Body    []byte
+	TTL     time.Duration  // Cache-Control: max-age, 小于 0 表示未指定
+	Stale   time.Duration  // Cache-Control: stale-while-revalidate
+	NoStore bool           // Cache-Control: no-store, no-cache
+	outreq  *http.Request  // 只有实际请求的结果才有， 用于记录日志
+	resp    *http.Response // 只有实际请求的结果才有， 用于记录日志
+}
+
+// 解析 Cache-Control, 返回 max-age, stale-while-revalidate, no-store
+func ParseCacheControl(str string) (time.Duration, time.Duration, bool) {
+	ttl, stale, nostore := time.Duration(-1), time.Duration(0), false
+	for item := range strings.SplitSeq(str, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch strings.ToLower(key) {
+		case "no-store", "no-cache":
+			nostore = true
+		case "max-age", "s-maxage":
+			if sec, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil {
+				ttl = time.Duration(sec) * time.Second
+			}
+		case "stale-while-revalidate":
+			if sec, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil {
+				stale = time.Duration(sec) * time.Second
+			}
+		}
+	}
+	return ttl, stale, nostore
+}
+
+// -----------------------------------------------------------------------------------
+
+type authzEntry struct {
+	dd      *AuthzDecision
+	expires time.Time // 过期时间
+	staleAt time.Time // 过期后仍可使用的时间， 后台刷新
+	refresh bool      // 正在后台刷新
+}
+
+type authzCall struct {
+	wg  sync.WaitGroup
+	dd  *AuthzDecision
+	err error
+}
+
+// 验证结果缓存， 相同的 key 并发请求只请求一次
+type AuthzCache struct {
+	Keys     []string      // 缓存 key 的组成: header:Name, cookie:Name, method, action, host, path, query
+	TTL      time.Duration // 通过结果的默认缓存时间
+	NegTTL   time.Duration // 拒绝结果(401, 403)的默认缓存时间, 0 不缓存
+	Stale    time.Duration // 默认 stale-while-revalidate 时间
+	Size     int           // 最大缓存数量
+	FailOpen bool          // 验证服务不可用时放行
+	entries  map[string]*authzEntry
+	calls    map[string]*authzCall
+	lock     sync.Mutex
+}
+
+func NewAuthzCache() *AuthzCache {
+	return &AuthzCache{
+		Keys:    []string{"header:Authorization", "header:Cookie", "method", "host", "path", "query", "action"},
+		TTL:     10 * time.Second,
+		NegTTL:  2 * time.Second,
+		Size:    10000,
+		entries: map[string]*authzEntry{},
+		calls:   map[string]*authzCall{},
+	}
+}
+
+// 解析缓存规则, 格式: key=header:Authorization|cookie:kst|method|path|query|action;ttl=10s;neg=2s;stale=30s;size=10000;fail=open
+// 验证服务根据路径和查询参数鉴权， key 需要包含 path 和 query, 否则不同的路径共享验证结果
+// fail=open 只在连接错误和超时时放行， 验证服务返回 5xx 时不放行
+func ParseAuthzCache(str string) (*AuthzCache, error) {
+	ac := NewAuthzCache()
+	for kv := range strings.SplitSeq(str, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		var err error
+		switch key {
+		case "":
+			continue
+		case "key":
+			ac.Keys = strings.Split(val, "|")
+			for _, kk := range ac.Keys {
+				kind, name, _ := strings.Cut(kk, ":")
+				switch kind {
+				case "header", "cookie":
+					if name == "" {
+						err = errors.New("key name is empty")
+					}
+				case "method", "action", "host", "path", "query":
+				default:
+					err = errors.New("unknow key " + kk)
+				}
+			}
+		case "ttl":
+			ac.TTL, err = time.ParseDuration(val)
+		case "neg":
+			ac.NegTTL, err = time.ParseDuration(val)
+		case "stale":
+			ac.Stale, err = time.ParseDuration(val)
+		case "size":
+			ac.Size, err = strconv.Atoi(val)
+		case "fail":
+			switch val {
+			case "open":
+				ac.FailOpen = true
+			case "closed", "close":
+				ac.FailOpen = false
+			default:
+				err = errors.New("fail must be open or closed")
+			}
+		default:
+			err = errors.New("unknow field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("authz cache parse %s error: %v", kv, err)
+		}
+	}
+	return ac, nil
+}
+
+// 缓存 key, 使用 sha256 避免令牌明文存储在内存中
+func (ac *AuthzCache) Key(rr *http.Request) string {
+	hash := sha256.New()
+	for _, kk := range ac.Keys {
+		kind, name, _ := strings.Cut(kk, ":")
+		val := ""
+		switch kind {
+		case "header":
+			val = strings.Join(rr.Header.Values(name), ",")
+		case "cookie":
+			if ck, err := rr.Cookie(name); err == nil {
+				val = ck.Value
+			}
+		case "method":
+			val = rr.Method
+		case "action":
+			val = gtw.GetAction(rr.URL)
+		case "host":
+			val = rr.Host
+		case "path":
+			val = rr.URL.Path
+		case "query":
+			val = rr.URL.RawQuery
+		}
+		hash.Write([]byte(val))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// 获取验证结果， 缓存未命中时调用 fn, 过期但在 stale 时间内时返回旧结果并后台刷新
+// fn 返回错误时， 如果存在旧结果(stale)则返回旧结果
+func (ac *AuthzCache) Do(key string, fn func() (*AuthzDecision, error)) (*AuthzDecision, error) {
+	now := time.Now()
+	ac.lock.Lock()
+	if ent := ac.entries[key]; ent != nil {
+		if now.Before(ent.expires) {
+			ac.lock.Unlock()
+			return ent.dd, nil
+		}
+		if now.Before(ent.staleAt) {
+			if !ent.refresh {
+				ent.refresh = true
+				go ac.call(key, fn)
+			}
+			ac.lock.Unlock()
+			return ent.dd, nil
+		}
+	}
+	ac.lock.Unlock()
+	return ac.call(key, fn)
+}
+
+// 合并相同 key 的请求
+func (ac *AuthzCache) call(key string, fn func() (*AuthzDecision, error)) (*AuthzDecision, error) {
+	ac.lock.Lock()
+	if cc := ac.calls[key]; cc != nil {
+		ac.lock.Unlock()
+		cc.wg.Wait()
+		return cc.dd, cc.err
+	}
+	cc := &authzCall{}
+	cc.wg.Add(1)
+	ac.calls[key] = cc
+	ac.lock.Unlock()
+
+	dd, err := fn()
+	ac.lock.Lock()
+	delete(ac.calls, key)
+	if err != nil {
+		// 验证服务异常， 使用旧结果
+		if ent := ac.entries[key]; ent != nil && time.Now().Before(ent.staleAt) {
+			ent.refresh = false
+			cc.dd, cc.err = ent.dd, nil
+			ac.lock.Unlock()
+			cc.wg.Done()
+			return ent.dd, nil
+		}
+		cc.err = err
+	} else {
+		// 合并的请求和缓存使用副本， 不包含日志信息
+		cc.dd = dd.clone()
+		ac.store(key, cc.dd)
+	}
+	ac.lock.Unlock()
+	cc.wg.Done()
+	return dd, err
+}
+
+// 保存结果， 需要持有锁
+func (ac *AuthzCache) store(key string, dd *AuthzDecision) {
+	ttl, stale := dd.TTL, dd.Stale
+	if ttl < 0 {
+		if dd.Allow {
+			ttl = ac.TTL
+		} else {
+			ttl = ac.NegTTL
+		}
+	}
+	if stale == 0 {
+		stale = ac.Stale
+	}
+	if dd.NoStore || ttl <= 0 || !dd.Allow && dd.Status != http.StatusUnauthorized && dd.Status != http.StatusForbidden {
+		delete(ac.entries, key)
+		return
+	}
+	now := time.Now()
+	if len(ac.entries) >= ac.Size {
+		for kk, ent := range ac.entries {
+			if now.After(ent.staleAt) {
+				delete(ac.entries, kk)
+			}
+		}
+		if len(ac.entries) >= ac.Size {
+			for kk := range ac.entries {
+				delete(ac.entries, kk) // 随机淘汰
+				if len(ac.entries) < ac.Size {
+					break
+				}
+			}
+		}
+	}
+	ac.entries[key] = &authzEntry{dd: dd, expires: now.Add(ttl), staleAt: now.Add(ttl + stale)}
+}
+
+// 清空缓存
+func (ac *AuthzCache) Clear() {
+	ac.lock.Lock()
+	ac.entries = map[string]*authzEntry{}
+	ac.lock.Unlock()
+}
+
+func (dd *AuthzDecision) clone() *AuthzDecision {
+	cp := *dd
+	cp.outreq, cp.resp = nil, nil
+	if cp.Allow {
+		cp.Header = dd.Header.Clone()
+		cp.Header.Del("Set-Cookie") // 不缓存 Set-Cookie
+	}
+	return &cp
+}
@@ -0,0 +1,168 @@
+package gte_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gte"
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// go test -v z/ze/gte/authz_cache_test.go -run TestAuthzCache
+func TestAuthzCache(t *testing.T) {
+	var calls int32
+	var delay atomic.Int64
+	authz := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Duration(delay.Load()))
+		switch rr.Header.Get("Authorization") {
+		case "ok":
+			rw.Header().Set("X-Request-Sky-Authorize", "e30=")
+			rw.Header().Set("X-Request-Sky-UserId", "u1")
+		case "nostore":
+			rw.Header().Set("Cache-Control", "no-store")
+			rw.Header().Set("X-Request-Sky-Authorize", "e30=")
+		default:
+			rw.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer authz.Close()
+	gw, _ := gtw.NewTargetGatewayV2("http://127.0.0.1:1")
+
+	cache, err := gte.ParseAuthzCache("key=header:Authorization|method;ttl=100ms;neg=1s;stale=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	az := gte.NewAuthzF1kin(nil, authz.URL, false).(*gte.AuthzF1kin)
+	az.Cache = cache
+	check := func(auth string) (bool, *http.Request, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		return az.Authz(gw, rec, req, nil), req, rec
+	}
+	expect := func(n int32) {
+		t.Helper()
+		if cnt := atomic.LoadInt32(&calls); cnt != n {
+			t.Fatal("calls", cnt, "!=", n)
+		}
+	}
+	for range 3 {
+		if ok, req, _ := check("ok"); !ok || req.Header.Get("X-Request-Sky-UserId") != "u1" {
+			t.Fatal("expect allow")
+		}
+	}
+	expect(1)
+	// 拒绝结果缓存
+	for range 2 {
+		if ok, _, rec := check("bad"); ok || rec.Code != http.StatusUnauthorized {
+			t.Fatal("expect deny", rec.Code)
+		}
+	}
+	expect(2)
+	// no-store 不缓存
+	check("nostore")
+	check("nostore")
+	expect(4)
+	// 过期后返回旧结果， 后台刷新
+	time.Sleep(150 * time.Millisecond)
+	if ok, req, _ := check("ok"); !ok || req.Header.Get("X-Request-Sky-UserId") != "u1" {
+		t.Fatal("expect stale allow")
+	}
+	for i := 0; atomic.LoadInt32(&calls) != 5 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect(5)
+
+	// 并发请求合并
+	delay.Store(int64(50 * time.Millisecond))
+	cache.Clear()
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Go(func() {
+			if ok, _, _ := check("ok"); !ok {
+				t.Error("expect allow")
+			}
+		})
+	}
+	wg.Wait()
+	expect(6)
+
+	// 验证服务不可用
+	authz.Close()
+	if ok, _, _ := check("ok"); !ok {
+		t.Fatal("expect allow from cache")
+	}
+	if ok, _, rec := check("other"); ok || rec.Code != http.StatusBadGateway {
+		t.Fatal("expect fail closed", rec.Code)
+	}
+	cache.FailOpen = true
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("Authorization", "other")
+	req.Header.Set("X-Request-Sky-UserId", "admin")
+	if !az.Authz(gw, httptest.NewRecorder(), req, nil) {
+		t.Fatal("expect fail open")
+	}
+	if req.Header.Get("X-Request-Sky-UserId") != "" {
+		t.Fatal("expect strip sky header on fail open")
+	}
+}
+
+// go test -v z/ze/gte/authz_cache_test.go -run TestAuthzCacheDefault
+func TestAuthzCacheDefault(t *testing.T) {
+	var calls int32
+	authz := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch rr.Header.Get("X-Request-Origin-Path") {
+		case "/error":
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case "/admin":
+			rw.WriteHeader(http.StatusForbidden)
+		default:
+			rw.Header().Set("X-Request-Sky-Authorize", "e30=")
+			rw.Header().Set("X-Request-Sky-UserId", "u1")
+		}
+	}))
+	defer authz.Close()
+	gw, _ := gtw.NewTargetGatewayV2("http://127.0.0.1:1")
+
+	cache, err := gte.ParseAuthzCache("fail=open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	az := gte.NewAuthzF1kin(nil, authz.URL, false).(*gte.AuthzF1kin)
+	az.Cache = cache
+	check := func(path string) (bool, *http.Request, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "ok")
+		req.Header.Set("X-Request-Sky-UserId", "admin")
+		rec := httptest.NewRecorder()
+		return az.Authz(gw, rec, req, nil), req, rec
+	}
+	// 不同的路径和查询参数不共享验证结果
+	ok, req, _ := check("/api")
+	if !ok || req.Header.Get("X-Request-Sky-UserId") != "u1" {
+		t.Fatal("expect allow")
+	}
+	req.Header[http.CanonicalHeaderKey("X-Request-Sky-UserId")][0] = "changed"
+	if ok, req, _ := check("/api"); !ok || req.Header.Get("X-Request-Sky-UserId") != "u1" {
+		t.Fatal("expect cached header not shared", req.Header.Get("X-Request-Sky-UserId"))
+	}
+	if ok, _, rec := check("/admin"); ok || rec.Code != http.StatusForbidden {
+		t.Fatal("expect deny other path", rec.Code)
+	}
+	if ok, _, _ := check("/api?a=1"); !ok {
+		t.Fatal("expect allow")
+	}
+	if cnt := atomic.LoadInt32(&calls); cnt != 3 {
+		t.Fatal("calls", cnt, "!= 3")
+	}
+	// 5xx 不触发 fail=open
+	if ok, _, rec := check("/error"); ok || rec.Code != http.StatusServiceUnavailable {
+		t.Fatal("expect fail closed on 5xx", rec.Code)
+	}
+}
@@ -7,13 +7,17 @@ package gte
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/jwtx"
 )
 
 // 鉴权器， 为 f1kin 系统定制的验证器
@@ -39,9 +43,19 @@ type AuthzF1kin struct {
 	gtw.AuthRecord
 	AuthzServe string       // 验证服务器
 	AllowSkipz bool         // 允许跳过验证
+	Cache      *AuthzCache  // 验证结果缓存， 为空不缓存
 	client     *http.Client // 请求客户端
 }
 
+// 验证服务返回 5xx, 没有旧结果时直接返回验证服务的响应， 不触发 fail=open
+type authzStatusError struct {
+	dd *AuthzDecision
+}
+
+func (ee *authzStatusError) Error() string {
+	return fmt.Sprintf("authz serve status %d", ee.dd.Status)
+}
+
 func (aa *AuthzF1kin) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
 	aa.AuthRecord.Authz(gw, rw, rr, rt)
 	// 通过验证服务器进行验证， 适配 FMES(f1kin) 平台
@@ -55,8 +69,6 @@ func (aa *AuthzF1kin) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Re
 	// if ainfo := rr.Header.Get("X-Request-Sky-Authorize"); ainfo != "" {
 	// 	return true // 已验证 ？？？
 	// }
-	ctx, cancel := context.WithTimeout(rr.Context(), 3*time.Second)
-	defer cancel() // 验证需要在 3s 完成，以防止后面业务阻塞
 	// -------- 处理验证地址 --------
 	auz := aa.AuthzServe
 	if rr.URL.RawQuery != "" {
@@ -81,16 +93,80 @@ func (aa *AuthzF1kin) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Re
 		return false
 	}
 	// -------- 处理远程验证 --------
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, auz, nil)
+	var dd *AuthzDecision
+	var err error
+	if aa.Cache != nil {
+		// 使用缓存， 请求与客户端请求分离， 避免合并的请求被取消， 后台刷新时请求头已经被修改
+		src := rr.Clone(context.Background())
+		dd, err = aa.Cache.Do(aa.Cache.Key(rr), func() (*AuthzDecision, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			dd, err := aa.request(ctx, src, auz)
+			if err == nil && dd.Status >= 500 {
+				return nil, &authzStatusError{dd: dd} // 服务异常， 使用旧结果
+			}
+			return dd, err
+		})
+		if se := (*authzStatusError)(nil); errors.As(err, &se) {
+			dd, err = se.dd, nil
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(rr.Context(), 3*time.Second)
+		defer cancel() // 验证需要在 3s 完成，以防止后面业务阻塞
+		dd, err = aa.request(ctx, rr, auz)
+	}
 	if err != nil {
-		msg := "error in authzf1kin, new request context, " + err.Error()
-		gw.Logf(msg + "\n")
-		rw.WriteHeader(http.StatusInternalServerError)
+		if aa.Cache != nil && aa.Cache.FailOpen {
+			// 只有连接错误和超时放行， 删除客户端伪造的用户信息
+			gw.Logf("error in authzf1kin, request authz serve, fail open, %s\n", err.Error())
+			jwtx.StripHeaders(rr)
+			return true
+		}
+		gw.GetErrorHandler()(rw, rr, err)
 		if rt != nil {
-			rt.SetRespBody([]byte("###" + msg))
+			rt.SetRespBody([]byte("###error authzf1kin, request authz serve, " + err.Error()))
 		}
 		return false
 	}
+	// -------- 处理验证结果 --------
+	if !dd.Allow {
+		// 验证失败，返回结果, 记录返回日志
+		if rt != nil && dd.outreq != nil {
+			rt.LogOutRequest(dd.outreq) // 带有的请求信息，用于记录
+			rt.LogResponse(dd.resp)     // 带有的响应信息，用于记录
+			rt.LogRespBody(int64(len(dd.Body)), nil, dd.Body)
+		}
+		// 认证结果返回
+		dst := rw.Header()
+		for k, vv := range dd.Header {
+			for _, v := range vv {
+				dst.Add(k, v)
+			}
+		}
+		rw.WriteHeader(dd.Status)
+		rw.Write(dd.Body)
+		return false
+	}
+	// -------- 处理验证成功 --------
+	// Set-Cookie 传递给 rw | X- 开头的 header 传递给 rr
+	for kk, vv := range dd.Header {
+		if kk == "Set-Cookie" {
+			for _, v := range vv {
+				rw.Header().Add("Set-Cookie", v)
+			}
+		} else {
+			rr.Header[kk] = slices.Clone(vv) // 缓存的结果共享， 使用副本
+		}
+	}
+	return true
+}
+
+// 请求验证服务器
+func (aa *AuthzF1kin) request(ctx context.Context, rr *http.Request, auz string) (*AuthzDecision, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, auz, nil)
+	if err != nil {
+		return nil, err
+	}
 	// 处理 header
 	gtw.CopyHeader(req.Header, rr.Header)
 	req.Header.Set("X-Request-Origin-Host", rr.Host)
@@ -102,49 +178,30 @@ func (aa *AuthzF1kin) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Re
 	// 请求远程鉴权服务器
 	resp, err := aa.client.Do(req)
 	if err != nil {
-		gw.GetErrorHandler()(rw, req, err)
-		if rt != nil {
-			rt.SetRespBody([]byte("###error authzf1kin, request authz serve, " + err.Error()))
-		}
-		return false
+		return nil, err
 	}
 	defer resp.Body.Close()
-	// -------- 处理验证结果 --------
+	dd := &AuthzDecision{Status: resp.StatusCode, Header: http.Header{}, outreq: req, resp: resp}
+	dd.TTL, dd.Stale, dd.NoStore = ParseCacheControl(resp.Header.Get("Cache-Control"))
 	if resp.StatusCode >= 300 || resp.Header.Get("X-Request-Sky-Authorize") == "" {
-		// 验证失败，返回结果
-		body, _ := io.ReadAll(resp.Body)
-		// 记录返回日志
-		if rt != nil {
-			rt.LogOutRequest(req) // 带有的请求信息，用于记录
-			rt.LogResponse(resp)  // 带有的响应信息，用于记录
-			rt.LogRespBody(int64(len(body)), nil, body)
-		}
-		// 认证结果返回
-		dst := rw.Header()
+		dd.Body, _ = io.ReadAll(resp.Body)
 		// 过滤 X-Request- , 其他的传递给 rw
 		for k, vv := range resp.Header {
 			if gtw.HasPrefixFold(k, "X-Request-Sky-") {
 				continue // 忽略用户信息 // X-Debug-Force-User 会触发 X-Request-Sky-Authorize 强制返回
 			}
-			for _, v := range vv {
-				dst.Add(k, v)
-			}
+			dd.Header[k] = vv
 		}
-		rw.WriteHeader(resp.StatusCode)
-		rw.Write(body)
-		return false
+		return dd, nil
 	}
-	// -------- 处理验证成功 --------
-	// Set-Cookie 传递给 rw | X- 开头的 header 传递给 rr
+	dd.Allow = true
 	for kk, vv := range resp.Header {
 		if gtw.HasPrefixFold(kk, "X-") {
-			rr.Header[kk] = vv
+			dd.Header[kk] = vv
 		}
 	}
 	if sc, ok := resp.Header["Set-Cookie"]; ok {
-		for _, vv := range sc {
-			rw.Header().Add("Set-Cookie", vv)
-		}
+		dd.Header["Set-Cookie"] = sc
 	}
-	return true
+	return dd, nil
 }
@@ -0,0 +1,38 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gte
+
+import (
+	"net/http"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/hmacx"
+)
+
+// 鉴权器， 验证 HMAC 请求签名， 用于服务间调用， 密钥 ID 写入 X-Request-Sky-SignKey 请求头
+func NewAuthzHmacx(sites []string, verifier *hmacx.Verifier) gtw.Authorizer {
+	return &AuthzHmacx{
+		AuthRecord: gtw.NewAuthRecord(sites),
+		Verifier:   verifier,
+	}
+}
+
+type AuthzHmacx struct {
+	gtw.AuthRecord
+	Verifier *hmacx.Verifier
+}
+
+func (aa *AuthzHmacx) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
+	aa.AuthRecord.Authz(gw, rw, rr, rt)
+	if _, err := aa.Verifier.Authz(rr); err != nil {
+		if rt != nil {
+			rt.SetRespBody([]byte("###error authzhmacx, " + err.Error()))
+		}
+		aa.Verifier.Reject(rr, rw, err)
+		return false
+	}
+	return true
+}
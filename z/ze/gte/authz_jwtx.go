@@ -0,0 +1,39 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gte
+
+import (
+	"net/http"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/jwtx"
+)
+
+// 鉴权器， 本地验证 JWT 令牌， 声明写入 X-Request-Sky-* 请求头
+func NewAuthzJwtx(sites []string, validator *jwtx.Validator) gtw.Authorizer {
+	return &AuthzJwtx{
+		AuthRecord: gtw.NewAuthRecord(sites),
+		Validator:  validator,
+	}
+}
+
+// 通过 JWT 验证权限
+type AuthzJwtx struct {
+	gtw.AuthRecord
+	Validator *jwtx.Validator
+}
+
+func (aa *AuthzJwtx) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
+	aa.AuthRecord.Authz(gw, rw, rr, rt)
+	if _, err := aa.Validator.Authz(rr); err != nil {
+		if rt != nil {
+			rt.SetRespBody([]byte("###error authzjwtx, " + err.Error()))
+		}
+		aa.Validator.Reject(rr, rw, err)
+		return false
+	}
+	return true
+}
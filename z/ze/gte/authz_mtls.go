@@ -0,0 +1,39 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gte
+
+import (
+	"net/http"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/tlsx"
+)
+
+// 鉴权器， 验证客户端证书身份， 身份写入 X-Request-Sky-Cert-* 请求头
+// 证书链由服务的 tls.Config 验证(clientca, clientauth)， 这里只检查身份是否允许
+func NewAuthzMtls(sites []string, verifier *tlsx.ClientVerifier) gtw.Authorizer {
+	return &AuthzMtls{
+		AuthRecord: gtw.NewAuthRecord(sites),
+		Verifier:   verifier,
+	}
+}
+
+type AuthzMtls struct {
+	gtw.AuthRecord
+	Verifier *tlsx.ClientVerifier
+}
+
+func (aa *AuthzMtls) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
+	aa.AuthRecord.Authz(gw, rw, rr, rt)
+	if _, err := aa.Verifier.Authz(rr); err != nil {
+		if rt != nil {
+			rt.SetRespBody([]byte("###error authzmtls, " + err.Error()))
+		}
+		aa.Verifier.Reject(rr, rw, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,345 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gte
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/jwtx"
+	"github.com/suisrc/zgg/z/ze/sess"
+)
+
+// 登出使用的 CSRF 令牌， 登录后通过请求头传递给上游服务， 登出表单使用 _csrf 字段或者 X-CSRF-Token 请求头提交
+const OidcxCSRFHeader = "X-Request-Sky-Csrf"
+
+// 鉴权器， OIDC 登录(授权码 + PKCE)， 网关作为依赖方完成登录， 用户信息写入 X-Request-Sky-* 请求头
+// 登录状态使用加密的 cookie 保存， domain 为 SiteHosts 中匹配的站点
+func NewAuthzOidcx(sites []string, issuer, clientID, clientSecret string, secret []byte) (*AuthzOidcx, error) {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: gtw.TransportGtw}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pvd, err := jwtx.Discover(ctx, client, issuer)
+	if err != nil {
+		return nil, err
+	}
+	store, err := sess.NewCookieStore(secret)
+	if err != nil {
+		return nil, err
+	}
+	keys := jwtx.NewRemoteKeySet(pvd.JwksURL)
+	keys.Client = client
+	validator := jwtx.NewValidator(keys)
+	validator.Issuer, validator.Audience = pvd.Issuer, []string{clientID}
+	return &AuthzOidcx{
+		AuthRecord: gtw.NewAuthRecord(sites),
+		OAuth2:     &jwtx.OAuth2{Provider: pvd, ClientID: clientID, ClientSecret: clientSecret, Client: client},
+		Validator:  validator,
+		Store:      store,
+		CookieKey:  "_zo",
+		Callback:   "/oauth2/callback",
+		SignOut:    "/oauth2/logout",
+		MaxAge:     8 * time.Hour,
+	}, nil
+}
+
+type AuthzOidcx struct {
+	gtw.AuthRecord
+	OAuth2    *jwtx.OAuth2
+	Validator *jwtx.Validator   // ID 令牌验证， Headers 为声明映射
+	Store     *sess.CookieStore // 登录状态加密
+	CookieKey string            // 登录状态 cookie, 授权过程使用 CookieKey + "_s_" + state 前缀
+	Callback  string            // 回调路径， 保留路径， 不转发
+	SignOut   string            // 登出路径， 保留路径， 不转发， 只允许 POST 并验证 CSRF 令牌
+	Redirect  string            // 回调地址， 为空时根据请求生成
+	MaxAge    time.Duration     // 登录状态有效期， 令牌过期后使用 refresh_token 续期
+	refreshs  map[string]*oidcxRefresh
+	lock      sync.Mutex
+}
+
+// 刷新令牌， 同一个会话同时只刷新一次， 刷新令牌轮换后， 使用旧 cookie 的请求在一段时间内复用刷新结果
+type oidcxRefresh struct {
+	done    chan struct{}
+	refresh string // 使用的刷新令牌
+	claims  jwtx.Claims
+	tkn     *jwtx.Token
+	err     error
+	until   time.Time // 结果保留时间， 为空表示正在刷新
+}
+
+func (aa *AuthzOidcx) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
+	aa.AuthRecord.Authz(gw, rw, rr, rt)
+	jwtx.StripHeaders(rr)
+	switch rr.URL.Path {
+	case aa.Callback:
+		aa.callback(gw, rw, rr, rt)
+		return false
+	case aa.SignOut:
+		aa.signout(gw, rw, rr, rt)
+		return false
+	}
+	if data := aa.load(rr); data != nil {
+		if claims, ok := aa.session(rw, rr, data); ok {
+			jwtx.SetHeaders(rr, claims, aa.Validator.Headers)
+			if csrf, _ := data["csrf"].(string); csrf != "" {
+				rr.Header.Set(OidcxCSRFHeader, csrf)
+			}
+			return true
+		}
+	}
+	// 未登录， 浏览器页面请求跳转到登录页面， 其他请求返回 401
+	if rr.Method != http.MethodGet || !strings.Contains(rr.Header.Get("Accept"), "text/html") {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="oidc"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	state, nonce, verifier, redirect := jwtx.NewVerifier(), jwtx.NewVerifier(), jwtx.NewVerifier(), aa.redirect(rr)
+	val, err := aa.Store.Save(state, map[string]any{
+		"nonce":    nonce,
+		"verifier": verifier,
+		"redirect": redirect,
+		"return":   rr.URL.RequestURI(),
+	}, 10*time.Minute)
+	if err != nil {
+		aa.fail(gw, rw, rt, http.StatusInternalServerError, "save state, "+err.Error())
+		return false
+	}
+	// state cookie 按 state 区分， 多个页面同时登录不会相互覆盖
+	aa.setCookie(rw, rr, aa.stateKey(state), val, 600)
+	http.Redirect(rw, rr, aa.OAuth2.AuthCodeURL(redirect, state, nonce, verifier), http.StatusFound)
+	return false
+}
+
+func (aa *AuthzOidcx) stateKey(state string) string {
+	return aa.CookieKey + "_s_" + state[:min(len(state), 12)]
+}
+
+// 登录状态， 未登录或者无效返回 nil
+func (aa *AuthzOidcx) load(rr *http.Request) map[string]any {
+	ck, err := rr.Cookie(aa.CookieKey)
+	if err != nil {
+		return nil
+	}
+	if _, data, err := aa.Store.Load(ck.Value); err == nil {
+		return data
+	}
+	return nil
+}
+
+// 登出， 只允许 POST, 已经登录时需要验证 CSRF 令牌
+func (aa *AuthzOidcx) signout(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) {
+	if rr.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		aa.fail(gw, rw, rt, http.StatusMethodNotAllowed, "signout need post")
+		return
+	}
+	if data := aa.load(rr); data != nil {
+		csrf, _ := data["csrf"].(string)
+		tkn := rr.Header.Get(sess.CSRFHeader)
+		if tkn == "" {
+			tkn = rr.PostFormValue(z.CSRFField)
+		}
+		if csrf == "" || !hmac.Equal([]byte(csrf), []byte(tkn)) {
+			aa.fail(gw, rw, rt, http.StatusForbidden, "csrf token invalid")
+			return
+		}
+	}
+	aa.setCookie(rw, rr, aa.CookieKey, "", -1)
+	target := "/"
+	if aa.OAuth2.Provider.EndSessionURL != "" {
+		target = aa.OAuth2.Provider.EndSessionURL + "?client_id=" + url.QueryEscape(aa.OAuth2.ClientID)
+	}
+	http.Redirect(rw, rr, target, http.StatusSeeOther)
+}
+
+// 授权回调， 验证 state, 获取令牌， 写入登录状态
+func (aa *AuthzOidcx) callback(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) {
+	qry := rr.URL.Query()
+	if msg := qry.Get("error"); msg != "" {
+		aa.fail(gw, rw, rt, http.StatusUnauthorized, "authorize, "+msg+" "+qry.Get("error_description"))
+		return
+	}
+	ck, err := rr.Cookie(aa.stateKey(qry.Get("state")))
+	if err != nil {
+		aa.fail(gw, rw, rt, http.StatusBadRequest, "state cookie missing")
+		return
+	}
+	state, data, err := aa.Store.Load(ck.Value)
+	if err != nil || data == nil || !hmac.Equal([]byte(state), []byte(qry.Get("state"))) {
+		aa.fail(gw, rw, rt, http.StatusBadRequest, "state invalid")
+		return
+	}
+	aa.setCookie(rw, rr, ck.Name, "", -1)
+	str := func(key string) string { val, _ := data[key].(string); return val }
+
+	tkn, err := aa.OAuth2.Exchange(rr.Context(), str("redirect"), qry.Get("code"), str("verifier"))
+	if err != nil {
+		aa.fail(gw, rw, rt, http.StatusBadGateway, "exchange code, "+err.Error())
+		return
+	}
+	claims, err := aa.Validator.Verify(tkn.IDToken)
+	if err == nil && claims.Str("nonce") != str("nonce") {
+		err = errors.New("nonce invalid")
+	}
+	if err != nil {
+		aa.fail(gw, rw, rt, http.StatusUnauthorized, "id token, "+err.Error())
+		return
+	}
+	if err := aa.save(rw, rr, claims, tkn, jwtx.NewVerifier(), jwtx.NewVerifier()); err != nil {
+		aa.fail(gw, rw, rt, http.StatusInternalServerError, "save session, "+err.Error())
+		return
+	}
+	target := str("return")
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		target = "/" // 只允许站内跳转
+	}
+	http.Redirect(rw, rr, target, http.StatusFound)
+}
+
+// 检查登录状态， 令牌过期时刷新
+func (aa *AuthzOidcx) session(rw http.ResponseWriter, rr *http.Request, data map[string]any) (jwtx.Claims, bool) {
+	claims, _ := data["claims"].(map[string]any)
+	if claims == nil {
+		return nil, false
+	}
+	exp, _ := data["exp"].(float64)
+	if time.Now().Unix() < int64(exp) {
+		return claims, true
+	}
+	refresh, _ := data["refresh"].(string)
+	if refresh == "" {
+		return nil, false
+	}
+	sid, _ := data["sid"].(string)
+	csrf, _ := data["csrf"].(string)
+	key := sid
+	if key == "" {
+		key = refresh
+	}
+	nclaims, tkn, err := aa.refresh(rr.Context(), key, refresh)
+	if err != nil {
+		if z.IsDebug() {
+			z.Logn("[_authzoi]: refresh token error,", err)
+		}
+		return nil, false
+	}
+	if nclaims != nil {
+		claims = nclaims
+	}
+	if err := aa.save(rw, rr, claims, tkn, sid, csrf); err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// 使用刷新令牌续期， 按会话合并并发的刷新请求
+func (aa *AuthzOidcx) refresh(ctx context.Context, key, refresh string) (jwtx.Claims, *jwtx.Token, error) {
+	now := time.Now()
+	aa.lock.Lock()
+	if aa.refreshs == nil {
+		aa.refreshs = map[string]*oidcxRefresh{}
+	}
+	if call := aa.refreshs[key]; call != nil && (call.until.IsZero() || call.refresh == refresh && now.Before(call.until)) {
+		aa.lock.Unlock()
+		<-call.done
+		return call.claims, call.tkn, call.err
+	}
+	for kk, call := range aa.refreshs {
+		if !call.until.IsZero() && now.After(call.until) {
+			delete(aa.refreshs, kk)
+		}
+	}
+	call := &oidcxRefresh{done: make(chan struct{}), refresh: refresh}
+	aa.refreshs[key] = call
+	aa.lock.Unlock()
+
+	// 刷新结果由多个请求共享， 不使用单个请求的取消信号
+	call.tkn, call.err = aa.OAuth2.Refresh(context.WithoutCancel(ctx), refresh)
+	if call.err == nil && call.tkn.IDToken != "" {
+		// 刷新后的 ID 令牌不包含 nonce
+		call.claims, call.err = aa.Validator.Verify(call.tkn.IDToken)
+	}
+	if call.err == nil && call.tkn.RefreshToken == "" {
+		call.tkn.RefreshToken = refresh
+	}
+	aa.lock.Lock()
+	if call.err != nil {
+		delete(aa.refreshs, key)
+	} else {
+		call.until = time.Now().Add(30 * time.Second)
+	}
+	aa.lock.Unlock()
+	close(call.done)
+	return call.claims, call.tkn, call.err
+}
+
+// 保存登录状态， sid 用于合并刷新请求， csrf 用于登出
+func (aa *AuthzOidcx) save(rw http.ResponseWriter, rr *http.Request, claims jwtx.Claims, tkn *jwtx.Token, sid, csrf string) error {
+	exp := time.Now().Add(time.Duration(tkn.ExpiresIn) * time.Second)
+	if tkn.ExpiresIn <= 0 {
+		if texp, ok := claims.Time("exp"); ok {
+			exp = texp
+		} else {
+			exp = time.Now().Add(time.Hour)
+		}
+	}
+	val, err := aa.Store.Save("", map[string]any{
+		"claims":  map[string]any(claims),
+		"refresh": tkn.RefreshToken,
+		"exp":     exp.Unix(),
+		"sid":     sid,
+		"csrf":    csrf,
+	}, aa.MaxAge)
+	if err != nil {
+		return err
+	}
+	aa.setCookie(rw, rr, aa.CookieKey, val, int(aa.MaxAge.Seconds()))
+	return nil
+}
+
+// 回调地址， 与站点使用相同的协议和域名
+func (aa *AuthzOidcx) redirect(rr *http.Request) string {
+	if aa.Redirect != "" {
+		return aa.Redirect
+	}
+	scheme := "http"
+	if rr.TLS != nil {
+		scheme = "https"
+	} else if proto := rr.Header.Get("X-Forwarded-Proto"); proto == "https" && z.IsTrustedProxy(rr.RemoteAddr) {
+		scheme = "https"
+	}
+	return scheme + "://" + rr.Host + aa.Callback
+}
+
+func (aa *AuthzOidcx) setCookie(rw http.ResponseWriter, rr *http.Request, name, value string, maxAge int) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   gtw.MatchSiteHost(rr.Host, aa.SiteHosts),
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(aa.redirect(rr), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (aa *AuthzOidcx) fail(gw gtw.IGateway, rw http.ResponseWriter, rt gtw.IRecord, status int, msg string) {
+	msg = "error in authzoidcx, " + msg
+	gw.Logf(msg + "\n")
+	if rt != nil {
+		rt.SetRespBody([]byte("###" + msg))
+	}
+	rw.WriteHeader(status)
+}
@@ -0,0 +1,243 @@
+package gte_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gte"
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/jwtx"
+)
+
+// 本地 IdP, 只实现授权码 + PKCE 和刷新令牌(轮换)
+func newStubIdP(refreshs *int32) *httptest.Server {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	codes := map[string]url.Values{}
+	lock, current := sync.Mutex{}, "r1"
+	var idp *httptest.Server
+	idToken := func(nonce string) string {
+		claims := jwtx.Claims{"iss": idp.URL, "aud": "zgg", "sub": "u1", "exp": time.Now().Add(time.Hour).Unix()}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		tkn, _ := jwtx.Sign(jwtx.RS256, "k1", key, claims)
+		return tkn
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, rr *http.Request) {
+		json.NewEncoder(rw).Encode(&jwtx.Provider{Issuer: idp.URL, AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token", JwksURL: idp.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, rr *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(rw).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "k1", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("/authorize", func(rw http.ResponseWriter, rr *http.Request) {
+		qry := rr.URL.Query()
+		if qry.Get("client_id") != "zgg" || qry.Get("code_challenge_method") != "S256" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code := jwtx.NewVerifier()
+		lock.Lock()
+		codes[code] = qry
+		lock.Unlock()
+		http.Redirect(rw, rr, qry.Get("redirect_uri")+"?code="+code+"&state="+qry.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, rr *http.Request) {
+		if usr, pwd, _ := rr.BasicAuth(); usr != "zgg" || pwd != "pwd" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rr.ParseForm()
+		lock.Lock()
+		defer lock.Unlock()
+		switch rr.PostForm.Get("grant_type") {
+		case "authorization_code":
+			qry := codes[rr.PostForm.Get("code")]
+			if qry == nil || jwtx.Challenge(rr.PostForm.Get("code_verifier")) != qry.Get("code_challenge") ||
+				rr.PostForm.Get("redirect_uri") != qry.Get("redirect_uri") {
+				rw.WriteHeader(http.StatusBadRequest)
+				rw.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			delete(codes, rr.PostForm.Get("code"))
+			json.NewEncoder(rw).Encode(&jwtx.Token{AccessToken: "a1", RefreshToken: "r1", IDToken: idToken(qry.Get("nonce")), ExpiresIn: 1})
+		case "refresh_token":
+			if rr.PostForm.Get("refresh_token") != current {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// 轮换刷新令牌， 旧的令牌失效
+			current = "r" + strconv.Itoa(int(atomic.AddInt32(refreshs, 1))+1)
+			json.NewEncoder(rw).Encode(&jwtx.Token{AccessToken: "a2", RefreshToken: current, IDToken: idToken(""), ExpiresIn: 3600})
+		}
+	})
+	idp = httptest.NewServer(mux)
+	return idp
+}
+
+// go test -v z/ze/gte/authz_oidcx_test.go -run TestAuthzOidcx
+func TestAuthzOidcx(t *testing.T) {
+	var refreshs int32
+	idp := newStubIdP(&refreshs)
+	defer idp.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		if rr.URL.Path == "/csrf" {
+			rw.Write([]byte(rr.Header.Get(gte.OidcxCSRFHeader)))
+			return
+		}
+		rw.Write([]byte(rr.Header.Get("X-Request-Sky-UserId")))
+	}))
+	defer backend.Close()
+
+	az, err := gte.NewAuthzOidcx(nil, idp.URL, "zgg", "pwd", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	az.Validator.Headers = map[string]string{"sub": "X-Request-Sky-UserId"}
+	gw, _ := gtw.NewTargetGatewayV2(backend.URL)
+	gw.Authorizer = az
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(addr string, accept string) *http.Response {
+		req, _ := http.NewRequest("GET", addr, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("X-Request-Sky-UserId", "fake")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	// 接口请求， 未登录返回 401
+	if resp := get(srv.URL+"/api", "application/json"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(resp.StatusCode)
+	}
+	// 页面请求， 跳转登录 -> IdP 授权 -> 回调 -> 原页面
+	resp := get(srv.URL+"/page?a=1", "text/html")
+	for range 3 {
+		if resp.StatusCode != http.StatusFound {
+			t.Fatal(resp.StatusCode, resp.Request.URL)
+		}
+		loc, _ := resp.Location()
+		resp = get(loc.String(), "text/html")
+	}
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.RequestURI() != "/page?a=1" {
+		t.Fatal(resp.StatusCode, resp.Request.URL)
+	}
+	body := func(addr string) string {
+		req, _ := http.NewRequest("GET", addr, nil)
+		req.Header.Set("X-Request-Sky-UserId", "fake")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bts, _ := io.ReadAll(resp.Body)
+		return string(bts)
+	}
+	if str := body(srv.URL + "/api"); str != "u1" {
+		t.Fatal(str)
+	}
+	// 令牌过期， 使用 refresh_token 续期， 并发请求只刷新一次
+	time.Sleep(1100 * time.Millisecond)
+	wg := sync.WaitGroup{}
+	for range 5 {
+		wg.Go(func() {
+			if str := body(srv.URL + "/api"); str != "u1" {
+				t.Error(str)
+			}
+		})
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&refreshs) != 1 {
+		t.Fatal(refreshs)
+	}
+	if str := body(srv.URL + "/api"); str != "u1" || atomic.LoadInt32(&refreshs) != 1 {
+		t.Fatal(str, refreshs)
+	}
+	// 错误的 state
+	if resp := get(srv.URL+"/oauth2/callback?code=x&state=y", "text/html"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp.StatusCode)
+	}
+	// 登出， 只允许 POST 并验证 CSRF 令牌
+	if resp := get(srv.URL+"/oauth2/logout", "text/html"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal(resp.StatusCode)
+	}
+	logout := func(csrf string) int {
+		resp, err := client.PostForm(srv.URL+"/oauth2/logout", url.Values{"_csrf": {csrf}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := logout("x"); code != http.StatusForbidden {
+		t.Fatal(code)
+	}
+	if csrf := body(srv.URL + "/csrf"); csrf == "" || logout(csrf) != http.StatusSeeOther {
+		t.Fatal("logout", csrf)
+	}
+	if resp := get(srv.URL+"/api", "application/json"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+// go test -v z/ze/gte/authz_oidcx_test.go -run TestAuthzOidcxState
+func TestAuthzOidcxState(t *testing.T) {
+	var refreshs int32
+	idp := newStubIdP(&refreshs)
+	defer idp.Close()
+	az, err := gte.NewAuthzOidcx(nil, idp.URL, "zgg", "pwd", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, _ := gtw.NewTargetGatewayV2("http://127.0.0.1:1")
+	// 两个页面同时登录， state cookie 不会相互覆盖
+	login := func() (*http.Cookie, string) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.Header.Set("Accept", "text/html")
+		az.Authz(gw, rec, req, nil)
+		loc, _ := rec.Result().Location()
+		return rec.Result().Cookies()[0], loc.Query().Get("state")
+	}
+	ck1, state1 := login()
+	ck2, state2 := login()
+	if ck1.Name == ck2.Name {
+		t.Fatal("state cookie overwrite", ck1.Name)
+	}
+	for _, cc := range []struct {
+		ck    *http.Cookie
+		state string
+	}{{ck1, state1}, {ck2, state2}} {
+		req := httptest.NewRequest("GET", "/oauth2/callback?code=x&state="+cc.state, nil)
+		req.AddCookie(ck1)
+		req.AddCookie(ck2)
+		rec := httptest.NewRecorder()
+		az.Authz(gw, rec, req, nil)
+		// code 无效， 但 state 验证通过
+		if rec.Code != http.StatusBadGateway {
+			t.Fatal(cc.state, rec.Code)
+		}
+	}
+}
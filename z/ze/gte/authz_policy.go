@@ -0,0 +1,88 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gte
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/jwtx"
+	"github.com/suisrc/zgg/z/ze/policy"
+)
+
+// 鉴权器， 策略授权， next 完成认证后， 使用 X-Request-Sky-Authorize 中的声明进行授权
+// next 为空时不进行认证， 只使用请求信息授权(匿名)
+// next 不进行认证时(只记录日志， 允许跳过验证)， 删除客户端伪造的用户信息
+func NewAuthzPolicy(sites []string, next gtw.Authorizer, engine *policy.Engine) gtw.Authorizer {
+	return &AuthzPolicy{
+		AuthRecord: gtw.NewAuthRecord(sites),
+		Next:       next,
+		Engine:     engine,
+		Strip:      !IsAuthenticator(next),
+	}
+}
+
+type AuthzPolicy struct {
+	gtw.AuthRecord
+	Next   gtw.Authorizer
+	Engine *policy.Engine
+	Strip  bool // 调用 next 之前删除 X-Request-Sky-*
+}
+
+// 鉴权器是否会认证请求， 并且不信任客户端提供的 X-Request-Sky-Authorize
+func IsAuthenticator(az gtw.Authorizer) bool {
+	switch aa := az.(type) {
+	case nil, *gtw.AuthRecord:
+		return false
+	case *AuthzF1kin:
+		return aa.AuthzServe != "" && !aa.AllowSkipz
+	case *AuthzPolicy:
+		return !aa.Strip
+	}
+	return true
+}
+
+func (aa *AuthzPolicy) Authz(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) bool {
+	if aa.Strip {
+		jwtx.StripHeaders(rr)
+	}
+	if aa.Next != nil {
+		if !aa.Next.Authz(gw, rw, rr, rt) {
+			return false
+		}
+	} else {
+		aa.AuthRecord.Authz(gw, rw, rr, rt)
+	}
+	var claims map[string]any
+	if str := rr.Header.Get(jwtx.SkyAuthorize); str != "" {
+		if bts, err := base64.StdEncoding.DecodeString(str); err == nil {
+			json.Unmarshal(bts, &claims)
+		}
+	}
+	dd := aa.Engine.Eval(policy.NewInput(rr, claims, aa.Engine.SubClaim, aa.Engine.RoleClaim))
+	if len(dd.Matched) > 0 {
+		rr.Header.Set("X-Request-Sky-Policys", strings.Join(dd.Matched, ","))
+	}
+	if r0, ok := rt.(*gtw.Record0); ok && aa.Engine.Explain && r0.Expand != nil {
+		r0.Expand["policy"] = dd.String()
+	}
+	if dd.Allow {
+		return true
+	}
+	msg := "error in authzpolicy, deny by " + dd.Rule
+	if dd.Rule == "" {
+		msg = "error in authzpolicy, deny by default"
+	}
+	if rt != nil {
+		rt.SetRespBody([]byte("###" + msg))
+	}
+	gw.Logf(msg + "\n")
+	rw.WriteHeader(http.StatusForbidden)
+	return false
+}
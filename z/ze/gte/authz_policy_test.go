@@ -0,0 +1,63 @@
+package gte_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gte"
+	"github.com/suisrc/zgg/z/ze/gtw"
+	"github.com/suisrc/zgg/z/ze/jwtx"
+	"github.com/suisrc/zgg/z/ze/policy"
+)
+
+// go test -v z/ze/gte/authz_policy_test.go -run TestAuthzPolicy
+func TestAuthzPolicy(t *testing.T) {
+	pp, err := policy.Parse([]byte(`
+[roles]
+admin = ["user"]
+[[rules]]
+id = "user-api"
+subjects = ["role:user"]
+resources = ["/api/**"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ee := policy.NewEngine(pp)
+	ee.Explain = true
+	keys := jwtx.NewKeySet(jwtx.NewSecret("k1", []byte("secret")))
+	az := gte.NewAuthzPolicy(nil, gte.NewAuthzJwtx(nil, jwtx.NewValidator(keys)), ee)
+	gw, _ := gtw.NewTargetGatewayV2("http://127.0.0.1:1")
+
+	check := func(rol string) (bool, *http.Request, *httptest.ResponseRecorder, *gtw.Record0) {
+		tkn, _ := jwtx.Sign(jwtx.HS256, "k1", []byte("secret"), jwtx.Claims{"sub": "u1", "rol": rol, "exp": time.Now().Unix() + 60})
+		req := httptest.NewRequest("GET", "/api/x", nil)
+		req.Header.Set("Authorization", "Bearer "+tkn)
+		rec := httptest.NewRecorder()
+		rt := &gtw.Record0{Expand: map[string]any{}}
+		return az.Authz(gw, rec, req, rt), req, rec, rt
+	}
+	if ok, req, _, rt := check("admin"); !ok || req.Header.Get("X-Request-Sky-Policys") != "user-api" ||
+		!strings.HasPrefix(rt.Expand["policy"].(string), "allow by user-api") {
+		t.Fatal("expect allow", rt.Expand)
+	} else if rc := gte.ToRecord0(rt).(*gte.Record0); rc.Expand["policy"] != rt.Expand["policy"] {
+		t.Fatal("expect policy in record0", rc.Expand)
+	}
+	if ok, _, rec, rt := check("guest"); ok || rec.Code != http.StatusForbidden || !strings.Contains(string(rt.RespBody), "deny by default") {
+		t.Fatal("expect deny", rec.Code)
+	}
+
+	// 只记录日志的鉴权器， 不信任客户端提供的用户信息
+	for _, next := range []gtw.Authorizer{nil, gte.NewAuthLogger(nil), gte.NewAuthzF1kin(nil, "", false), gte.NewAuthzF1kin(nil, "http://127.0.0.1:1", true)} {
+		az := gte.NewAuthzPolicy(nil, next, ee)
+		req := httptest.NewRequest("GET", "/api/x", nil)
+		req.Header.Set(jwtx.SkyAuthorize, "eyJzdWIiOiJ1MSIsInJvbCI6ImFkbWluIn0=") // {"sub":"u1","rol":"admin"}
+		rec := httptest.NewRecorder()
+		if az.Authz(gw, rec, req, nil) || rec.Code != http.StatusForbidden && rec.Code != http.StatusBadGateway {
+			t.Fatalf("expect deny forged claims %T %d", next, rec.Code)
+		}
+	}
+}
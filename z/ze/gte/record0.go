@@ -6,6 +6,7 @@
 package gte
 
 import (
+	"maps"
 	"net"
 	"net/url"
 	"strings"
@@ -59,6 +60,7 @@ type Record0 struct {
 	RespBody any
 	RespSize int64
 	Result2  string
+	Expand   map[string]any // 扩展信息， 策略解释， 上游重试等
 }
 
 // ==========================================================
@@ -194,6 +196,10 @@ func ByRecord0(rt *gtw.Record0, rc *Record0) {
 		// }
 	}
 	rc.RespSize = rt.RespSize
+	// 扩展信息， rt 会被回收， 需要复制
+	if len(rt.Expand) > 0 {
+		rc.Expand = maps.Clone(rt.Expand)
+	}
 	// -------------------------------------------------------------------
 	// 尝试分析结果
 	rc.Result2 = "success"
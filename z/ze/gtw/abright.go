@@ -51,6 +51,9 @@ var (
 	// default's transport for gateway
 	TransportGtw = http.DefaultTransport
 
+	// h2c(HTTP/2 cleartext)'s transport, http:// 使用 h2c, https:// 使用 h2
+	TransportH2c http.RoundTripper = NewTransportH2c()
+
 	// skip tls verify's transport
 	TransportSkip http.RoundTripper = &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -71,6 +74,14 @@ var (
 
 // --------------------------------------------------------------------------------------
 
+func NewTransportH2c() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Protocols = new(http.Protocols)
+	tr.Protocols.SetHTTP2(true)
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr
+}
+
 func NewTargetProxyV2(target_ string) (http.Handler, error) {
 	target, err := url.Parse(target_)
 	if err != nil {
@@ -31,6 +31,16 @@ func NewAuthRecord(sites []string) AuthRecord {
 	}
 }
 
+// 匹配站点域名， 用于 cookie 的 Domain, 不匹配返回空
+func MatchSiteHost(host string, sites []string) string {
+	for _, site := range sites {
+		if strings.HasSuffix(host, site) {
+			return site
+		}
+	}
+	return ""
+}
+
 // 不验证，只用于记录日志
 type AuthRecord struct {
 	ClientKey string   // Client ID key
@@ -63,13 +73,7 @@ func (aa *AuthRecord) Authz(gw IGateway, rw http.ResponseWriter, rr *http.Reques
 	if len(aa.SiteHosts) == 0 {
 		return true
 	}
-	siteHost := ""
-	for _, host := range aa.SiteHosts {
-		if strings.HasSuffix(rr.Host, host) {
-			siteHost = host
-			break
-		}
-	}
+	siteHost := MatchSiteHost(rr.Host, aa.SiteHosts)
 	if siteHost == "" {
 		return true // 只处理已知的站点列表
 	}
@@ -0,0 +1,64 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// 缓存内容， 完整读取后关闭 rc, 返回内容， rest 为 nil
+// 超过 max 或者读取错误时， 返回 nil 和拼接已读取内容的 rest, 调用者使用 rest 替换原来的内容
+func bufferBody(rc io.ReadCloser, max int64) (buf []byte, rest io.ReadCloser) {
+	bts, err := io.ReadAll(io.LimitReader(rc, max+1))
+	if err != nil || int64(len(bts)) > max {
+		return nil, struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(bts), rc), rc}
+	}
+	rc.Close()
+	return bts, nil
+}
+
+// 读取时缓存内容， 超过 limit 后放弃， 关闭时回调， full 表示完整读取并且没有超过 limit
+type TeeBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	over  bool
+	eof   bool
+	done  func(body []byte, full bool)
+	once  sync.Once
+}
+
+// limit <= 0 不限制大小
+func NewTeeBody(rc io.ReadCloser, limit int64, done func(body []byte, full bool)) *TeeBody {
+	return &TeeBody{ReadCloser: rc, limit: limit, done: done}
+}
+
+func (tb *TeeBody) Read(pp []byte) (int, error) {
+	nn, err := tb.ReadCloser.Read(pp)
+	if nn > 0 && !tb.over {
+		if tb.limit > 0 && int64(tb.buf.Len()+nn) > tb.limit {
+			tb.over = true
+			tb.buf = bytes.Buffer{}
+		} else {
+			tb.buf.Write(pp[:nn])
+		}
+	}
+	if err == io.EOF {
+		tb.eof = true
+	}
+	return nn, err
+}
+
+func (tb *TeeBody) Close() error {
+	err := tb.ReadCloser.Close()
+	tb.once.Do(func() { tb.done(tb.buf.Bytes(), tb.eof && !tb.over) })
+	return err
+}
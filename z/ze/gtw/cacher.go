@@ -0,0 +1,16 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import "net/http"
+
+// 请求上游服务
+type FetchFunc func(outreq *http.Request) (*http.Response, error)
+
+// 响应缓存， req 为原始请求， outreq 为转发请求， 返回缓存的响应或者 fetch 的响应
+type Cacher interface {
+	Cache(gw IGateway, req, outreq *http.Request, rt IRecord, fetch FetchFunc) (*http.Response, error)
+}
@@ -30,8 +30,14 @@ type IGateway interface {
 
 type GatewayProxy struct {
 	ReverseProxy
-	RecordPool RecordPool // 请求追踪
-	Authorizer Authorizer // 权限认证
+	RecordPool RecordPool       // 请求追踪
+	Authorizer Authorizer       // 权限认证
+	Limiter    Limiter          // 流量限制
+	Upstreams  *UpstreamPool    // 上游服务池， 为空使用 Director 的地址
+	Resilience *ResilientPolicy // 上游调用策略， 超时， 重试和熔断
+	Cacher     Cacher           // 响应缓存
+	Transform  *Transform       // 请求和响应转换
+	Mirror     *Mirror          // 流量镜像
 }
 
 func (p *GatewayProxy) GetProxyName() string {
@@ -63,6 +69,18 @@ func (p *GatewayProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	// ==== recordtrace ====<<<
 
+	// ==== limiter ====>>>
+	if p.Limiter != nil {
+		release, ok := p.Limiter.Limit(p, rw, req, record)
+		if !ok {
+			return // limited
+		}
+		if release != nil {
+			defer release()
+		}
+	}
+	// ==== limiter ====<<<
+
 	ctx := req.Context()
 	if ctx.Done() != nil {
 		// CloseNotifier predates context.Context, and has been
@@ -112,6 +130,12 @@ func (p *GatewayProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	// ==== authentication ====<<<
 
+	// ==== transform ====>>>
+	if p.Transform != nil && !p.Transform.Request(rw, outreq, record) {
+		return // redirect
+	}
+	// ==== transform ====<<<
+
 	if (p.Director != nil) == (p.Rewrite != nil) {
 		err := errors.New("ReverseProxy must have exactly one of Director or Rewrite set")
 		if record != nil {
@@ -175,6 +199,7 @@ func (p *GatewayProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.Rewrite(pr)
 		outreq = pr.Out
 	} else {
+		stripForwarded(req, outreq)
 		if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
 			// If we aren't the first proxy retain prior
 			// X-Forwarded-For information as a comma+space
@@ -217,11 +242,6 @@ func (p *GatewayProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			clear(h)
 			return nil
 		},
-		GotConn: func(info httptrace.GotConnInfo) {
-			if record != nil {
-				record.SetUpstream(info.Conn.RemoteAddr().String()) // record upstream address
-			}
-		},
 	}
 	outreq = outreq.WithContext(httptrace.WithClientTrace(outreq.Context(), trace))
 
@@ -231,10 +251,44 @@ func (p *GatewayProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	// ==== recordtrace ====<<<
 
-	res, err := transport.RoundTrip(outreq)
+	// ==== mirror ====>>>
+	var mirror *mirrorCall
+	if p.Mirror != nil && reqUpType == "" {
+		if mirror = p.Mirror.start(outreq, transport, p.RecordPool); mirror != nil {
+			RecordExpand(record, "mirror", p.Mirror.Target.Host)
+		}
+	}
+	// ==== mirror ====<<<
+
+	// ==== upstream ====>>>
+	var upstream *Upstream
+	var upstatus int
+	var uperr error
+	fetch := func(outreq *http.Request) (*http.Response, error) {
+		res, up, err := p.roundTrip(transport, outreq, record)
+		if upstream, uperr = up, err; res != nil {
+			upstatus = res.StatusCode
+		}
+		return res, err
+	}
+	var res *http.Response
+	var err error
+	if p.Cacher != nil && (p.Transform == nil || p.Transform.Cacheable()) {
+		res, err = p.Cacher.Cache(p, req, outreq, record, fetch)
+	} else {
+		res, err = fetch(outreq)
+	}
+	// ==== upstream ====<<<
 	roundTripMutex.Lock()
 	roundTripDone = true
 	roundTripMutex.Unlock()
+	if upstream != nil {
+		// 活动连接数在响应结束后释放， 使用上游的结果， 缓存可能替换了响应
+		defer p.Upstreams.Done(upstream, uperr, upstatus)
+	}
+	if mirror != nil {
+		mirror.compare(res, err)
+	}
 	if err != nil {
 		if record != nil {
 			record.SetRespBody([]byte("###error gateway, " + err.Error()))
@@ -242,6 +296,18 @@ func (p *GatewayProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.GetErrorHandler()(rw, outreq, err)
 		return
 	}
+	// ==== transform ====>>>
+	if p.Transform != nil {
+		if err := p.Transform.Response(outreq, res); err != nil {
+			res.Body.Close()
+			if record != nil {
+				record.SetRespBody([]byte("###error transform, " + err.Error()))
+			}
+			p.GetErrorHandler()(rw, outreq, err)
+			return
+		}
+	}
+	// ==== transform ====<<<
 	// ==== recordtrace ====>>>
 	if record != nil {
 		record.LogResponse(res)
@@ -0,0 +1,13 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import "net/http"
+
+// 流量限制， 返回 false 表示请求被拒绝(已经写出响应), release 在请求结束后调用
+type Limiter interface {
+	Limit(gw IGateway, rw http.ResponseWriter, rr *http.Request, rt IRecord) (release func(), ok bool)
+}
@@ -0,0 +1,240 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 流量镜像， 通过 GatewayProxy.Mirror 使用， 异步将采样的请求发送到影子服务， 丢弃影子服务的响应
+// 开启 Diff 后比较主服务和影子服务的状态码和响应体， 差异通过 RecordPool 记录， Expand["mirror"]
+type Mirror struct {
+	Target      *url.URL          // 影子服务， 只替换协议和地址
+	Percent     float64           // 采样比例, 0 ~ 100
+	MaxBody     int64             // 缓存请求体和比较响应体的最大大小， 请求体超过后不镜像
+	Timeout     time.Duration     // 影子服务请求超时
+	Diff        bool              // 比较响应
+	Concurrency int               // 最大并发镜像请求， 超过后不镜像
+	Credential  bool              // 转发认证信息 Authorization, Cookie 和 X-Request-Sky-*, 默认删除
+	Transport   http.RoundTripper // 为空使用网关的 Transport
+	RecordPool  RecordPool        // 记录差异， 为空使用网关的 RecordPool
+	sem         chan struct{}
+	once        sync.Once
+}
+
+// 镜像请求头， 影子服务可以用来区分镜像流量
+const MirrorHeader = "X-Request-Sky-Mirror"
+
+func NewMirror(target string) (*Mirror, error) {
+	uu, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if uu.Host == "" {
+		return nil, fmt.Errorf("mirror host is empty: %s", target)
+	}
+	return &Mirror{
+		Target:      uu,
+		Percent:     100,
+		MaxBody:     64 << 10,
+		Timeout:     5 * time.Second,
+		Concurrency: 100,
+	}, nil
+}
+
+// 解析流量镜像, 格式: http://shadow:80;percent=10;body=65536;timeout=5s;diff=true;conc=100;cred=false
+func ParseMirror(str string) (*Mirror, error) {
+	target, opts, _ := strings.Cut(str, ";")
+	mm, err := NewMirror(strings.TrimSpace(target))
+	if err != nil {
+		return nil, err
+	}
+	for kv := range strings.SplitSeq(opts, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		switch key {
+		case "":
+			continue
+		case "percent":
+			if mm.Percent, err = strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64); err == nil && (mm.Percent < 0 || mm.Percent > 100) {
+				err = errors.New("percent must be 0 ~ 100")
+			}
+		case "body":
+			mm.MaxBody, err = strconv.ParseInt(val, 10, 64)
+		case "timeout":
+			mm.Timeout, err = time.ParseDuration(val)
+		case "diff":
+			mm.Diff, err = strconv.ParseBool(val)
+		case "conc":
+			mm.Concurrency, err = strconv.Atoi(val)
+		case "cred":
+			mm.Credential, err = strconv.ParseBool(val)
+		default:
+			err = errors.New("unknow field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mirror parse %s error: %v", kv, err)
+		}
+	}
+	return mm, nil
+}
+
+// -----------------------------------------------------------------------------------
+
+type mirrorResult struct {
+	status int
+	body   []byte
+	full   bool // body 完整读取
+	err    error
+}
+
+// 一次镜像请求
+type mirrorCall struct {
+	mirror *Mirror
+	record IRecord
+	pool   RecordPool
+	done   chan mirrorResult
+}
+
+// 采样并发送镜像请求， 没有采样返回 nil， outreq 的请求体会被替换为缓存的内容
+func (mm *Mirror) start(outreq *http.Request, transport http.RoundTripper, pool RecordPool) *mirrorCall {
+	if mm.Percent <= 0 || mm.Percent < 100 && rand.Float64()*100 >= mm.Percent {
+		return nil
+	}
+	if outreq.ContentLength > mm.MaxBody {
+		return nil
+	}
+	mm.once.Do(func() { mm.sem = make(chan struct{}, max(mm.Concurrency, 1)) })
+	select {
+	case mm.sem <- struct{}{}:
+	default:
+		return nil // 并发已满， 丢弃
+	}
+	var body []byte
+	if outreq.Body != nil && outreq.Body != http.NoBody {
+		var rest io.ReadCloser
+		if body, rest = bufferBody(outreq.Body, mm.MaxBody); rest != nil {
+			outreq.Body = rest // 超过缓存大小， 不镜像
+			<-mm.sem
+			return nil
+		}
+		outreq.Body = io.NopCloser(bytes.NewReader(body))
+		outreq.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+	if mm.Transport != nil {
+		transport = mm.Transport
+	}
+	if mm.RecordPool != nil {
+		pool = mm.RecordPool
+	}
+	// 影子请求与客户端请求无关， 不继承 context
+	ctx, cancel := context.WithTimeout(context.Background(), mm.Timeout)
+	shadow := outreq.Clone(ctx)
+	shadow.URL.Scheme, shadow.URL.Host = mm.Target.Scheme, mm.Target.Host
+	if !mm.Credential {
+		// 影子服务不一定可信， 删除认证信息和网关添加的用户信息
+		shadow.Header.Del("Authorization")
+		shadow.Header.Del("Cookie")
+		for kk := range shadow.Header {
+			if HasPrefixFold(kk, "X-Request-Sky-") {
+				delete(shadow.Header, kk)
+			}
+		}
+	}
+	shadow.Header.Set(MirrorHeader, "true")
+	if body != nil {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	mc := &mirrorCall{mirror: mm, pool: pool, done: make(chan mirrorResult, 1)}
+	if mm.Diff && pool != nil {
+		mc.record = pool.Get()
+		mc.record.LogRequest(shadow)
+		mc.record.LogOutRequest(shadow)
+	}
+	go func() {
+		defer func() { <-mm.sem }()
+		defer cancel()
+		mc.done <- mc.call(transport, shadow)
+	}()
+	return mc
+}
+
+func (mc *mirrorCall) call(transport http.RoundTripper, shadow *http.Request) mirrorResult {
+	res, err := transport.RoundTrip(shadow)
+	if err != nil {
+		if mc.record != nil {
+			mc.record.SetRespBody([]byte("###error mirror, " + err.Error()))
+		}
+		return mirrorResult{err: err}
+	}
+	defer res.Body.Close()
+	if mc.record != nil {
+		mc.record.LogResponse(res)
+	}
+	if !mc.mirror.Diff {
+		io.Copy(io.Discard, res.Body)
+		return mirrorResult{status: res.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, mc.mirror.MaxBody+1))
+	full := err == nil && int64(len(body)) <= mc.mirror.MaxBody
+	if full && mc.record != nil {
+		mc.record.LogRespBody(int64(len(body)), nil, body)
+	}
+	return mirrorResult{status: res.StatusCode, body: body, full: full, err: err}
+}
+
+// 主服务的响应， 开启 Diff 时， 在响应体关闭后与影子服务比较
+func (mc *mirrorCall) compare(res *http.Response, err error) {
+	if !mc.mirror.Diff {
+		return
+	}
+	if err != nil {
+		go mc.diff(mirrorResult{err: err})
+		return
+	}
+	res.Body = NewTeeBody(res.Body, mc.mirror.MaxBody, func(body []byte, full bool) {
+		go mc.diff(mirrorResult{status: res.StatusCode, body: body, full: full})
+	})
+}
+
+func (mc *mirrorCall) diff(primary mirrorResult) {
+	shadow := <-mc.done
+	diffs := []string{}
+	if primary.status != shadow.status {
+		diffs = append(diffs, fmt.Sprintf("status %d != %d", primary.status, shadow.status))
+	}
+	if (primary.err == nil) != (shadow.err == nil) {
+		diffs = append(diffs, fmt.Sprintf("error %v != %v", primary.err, shadow.err))
+	}
+	if primary.full && shadow.full && !bytes.Equal(primary.body, shadow.body) {
+		diffs = append(diffs, fmt.Sprintf("body %d != %d bytes", len(primary.body), len(shadow.body)))
+	}
+	if mc.record == nil {
+		return
+	}
+	if len(diffs) == 0 {
+		mc.pool.Put(mc.record) // 没有差异， 不记录
+		return
+	}
+	RecordExpand(mc.record, "mirror", map[string]any{
+		"target":  mc.mirror.Target.Host,
+		"primary": primary.status,
+		"shadow":  shadow.status,
+		"diff":    strings.Join(diffs, "; "),
+	})
+	mc.record.Recycle()
+}
@@ -0,0 +1,114 @@
+package gtw_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// go test -v z/ze/gtw/mirror_test.go -run TestMirror
+func TestMirror(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		bts, _ := io.ReadAll(rr.Body)
+		rw.Write(append([]byte("v1:"), bts...))
+	}))
+	defer primary.Close()
+	var shadows atomic.Int32
+	received := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		shadows.Add(1)
+		bts, _ := io.ReadAll(rr.Body)
+		received <- rr.Header.Get(gtw.MirrorHeader) + "|" + string(bts) + "|" +
+			rr.Header.Get("Authorization") + rr.Header.Get("Cookie") + rr.Header.Get("X-Request-Sky-User")
+		if rr.URL.Path == "/diff" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		rw.Write(append([]byte("v1:"), bts...))
+	}))
+	defer shadow.Close()
+
+	mm, err := gtw.ParseMirror(shadow.URL + ";percent=100;diff=true;timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gtw.ParseMirror(shadow.URL + ";percent=200"); err == nil {
+		t.Fatal("expect percent error")
+	}
+	diffs := make(chan map[string]any, 10)
+	gw, _ := gtw.NewTargetGatewayV2(primary.URL)
+	gw.Mirror = mm
+	gw.RecordPool = gtw.NewRecordPool(func(rt gtw.IRecord) {
+		if diff, ok := rt.(*gtw.Record0).Expand["mirror"].(map[string]any); ok {
+			diffs <- diff
+		}
+	}, false)
+	call := func(path, body string) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer x")
+		req.Header.Set("Cookie", "sid=1")
+		req.Header.Set("X-Request-Sky-User", "u1")
+		gw.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	// 主服务响应不受影响， 影子服务收到相同的请求体
+	if body := call("/same", "hello"); body != "v1:hello" {
+		t.Fatal("primary", body)
+	}
+	if got := <-received; got != "true|hello|" {
+		t.Fatal("shadow", got)
+	}
+	if body := call("/diff", "world"); body != "v1:world" {
+		t.Fatal("primary", body)
+	}
+	<-received
+	select {
+	case diff := <-diffs:
+		if diff["primary"] != 200 || diff["shadow"] != 500 || !strings.Contains(diff["diff"].(string), "status 200 != 500") {
+			t.Fatal("diff", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("diff not recorded")
+	}
+	// 没有差异不记录
+	select {
+	case diff := <-diffs:
+		t.Fatal("unexpected diff", diff)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 转发认证信息
+	if mm, err = gtw.ParseMirror(shadow.URL + ";body=4;cred=true"); err != nil {
+		t.Fatal(err)
+	}
+	gw.Mirror = mm
+	call("/same", "a")
+	if got := <-received; got != "true|a|Bearer xsid=1u1" {
+		t.Fatal("shadow credential", got)
+	}
+	// 请求体超过缓存大小， 主服务收到完整的请求体， 不镜像
+	shadows.Store(0)
+	if body := call("/same", "123456"); body != "v1:123456" {
+		t.Fatal("primary", body)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if shadows.Load() != 0 {
+		t.Fatal("expect no mirror for large body")
+	}
+
+	// 不采样
+	mm.Percent = 0
+	shadows.Store(0)
+	call("/same", "x")
+	time.Sleep(50 * time.Millisecond)
+	if shadows.Load() != 0 {
+		t.Fatal("expect no mirror")
+	}
+}
@@ -129,6 +129,13 @@ func (rc *Record0) SetSrvAuthz(addr string) {
 	rc.SrvAuthzAddr = addr
 }
 
+// 写入扩展字段， 只支持 Record0
+func RecordExpand(record IRecord, key string, val any) {
+	if rc, ok := record.(*Record0); ok && rc.Expand != nil {
+		rc.Expand[key] = val
+	}
+}
+
 // ----------------------------------------------------------------------------
 
 // NewRecordPool 初始化缓冲池
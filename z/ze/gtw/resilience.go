@@ -0,0 +1,420 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUpstreamTimeout = errors.New("upstream timeout")
+	ErrConnectTimeout  = fmt.Errorf("connect: %w", ErrUpstreamTimeout)
+	ErrResponseTimeout = fmt.Errorf("response: %w", ErrUpstreamTimeout)
+	ErrCircuitOpen     = errors.New("upstream circuit open")
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breaker struct {
+	state  int
+	fails  int       // 连续失败次数
+	until  time.Time // 熔断结束时间
+	probes int       // 半开状态正在探测的请求
+}
+
+// 上游调用策略， 超时， 重试和熔断， 通过 GatewayProxy.Resilience 使用
+type ResilientPolicy struct {
+	ConnectTimeout  time.Duration // 连接超时(含 TLS 握手)
+	ResponseTimeout time.Duration // 等待响应头超时
+	Retries         int           // 最大重试次数， 只重试幂等方法或者带 Idempotency-Key 的请求
+	RetryOn         []int         // 重试的状态码， 连接错误和超时总是重试
+	Backoff         time.Duration // 退避基础时间， 指数增长， 全随机抖动
+	MaxBackoff      time.Duration // 最大退避时间
+	Budget          float64       // 重试预算， 重试数量不超过请求数量的比例
+	MinRetries      int           // 每个统计窗口最少允许的重试次数
+	MaxBody         int64         // 缓存请求体用于重试的最大大小， 超过后不重试
+	Failures        int           // 熔断， 连续失败次数， 0 不熔断
+	OpenTime        time.Duration // 熔断时间， 之后进入半开状态
+	Probes          int           // 半开状态允许的探测请求数量
+	breakers        map[string]*breaker
+	window          time.Time // 重试预算统计窗口
+	requests        int
+	retries         int
+	lock            sync.Mutex
+}
+
+func NewResilientPolicy() *ResilientPolicy {
+	return &ResilientPolicy{
+		RetryOn:    []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		Backoff:    50 * time.Millisecond,
+		MaxBackoff: time.Second,
+		Budget:     0.2,
+		MinRetries: 10,
+		MaxBody:    64 << 10,
+		OpenTime:   30 * time.Second,
+		Probes:     1,
+		breakers:   map[string]*breaker{},
+	}
+}
+
+// 解析调用策略, 格式: connect=2s;response=30s;retries=2;retryon=502|503|504;backoff=50ms;maxbackoff=1s;budget=0.2;body=65536;breaker=5;open=30s;probes=1
+func ParseResilientPolicy(str string) (*ResilientPolicy, error) {
+	rs := NewResilientPolicy()
+	for kv := range strings.SplitSeq(str, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		var err error
+		switch key {
+		case "":
+			continue
+		case "connect":
+			rs.ConnectTimeout, err = time.ParseDuration(val)
+		case "response":
+			rs.ResponseTimeout, err = time.ParseDuration(val)
+		case "retries":
+			rs.Retries, err = strconv.Atoi(val)
+		case "retryon":
+			rs.RetryOn = rs.RetryOn[:0]
+			for code := range strings.SplitSeq(val, "|") {
+				var num int
+				if num, err = strconv.Atoi(code); err != nil {
+					break
+				}
+				rs.RetryOn = append(rs.RetryOn, num)
+			}
+		case "backoff":
+			rs.Backoff, err = time.ParseDuration(val)
+		case "maxbackoff":
+			rs.MaxBackoff, err = time.ParseDuration(val)
+		case "budget":
+			rs.Budget, err = strconv.ParseFloat(val, 64)
+		case "minretries":
+			rs.MinRetries, err = strconv.Atoi(val)
+		case "body":
+			rs.MaxBody, err = strconv.ParseInt(val, 10, 64)
+		case "breaker":
+			rs.Failures, err = strconv.Atoi(val)
+		case "open":
+			rs.OpenTime, err = time.ParseDuration(val)
+		case "probes":
+			rs.Probes, err = strconv.Atoi(val)
+		default:
+			err = errors.New("unknow field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resilient parse %s error: %v", kv, err)
+		}
+	}
+	return rs, nil
+}
+
+// -----------------------------------------------------------------------------------
+
+// 是否可以重试
+func (rs *ResilientPolicy) idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// 缓存请求体， 返回重放函数， 不能重试时返回 nil
+func (rs *ResilientPolicy) replayable(req *http.Request) func() io.ReadCloser {
+	if rs == nil || rs.Retries <= 0 || !rs.idempotent(req) {
+		return nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return func() io.ReadCloser { return req.Body }
+	}
+	if req.ContentLength > rs.MaxBody {
+		return nil
+	}
+	bts, rest := bufferBody(req.Body, rs.MaxBody)
+	if rest != nil {
+		req.Body = rest // 超过缓存大小， 不重试
+		return nil
+	}
+	replay := func() io.ReadCloser { return io.NopCloser(bytes.NewReader(bts)) }
+	req.Body = replay()
+	req.GetBody = func() (io.ReadCloser, error) { return replay(), nil }
+	return replay
+}
+
+// 是否需要重试， 并消耗重试预算
+func (rs *ResilientPolicy) retry(req *http.Request, err error, status int) bool {
+	if req.Context().Err() != nil {
+		return false // 客户端取消
+	}
+	if err == nil && !slices.Contains(rs.RetryOn, status) {
+		return false
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.retries >= rs.MinRetries+int(rs.Budget*float64(rs.requests)) {
+		return false
+	}
+	rs.retries++
+	return true
+}
+
+// 统计请求数量， 10s 一个窗口
+func (rs *ResilientPolicy) count() {
+	if rs == nil {
+		return
+	}
+	now := time.Now()
+	rs.lock.Lock()
+	if now.Sub(rs.window) > 10*time.Second {
+		rs.window, rs.requests, rs.retries = now, 0, 0
+	}
+	rs.requests++
+	rs.lock.Unlock()
+}
+
+// 指数退避， 全随机抖动
+func (rs *ResilientPolicy) sleep(ctx context.Context, attempt int) error {
+	dur := min(rs.MaxBackoff, rs.Backoff<<(attempt-1))
+	if dur <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(rand.Int64N(int64(dur) + 1)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 熔断检查
+func (rs *ResilientPolicy) allow(key string) bool {
+	if rs == nil || rs.Failures <= 0 {
+		return true
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	bk := rs.breakers[key]
+	if bk == nil {
+		return true
+	}
+	switch bk.state {
+	case breakerOpen:
+		if time.Now().Before(bk.until) {
+			return false
+		}
+		bk.state, bk.probes = breakerHalfOpen, 0
+		fallthrough
+	case breakerHalfOpen:
+		if bk.probes >= max(rs.Probes, 1) {
+			return false
+		}
+		bk.probes++
+	}
+	return true
+}
+
+// 记录调用结果， 连接错误, 超时和 5xx 为失败
+func (rs *ResilientPolicy) report(key string, err error, status int) {
+	if rs == nil || rs.Failures <= 0 || errors.Is(err, context.Canceled) {
+		return
+	}
+	failed := err != nil || status >= http.StatusInternalServerError
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	bk := rs.breakers[key]
+	if bk == nil {
+		if !failed {
+			return
+		}
+		bk = &breaker{}
+		rs.breakers[key] = bk
+	}
+	if bk.state == breakerHalfOpen {
+		bk.probes--
+	}
+	switch {
+	case !failed:
+		bk.state, bk.fails = breakerClosed, 0
+	case bk.state == breakerHalfOpen:
+		bk.state, bk.until = breakerOpen, time.Now().Add(rs.OpenTime)
+	case bk.state == breakerClosed:
+		if bk.fails++; bk.fails >= rs.Failures {
+			bk.state, bk.fails, bk.until = breakerOpen, 0, time.Now().Add(rs.OpenTime)
+		}
+	}
+}
+
+// 熔断状态
+func (rs *ResilientPolicy) State(key string) string {
+	if rs == nil {
+		return ""
+	}
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if bk := rs.breakers[key]; bk != nil {
+		switch bk.state {
+		case breakerOpen:
+			return "open"
+		case breakerHalfOpen:
+			return "half-open"
+		}
+	}
+	return "closed"
+}
+
+// 单次调用， 处理连接超时和响应超时
+func (rs *ResilientPolicy) attempt(transport http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if rs == nil || rs.ConnectTimeout <= 0 && rs.ResponseTimeout <= 0 {
+		return transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timers := []*time.Timer{}
+	if rs.ConnectTimeout > 0 {
+		timer := time.AfterFunc(rs.ConnectTimeout, func() { cancel(ErrConnectTimeout) })
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(httptrace.GotConnInfo) { timer.Stop() },
+		})
+		timers = append(timers, timer)
+	}
+	if rs.ResponseTimeout > 0 {
+		timers = append(timers, time.AfterFunc(rs.ResponseTimeout, func() { cancel(ErrResponseTimeout) }))
+	}
+	res, err := transport.RoundTrip(req.WithContext(ctx))
+	for _, timer := range timers {
+		timer.Stop()
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrUpstreamTimeout) {
+		cancel(cause)
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, cause
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	// 响应体关闭后释放， 协议升级需要保留 io.ReadWriteCloser
+	if conn, ok := res.Body.(io.ReadWriteCloser); ok && res.StatusCode == http.StatusSwitchingProtocols {
+		res.Body = &cancelConn{ReadWriteCloser: conn, cancel: func() { cancel(nil) }}
+	} else {
+		res.Body = &cancelBody{ReadCloser: res.Body, cancel: func() { cancel(nil) }}
+	}
+	return res, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
+
+type cancelConn struct {
+	io.ReadWriteCloser
+	cancel func()
+}
+
+func (cc *cancelConn) Close() error {
+	err := cc.ReadWriteCloser.Close()
+	cc.cancel()
+	return err
+}
+
+// -----------------------------------------------------------------------------------
+
+// 请求上游服务， 处理上游服务池, 熔断, 超时和重试， 返回最后一次请求的上游服务
+// 每次请求的结果记录在 Record0.Expand["upstreams"]
+func (p *GatewayProxy) roundTrip(transport http.RoundTripper, outreq *http.Request, record IRecord) (*http.Response, *Upstream, error) {
+	rs := p.Resilience
+	replay := rs.replayable(outreq)
+	rs.count()
+	trail := []string{}
+	defer func() {
+		if len(trail) > 1 || rs != nil && rs.Failures > 0 {
+			RecordExpand(record, "upstreams", strings.Join(trail, ", "))
+		}
+	}()
+	for attempt := 1; ; attempt++ {
+		var upstream *Upstream
+		if p.Upstreams != nil {
+			if upstream = p.Upstreams.Pick(outreq); upstream == nil {
+				trail = append(trail, ErrNoUpstream.Error())
+				return nil, nil, ErrNoUpstream
+			}
+			upstream.Rewrite(outreq)
+		}
+		key, status := outreq.URL.Host, 0
+		var res *http.Response
+		var err error
+		if !rs.allow(key) {
+			err = ErrCircuitOpen
+		} else {
+			res, err = rs.attempt(transport, p.traceUpstream(outreq, upstream, record))
+			if res != nil {
+				status = res.StatusCode
+			}
+			rs.report(key, err, status)
+		}
+		if err != nil {
+			trail = append(trail, key+" "+err.Error())
+		} else {
+			trail = append(trail, key+" "+strconv.Itoa(status))
+		}
+		if replay == nil || attempt > rs.Retries || p.Upstreams == nil && errors.Is(err, ErrCircuitOpen) ||
+			!rs.retry(outreq, err, status) {
+			return res, upstream, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		if upstream != nil {
+			p.Upstreams.Done(upstream, err, status)
+		}
+		if err := rs.sleep(outreq.Context(), attempt); err != nil {
+			return nil, nil, err
+		}
+		outreq.Body = replay()
+	}
+}
+
+// 记录上游服务地址和状态
+func (p *GatewayProxy) traceUpstream(outreq *http.Request, upstream *Upstream, record IRecord) *http.Request {
+	if record == nil {
+		return outreq
+	}
+	return outreq.WithContext(httptrace.WithClientTrace(outreq.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if upstream != nil {
+				record.SetUpstream(info.Conn.RemoteAddr().String() + " " + p.Upstreams.Status(upstream))
+			} else {
+				record.SetUpstream(info.Conn.RemoteAddr().String()) // record upstream address
+			}
+		},
+	}))
+}
@@ -0,0 +1,91 @@
+package gtw_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// go test -v z/ze/gtw/resilience_test.go -run TestResilience
+func TestResilience(t *testing.T) {
+	var fails, calls atomic.Int32
+	var slow atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		calls.Add(1)
+		if slow.Load() {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if fails.Add(-1) >= 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bts, _ := io.ReadAll(rr.Body)
+		rw.Write(append([]byte("ok:"), bts...))
+	}))
+	defer srv.Close()
+
+	rs, err := gtw.ParseResilientPolicy("response=100ms;retries=2;retryon=503;backoff=1ms;breaker=4;open=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gtw.ParseResilientPolicy("retries=x"); err == nil {
+		t.Fatal("expect parse error")
+	}
+	trails := make(chan string, 10)
+	gw, _ := gtw.NewTargetGatewayV2(srv.URL)
+	gw.Resilience = rs
+	gw.RecordPool = gtw.NewRecordPool(func(rt gtw.IRecord) {
+		trail, _ := rt.(*gtw.Record0).Expand["upstreams"].(string)
+		trails <- trail
+	}, false)
+	call := func(method, body string) (int, string, string) {
+		calls.Store(0)
+		req := httptest.NewRequest(method, "/api", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		select {
+		case trail := <-trails:
+			return rec.Code, rec.Body.String(), trail
+		case <-time.After(time.Second):
+			t.Fatal("record not saved")
+		}
+		return 0, "", ""
+	}
+
+	// 重试幂等请求， 重放请求体
+	fails.Store(2)
+	if code, body, trail := call("PUT", "data"); code != 200 || body != "ok:data" || strings.Count(trail, ",") != 2 {
+		t.Fatal("retry", code, body, trail)
+	}
+	// 非幂等请求不重试
+	fails.Store(1)
+	if code, _, _ := call("POST", "data"); code != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatal("post retry", code, calls.Load())
+	}
+	// 响应超时， 返回 504， 连续失败(含上面的 503)后熔断
+	slow.Store(true)
+	if code, _, trail := call("GET", ""); code != http.StatusGatewayTimeout || !strings.Contains(trail, "response: upstream timeout") {
+		t.Fatal("timeout", code, trail)
+	}
+	if code, _, trail := call("GET", ""); code != http.StatusServiceUnavailable || calls.Load() != 0 {
+		t.Fatal("breaker open", code, trail)
+	}
+	if st := rs.State(strings.TrimPrefix(srv.URL, "http://")); st != "open" {
+		t.Fatal("state", st)
+	}
+	// 半开状态探测成功后恢复
+	slow.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	if code, body, _ := call("GET", ""); code != 200 || body != "ok:" {
+		t.Fatal("half-open", code, body)
+	}
+	if st := rs.State(strings.TrimPrefix(srv.URL, "http://")); st != "closed" {
+		t.Fatal("state", st)
+	}
+}
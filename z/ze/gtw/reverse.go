@@ -20,9 +20,12 @@ import (
 	"net/http/httptrace"
 	"net/textproto"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/suisrc/zgg/z"
 )
 
 // A ProxyRequest contains a request to be rewritten by a [ReverseProxy].
@@ -48,7 +51,9 @@ type ProxyRequest struct {
 //     on whether the inbound request was made on a TLS-enabled connection.
 //
 // If the outbound request contains an existing X-Forwarded-For header,
-// SetXForwarded appends the client IP address to it. To append to the
+// SetXForwarded appends the client IP address to it. If the inbound request
+// comes from a trusted proxy (z.IsTrustedProxy), the inbound X-Forwarded-Host
+// and X-Forwarded-Proto headers are retained. To append to the
 // inbound request's X-Forwarded-For header (the default behavior of
 // [ReverseProxy] when using a Director function), copy the header
 // from the inbound request before calling SetXForwarded:
@@ -68,14 +73,33 @@ func (r *ProxyRequest) SetXForwarded() {
 	} else {
 		r.Out.Header.Del("X-Forwarded-For")
 	}
-	r.Out.Header.Set("X-Forwarded-Host", r.In.Host)
-	if r.In.TLS == nil {
+	trusted := z.IsTrustedProxy(r.In.RemoteAddr)
+	if host := r.In.Header.Get("X-Forwarded-Host"); trusted && host != "" {
+		r.Out.Header.Set("X-Forwarded-Host", host)
+	} else {
+		r.Out.Header.Set("X-Forwarded-Host", r.In.Host)
+	}
+	if proto := r.In.Header.Get("X-Forwarded-Proto"); trusted && proto != "" {
+		r.Out.Header.Set("X-Forwarded-Proto", proto)
+	} else if r.In.TLS == nil {
 		r.Out.Header.Set("X-Forwarded-Proto", "http")
 	} else {
 		r.Out.Header.Set("X-Forwarded-Proto", "https")
 	}
 }
 
+// 清除不可信来源的转发头部， 仅清除与原始请求一致的值(由客户端传入)， Director 设置的值会保留
+func stripForwarded(req, outreq *http.Request) {
+	if z.IsTrustedProxy(req.RemoteAddr) {
+		return
+	}
+	for _, key := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-Ip"} {
+		if vals, ok := outreq.Header[key]; ok && vals != nil && slices.Equal(vals, req.Header[key]) {
+			outreq.Header.Del(key)
+		}
+	}
+}
+
 // ReverseProxy is an HTTP Handler that takes an incoming request and
 // sends it to another server, proxying the response back to the
 // client.
@@ -217,7 +241,14 @@ var hopHeaders = []string{
 
 func (p *ReverseProxy) defaultErrorHandler(rw http.ResponseWriter, req *http.Request, err error) {
 	p.Logf("error: %v", err)
-	rw.WriteHeader(http.StatusBadGateway)
+	switch {
+	case errors.Is(err, ErrUpstreamTimeout), errors.Is(err, context.DeadlineExceeded):
+		rw.WriteHeader(http.StatusGatewayTimeout)
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrNoUpstream):
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		rw.WriteHeader(http.StatusBadGateway)
+	}
 }
 
 func (p *ReverseProxy) GetErrorHandler() func(http.ResponseWriter, *http.Request, error) {
@@ -345,6 +376,7 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.Rewrite(pr)
 		outreq = pr.Out
 	} else {
+		stripForwarded(req, outreq)
 		if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
 			// If we aren't the first proxy retain prior
 			// X-Forwarded-For information as a comma+space
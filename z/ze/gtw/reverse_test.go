@@ -6,9 +6,12 @@ package gtw_test
 
 import (
 	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/ze/gtw"
 )
 
@@ -31,3 +34,60 @@ func Test_lower(t *testing.T) {
 	t.Log(buf.String())
 
 }
+
+// go test -v z/ze/gtw/reverse_test.go -run Test_forwarded
+
+func Test_forwarded(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		rw.Write([]byte(rr.Header.Get("X-Forwarded-For") + "|" + rr.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	proxy := gtw.NewSingleProxy(target)
+
+	defer z.SetTrustedProxies(z.TrustedProxyDef)
+	z.SetTrustedProxies([]string{"10.0.0.0/8"})
+	for remote, expect := range map[string]string{
+		"10.0.0.1:1234": "9.9.9.9, 10.0.0.1|https", // 可信代理， 保留转发头部
+		"1.2.3.4:1234":  "1.2.3.4|",                // 不可信来源， 清除转发头部
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", "9.9.9.9")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if body := rec.Body.String(); body != expect {
+			t.Errorf("%s: %s != %s", remote, body, expect)
+		}
+	}
+}
+
+// go test -v z/ze/gtw/reverse_test.go -run Test_forwardedGateway
+func Test_forwardedGateway(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		rw.Write([]byte(rr.Header.Get("X-Forwarded-For") + "|" + rr.Header.Get("X-Forwarded-Proto") + "|" +
+			rr.Header.Get("Forwarded") + "|" + rr.Header.Get("X-Real-Ip")))
+	}))
+	defer backend.Close()
+	gw, _ := gtw.NewTargetGatewayV2(backend.URL)
+
+	defer z.SetTrustedProxies(z.TrustedProxyDef)
+	z.SetTrustedProxies([]string{"10.0.0.0/8"})
+	for remote, expect := range map[string]string{
+		"10.0.0.1:1234": "9.9.9.9, 10.0.0.1|https|for=9.9.9.9|9.9.9.9", // 可信代理， 保留转发头部
+		"1.2.3.4:1234":  "1.2.3.4|||",                                  // 不可信来源， 清除转发头部
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", "9.9.9.9")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("Forwarded", "for=9.9.9.9")
+		req.Header.Set("X-Real-Ip", "9.9.9.9")
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		if body := rec.Body.String(); body != expect {
+			t.Errorf("%s: %s != %s", remote, body, expect)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 转换规则操作
+const (
+	OpReqSet    = "req.set"   // req.set Name value
+	OpReqAdd    = "req.add"   // req.add Name value
+	OpReqDel    = "req.del"   // req.del Name
+	OpRespSet   = "resp.set"  // resp.set Name value
+	OpRespAdd   = "resp.add"  // resp.add Name value
+	OpRespDel   = "resp.del"  // resp.del Name
+	OpQuerySet  = "query.set" // query.set name value
+	OpQueryAdd  = "query.add" // query.add name value
+	OpQueryDel  = "query.del" // query.del name
+	OpPath      = "path"      // path ^/old/(.*)$ /new/$1
+	OpRedirect  = "redirect"  // redirect ^/old/(.*)$ https://example.com/$1 301
+	OpBody      = "body"      // body old new, 只处理有 Content-Length 的文本类型的响应
+	maxBodySize = 8 << 20
+)
+
+type TransformRule struct {
+	Op     string
+	Name   string         // 头部或者参数名称， body 为查找的内容
+	Value  string         // 值， 支持 ${trace} ${ip} ${route} ${host} ${method} ${path} ${query} ${scheme} ${time} ${status} ${header.Name} ${query.name} ${cookie.name}
+	Regexp *regexp.Regexp // path 和 redirect 的匹配规则
+	Code   int            // redirect 状态码
+}
+
+// 请求和响应转换， 通过 GatewayProxy.Transform 使用
+// 请求转换在鉴权之后， Director 之前执行， path 和 redirect 匹配客户端请求的路径
+// 请求转换使用 ${header.x} ${cookie.x} ${ip} 时， 转发的请求因人而异， 不使用响应缓存
+type Transform struct {
+	Route   string // 路由, ${route}
+	MaxBody int64  // 替换响应体的最大大小， 超过后不替换
+	Rules   []*TransformRule
+	body    bool // 存在 body 规则， 请求需要删除 Accept-Encoding
+	trace   bool // 存在 ${trace}, 请求需要 X-Request-Id
+	nocache bool // 请求转换使用了客户端的请求头， cookie 或者地址， 转发的请求与缓存 key 不一致
+}
+
+// 解析转换规则， 规则之间使用 ; 分割， 参数之间使用空格分割， 包含空格或者 ; 的参数使用双引号
+// 如: req.set X-Trace ${trace}; resp.del Server; path ^/v1/(.*)$ /v2/$1; body "http://old" "https://new"
+func ParseTransform(route, str string) (*Transform, error) {
+	tf := &Transform{Route: route, MaxBody: maxBodySize}
+	rules, err := splitRules(str)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range rules {
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("transform parse %s error: %v", line, err)
+		} else if len(args) == 0 {
+			continue
+		}
+		rule := &TransformRule{Op: strings.ToLower(args[0])}
+		switch {
+		case rule.Op == OpReqDel || rule.Op == OpRespDel || rule.Op == OpQueryDel:
+			if len(args) != 2 {
+				err = errors.New("need name")
+				break
+			}
+			rule.Name = args[1]
+		case rule.Op == OpReqSet || rule.Op == OpReqAdd || rule.Op == OpRespSet || rule.Op == OpRespAdd ||
+			rule.Op == OpQuerySet || rule.Op == OpQueryAdd || rule.Op == OpBody:
+			if len(args) != 3 || args[1] == "" {
+				err = errors.New("need name and value")
+				break
+			}
+			rule.Name, rule.Value = args[1], args[2]
+			tf.body = tf.body || rule.Op == OpBody
+		case rule.Op == OpPath || rule.Op == OpRedirect:
+			if len(args) < 3 || len(args) > 4 || rule.Op == OpPath && len(args) != 3 {
+				err = errors.New("need regexp and replacement")
+				break
+			}
+			if rule.Regexp, err = regexp.Compile(args[1]); err != nil {
+				break
+			}
+			rule.Value, rule.Code = args[2], http.StatusFound
+			if len(args) == 4 {
+				if rule.Code, err = strconv.Atoi(args[3]); err == nil && (rule.Code < 300 || rule.Code > 399) {
+					err = errors.New("redirect code must be 3xx")
+				}
+			}
+		default:
+			err = errors.New("unknow operation")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transform parse %s error: %v", line, err)
+		}
+		tf.Rules = append(tf.Rules, rule)
+		tf.trace = tf.trace || strings.Contains(rule.Value, "${trace}")
+		if rule.Op == OpReqSet || rule.Op == OpReqAdd || rule.Op == OpQuerySet || rule.Op == OpQueryAdd || rule.Op == OpPath {
+			tf.nocache = tf.nocache || strings.Contains(rule.Value, "${header.") ||
+				strings.Contains(rule.Value, "${cookie.") || strings.Contains(rule.Value, "${ip}")
+		}
+	}
+	return tf, nil
+}
+
+// 转发的请求是否只由请求地址决定， 可以使用响应缓存
+func (tf *Transform) Cacheable() bool {
+	return !tf.nocache
+}
+
+// 按 ; 分割规则， 忽略引号中的 ;
+func splitRules(str string) ([]string, error) {
+	rules, start, quote := []string{}, 0, false
+	for i := 0; i < len(str); i++ {
+		switch {
+		case quote && str[i] == '\\':
+			i++
+		case str[i] == '"':
+			quote = !quote
+		case !quote && str[i] == ';':
+			rules = append(rules, str[start:i])
+			start = i + 1
+		}
+	}
+	if quote {
+		return nil, errors.New("transform parse error: unclosed quote")
+	}
+	return append(rules, str[start:]), nil
+}
+
+// 按空格分割参数， 双引号中的内容使用 strconv.Unquote 解析
+func splitArgs(str string) ([]string, error) {
+	args := []string{}
+	for str = strings.TrimSpace(str); str != ""; str = strings.TrimSpace(str) {
+		if str[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(str)
+			if err != nil {
+				return nil, err
+			}
+			val, _ := strconv.Unquote(quoted)
+			args, str = append(args, val), str[len(quoted):]
+			continue
+		}
+		idx := strings.IndexAny(str, " \t")
+		if idx < 0 {
+			idx = len(str)
+		}
+		args, str = append(args, str[:idx]), str[idx:]
+	}
+	return args, nil
+}
+
+// -----------------------------------------------------------------------------------
+
+// 转换请求， 返回 false 表示已经重定向
+func (tf *Transform) Request(rw http.ResponseWriter, req *http.Request, rt IRecord) bool {
+	if tf.trace && req.Header.Get("X-Request-Id") == "" {
+		traceid, _ := GenUUIDv4()
+		req.Header.Set("X-Request-Id", traceid)
+	}
+	vars := tf.vars(req, nil)
+	var query url.Values
+	for _, rule := range tf.Rules {
+		switch rule.Op {
+		case OpRedirect:
+			if !rule.Regexp.MatchString(req.URL.Path) {
+				continue
+			}
+			target := rule.Regexp.ReplaceAllString(req.URL.Path, expand(rule.Value, literal(vars)))
+			http.Redirect(rw, req, target, rule.Code)
+			if rt != nil {
+				rt.LogResponse(&http.Response{StatusCode: rule.Code, Header: rw.Header()})
+				RecordExpand(rt, "transform", "redirect "+target)
+			}
+			return false
+		case OpPath:
+			if rule.Regexp.MatchString(req.URL.Path) {
+				req.URL.Path = rule.Regexp.ReplaceAllString(req.URL.Path, expand(rule.Value, literal(vars)))
+				req.URL.RawPath = ""
+			}
+		case OpReqSet:
+			req.Header.Set(rule.Name, expand(rule.Value, vars))
+		case OpReqAdd:
+			req.Header.Add(rule.Name, expand(rule.Value, vars))
+		case OpReqDel:
+			req.Header.Del(rule.Name)
+		case OpQuerySet, OpQueryAdd, OpQueryDel:
+			if query == nil {
+				query = req.URL.Query()
+			}
+			switch rule.Op {
+			case OpQuerySet:
+				query[rule.Name] = []string{expand(rule.Value, vars)}
+			case OpQueryAdd:
+				query[rule.Name] = append(query[rule.Name], expand(rule.Value, vars))
+			default:
+				delete(query, rule.Name)
+			}
+		}
+	}
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+	if tf.body {
+		req.Header.Del("Accept-Encoding") // 需要替换响应体， 使用未压缩的内容
+	}
+	return true
+}
+
+// 转换响应， 请求为转发的请求
+func (tf *Transform) Response(req *http.Request, res *http.Response) error {
+	vars := tf.vars(req, res)
+	for _, rule := range tf.Rules {
+		switch rule.Op {
+		case OpRespSet:
+			res.Header.Set(rule.Name, expand(rule.Value, vars))
+		case OpRespAdd:
+			res.Header.Add(rule.Name, expand(rule.Value, vars))
+		case OpRespDel:
+			res.Header.Del(rule.Name)
+		}
+	}
+	if !tf.body || !textual(res) {
+		return nil
+	}
+	if res.ContentLength < 0 || res.ContentLength > tf.MaxBody {
+		return nil // 没有 Content-Length 的响应可能是流式的， 不缓冲
+	}
+	body, rest := bufferBody(res.Body, tf.MaxBody)
+	if rest != nil {
+		res.Body = rest // 超过最大值， 不替换
+		return nil
+	}
+	for _, rule := range tf.Rules {
+		if rule.Op == OpBody {
+			body = bytes.ReplaceAll(body, []byte(rule.Name), []byte(expand(rule.Value, vars)))
+		}
+	}
+	res.Body, res.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		res.Header.Set("ETag", "W/"+etag) // 内容已经修改， 使用弱验证器
+	}
+	return nil
+}
+
+// 是否为未压缩的文本类型
+func textual(res *http.Response) bool {
+	if ce := res.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return false
+	}
+	ct, _, _ := strings.Cut(strings.ToLower(res.Header.Get("Content-Type")), ";")
+	ct = strings.TrimSpace(ct)
+	if ct == "text/event-stream" || ct == "application/x-ndjson" {
+		return false // 流式响应
+	}
+	return strings.HasPrefix(ct, "text/") || strings.HasSuffix(ct, "json") || strings.HasSuffix(ct, "xml") ||
+		strings.HasSuffix(ct, "javascript")
+}
+
+// 模板变量
+func (tf *Transform) vars(req *http.Request, res *http.Response) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		switch key {
+		case "trace":
+			return req.Header.Get("X-Request-Id"), true
+		case "ip":
+			return GetRemoteIP(req), true
+		case "route":
+			return tf.Route, true
+		case "host":
+			return req.Host, true
+		case "method":
+			return req.Method, true
+		case "path":
+			return req.URL.Path, true
+		case "query":
+			return req.URL.RawQuery, true
+		case "scheme":
+			if scheme := req.Header.Get("X-Scheme"); scheme != "" {
+				return scheme, true
+			} else if req.TLS != nil {
+				return "https", true
+			}
+			return "http", true
+		case "time":
+			return time.Now().Format(time.RFC3339), true
+		case "status":
+			if res != nil {
+				return strconv.Itoa(res.StatusCode), true
+			}
+			return "", true
+		}
+		if name, ok := strings.CutPrefix(key, "header."); ok {
+			return req.Header.Get(name), true
+		} else if name, ok := strings.CutPrefix(key, "query."); ok {
+			return req.URL.Query().Get(name), true
+		} else if name, ok := strings.CutPrefix(key, "cookie."); ok {
+			if ck, err := req.Cookie(name); err == nil {
+				return ck.Value, true
+			}
+			return "", true
+		}
+		return "", false
+	}
+}
+
+// 正则替换的模板变量， 值中的 $ 转义， 避免客户端的值被当作分组引用
+func literal(vars func(string) (string, bool)) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := vars(key)
+		return strings.ReplaceAll(val, "$", "$$"), ok
+	}
+}
+
+// 替换模板变量， 未知的变量保留原样(正则替换的 ${1})
+func expand(str string, vars func(string) (string, bool)) string {
+	if !strings.Contains(str, "${") {
+		return str
+	}
+	sbr := strings.Builder{}
+	for {
+		start := strings.Index(str, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(str[start:], '}')
+		if end < 0 {
+			break
+		}
+		sbr.WriteString(str[:start])
+		if val, ok := vars(str[start+2 : start+end]); ok {
+			sbr.WriteString(val)
+		} else {
+			sbr.WriteString(str[start : start+end+1])
+		}
+		str = str[start+end+1:]
+	}
+	sbr.WriteString(str)
+	return sbr.String()
+}
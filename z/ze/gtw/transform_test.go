@@ -0,0 +1,103 @@
+package gtw_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// go test -v z/ze/gtw/transform_test.go -run TestTransform
+func TestTransform(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		rw.Header().Set("Server", "backend")
+		rw.Header().Set("ETag", `"v1"`)
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("X-Echo", rr.URL.RequestURI()+"|"+rr.Header.Get("X-Route")+"|"+rr.Header.Get("X-Debug"))
+		rw.Write([]byte(`<a href="http://old.example.com/a">a</a>`))
+	}))
+	defer srv.Close()
+
+	if _, err := gtw.ParseTransform("api", "req.set X-A"); err == nil {
+		t.Fatal("expect parse error")
+	}
+	tf, err := gtw.ParseTransform("api", `redirect ^/old/(.*)$ /new/$1 301; path ^/v1/(.*)$ /v2/$1;
+		req.set X-Route "${route}; ${header.X-User}"; req.del X-Debug;
+		query.set lang zh; query.add tag ${ip}; query.del token;
+		resp.del Server; resp.set X-Trace ${trace}; body http://old.example.com https://new.example.com`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, _ := gtw.NewTargetGatewayV2(srv.URL)
+	gw.Transform = tf
+
+	req := httptest.NewRequest("GET", "/v1/users?token=x&tag=a", nil)
+	req.Header.Set("X-User", "u1")
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Request-Id", "trace-1")
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if echo := rec.Header().Get("X-Echo"); echo != "/v2/users?lang=zh&tag=a&tag=192.0.2.1|api; u1|" {
+		t.Fatal("request", echo)
+	}
+	if rec.Header().Get("Server") != "" || rec.Header().Get("X-Trace") != "trace-1" || rec.Header().Get("ETag") != `W/"v1"` {
+		t.Fatal("response header", rec.Header())
+	}
+	if body := rec.Body.String(); body != `<a href="https://new.example.com/a">a</a>` {
+		t.Fatal("body", body)
+	}
+
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest("GET", "/old/page", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/new/page" {
+		t.Fatal("redirect", rec.Code, rec.Header())
+	}
+}
+
+// go test -v z/ze/gtw/transform_test.go -run TestTransformLimit
+func TestTransformLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		rw.Header().Set("X-Echo", rr.URL.Path+"|"+rr.Header.Get("X-Request-Id")+"|"+rr.Header.Get("Accept-Encoding"))
+	}))
+	defer srv.Close()
+
+	// 客户端的值中的 $ 不作为分组引用， 没有 ${trace} 不生成 X-Request-Id, 没有 body 规则保留 Accept-Encoding
+	tf, err := gtw.ParseTransform("api", `path ^/u/(.*)$ /x/${header.X-Name}/$1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.Cacheable() {
+		t.Fatal("expect not cacheable")
+	}
+	gw, _ := gtw.NewTargetGatewayV2(srv.URL)
+	gw.Transform = tf
+	req := httptest.NewRequest("GET", "/u/abc", nil)
+	req.Header.Set("X-Name", "$1")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if echo := rec.Header().Get("X-Echo"); echo != "/x/$1/abc||gzip" {
+		t.Fatal("request", echo)
+	}
+	if tf, _ := gtw.ParseTransform("api", `req.set X-Trace ${trace}; query.set lang zh`); !tf.Cacheable() {
+		t.Fatal("expect cacheable")
+	}
+
+	// 流式响应和没有 Content-Length 的响应不缓冲
+	tf, _ = gtw.ParseTransform("api", `body old new`)
+	for _, cc := range []struct {
+		ct  string
+		len int64
+	}{{"text/event-stream", 3}, {"text/plain", -1}, {"application/x-ndjson", 3}} {
+		res := &http.Response{Header: http.Header{"Content-Type": {cc.ct}}, ContentLength: cc.len, Body: io.NopCloser(strings.NewReader("old"))}
+		if err := tf.Response(httptest.NewRequest("GET", "/", nil), res); err != nil {
+			t.Fatal(err)
+		}
+		if bts, _ := io.ReadAll(res.Body); string(bts) != "old" {
+			t.Fatal("expect not rewrite", cc.ct, string(bts))
+		}
+	}
+}
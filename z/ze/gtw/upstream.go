@@ -0,0 +1,372 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package gtw
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var ErrNoUpstream = errors.New("no healthy upstream")
+
+const (
+	PolicyRound  = "round"  // 轮询
+	PolicyWeight = "weight" // 平滑加权轮询
+	PolicyLeast  = "least"  // 最少连接(按权重)
+	PolicyHash   = "hash"   // 一致性哈希
+)
+
+// 上游服务
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+	active atomic.Int64 // 活动请求数
+	down   atomic.Bool  // 主动健康检查失败
+	fails  int          // 连续失败次数, 被动检查
+	eject  time.Time    // 被动检查剔除， 到期后恢复
+	rise   int          // 主动检查连续成功次数
+	fall   int          // 主动检查连续失败次数
+	cw     int          // 平滑加权轮询当前权重
+}
+
+func (up *Upstream) State() string {
+	if up.down.Load() {
+		return "down"
+	}
+	return "up"
+}
+
+// 上游服务池， 通过 GatewayProxy.Upstreams 使用， 路径使用网关的 Director 处理， 这里只替换协议和地址
+type UpstreamPool struct {
+	Upstreams []*Upstream
+	Policy    string        // round, weight, least, hash
+	HashKey   string        // hash 策略的 key: header:Name, cookie:Name, ip
+	Health    string        // 主动健康检查路径， 为空不检查
+	Interval  time.Duration // 健康检查间隔
+	Timeout   time.Duration // 健康检查超时
+	Rise      int           // 连续成功 rise 次后恢复
+	Fall      int           // 连续失败 fall 次后标记为 down
+	MaxFails  int           // 被动检查， 连续失败(5xx, 连接错误)次数， 0 不检查
+	EjectTime time.Duration // 被动检查， 剔除时间
+	Client    *http.Client  // 健康检查客户端
+	ring      []ringNode
+	index     uint64
+	lock      sync.Mutex
+}
+
+type ringNode struct {
+	hash uint32
+	up   *Upstream
+}
+
+func NewUpstreamPool(targets ...string) (*UpstreamPool, error) {
+	pool := &UpstreamPool{
+		Policy:    PolicyRound,
+		HashKey:   "ip",
+		Interval:  5 * time.Second,
+		Timeout:   2 * time.Second,
+		Rise:      2,
+		Fall:      3,
+		MaxFails:  3,
+		EjectTime: 30 * time.Second,
+	}
+	for _, target := range targets {
+		if err := pool.Add(target, 1); err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// 解析上游服务池, 格式: http://a:80=3|http://b:80/?k=v#1;policy=weight;hash=header:X-User;health=/healthz;interval=5s;timeout=2s;fails=3;eject=30s
+func ParseUpstreamPool(str string) (*UpstreamPool, error) {
+	targets, opts, _ := strings.Cut(str, ";")
+	pool, err := NewUpstreamPool()
+	if err != nil {
+		return nil, err
+	}
+	for target := range strings.SplitSeq(targets, "|") {
+		if target = strings.TrimSpace(target); target == "" {
+			continue
+		}
+		// 权重使用 =N 后缀， 地址带有查询参数时使用 #N 后缀， 避免与查询参数中的 = 混淆
+		weight, idx := 1, strings.IndexByte(target, '#')
+		if idx < 0 && !strings.ContainsRune(target, '?') {
+			idx = strings.LastIndexByte(target, '=')
+		}
+		if idx > 0 {
+			if weight, err = strconv.Atoi(target[idx+1:]); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("upstream weight invalid: %s", target)
+			}
+			target = target[:idx]
+		}
+		if err := pool.Add(target, weight); err != nil {
+			return nil, err
+		}
+	}
+	if len(pool.Upstreams) == 0 {
+		return nil, errors.New("upstream is empty")
+	}
+	for kv := range strings.SplitSeq(opts, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		switch key {
+		case "":
+			continue
+		case "policy":
+			if !slices.Contains([]string{PolicyRound, PolicyWeight, PolicyLeast, PolicyHash}, val) {
+				err = errors.New("unknow policy")
+			}
+			pool.Policy = val
+		case "hash":
+			kind, name, _ := strings.Cut(val, ":")
+			if kind != "ip" && (kind != "header" && kind != "cookie" || name == "") {
+				err = errors.New("hash key must be header:Name, cookie:Name or ip")
+			}
+			pool.HashKey = val
+		case "health":
+			pool.Health = val
+		case "interval":
+			pool.Interval, err = time.ParseDuration(val)
+		case "timeout":
+			pool.Timeout, err = time.ParseDuration(val)
+		case "rise":
+			pool.Rise, err = strconv.Atoi(val)
+		case "fall":
+			pool.Fall, err = strconv.Atoi(val)
+		case "fails":
+			pool.MaxFails, err = strconv.Atoi(val)
+		case "eject":
+			pool.EjectTime, err = time.ParseDuration(val)
+		default:
+			err = errors.New("unknow field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("upstream parse %s error: %v", kv, err)
+		}
+	}
+	return pool, nil
+}
+
+func (pool *UpstreamPool) Add(target string, weight int) error {
+	uu, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if uu.Host == "" {
+		return fmt.Errorf("upstream host is empty: %s", target)
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.Upstreams = append(pool.Upstreams, &Upstream{URL: uu, Weight: max(weight, 1)})
+	pool.ring = nil // 重建哈希环
+	return nil
+}
+
+// 是否可用， 需要持有锁
+func (pool *UpstreamPool) available(up *Upstream, now time.Time) bool {
+	return !up.down.Load() && !now.Before(up.eject)
+}
+
+// 选择上游服务， 没有可用服务时返回 nil
+func (pool *UpstreamPool) Pick(rr *http.Request) *Upstream {
+	now := time.Now()
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	var pick *Upstream
+	switch pool.Policy {
+	case PolicyHash:
+		pick = pool.hash(rr, now)
+	case PolicyLeast:
+		for _, up := range pool.Upstreams {
+			if !pool.available(up, now) {
+				continue
+			}
+			// active/weight 比较， 使用乘法避免除法
+			if pick == nil || up.active.Load()*int64(pick.Weight) < pick.active.Load()*int64(up.Weight) {
+				pick = up
+			}
+		}
+	case PolicyWeight:
+		total := 0
+		for _, up := range pool.Upstreams {
+			if !pool.available(up, now) {
+				continue
+			}
+			up.cw += up.Weight
+			total += up.Weight
+			if pick == nil || up.cw > pick.cw {
+				pick = up
+			}
+		}
+		if pick != nil {
+			pick.cw -= total
+		}
+	default:
+		for range pool.Upstreams {
+			up := pool.Upstreams[pool.index%uint64(len(pool.Upstreams))]
+			pool.index++
+			if pool.available(up, now) {
+				pick = up
+				break
+			}
+		}
+	}
+	if pick != nil {
+		pick.active.Add(1)
+	}
+	return pick
+}
+
+// 一致性哈希， 虚拟节点数量与权重成正比
+func (pool *UpstreamPool) hash(rr *http.Request, now time.Time) *Upstream {
+	if pool.ring == nil {
+		for _, up := range pool.Upstreams {
+			for i := range up.Weight * 100 {
+				hh := crc32.ChecksumIEEE([]byte(up.URL.Host + "#" + strconv.Itoa(i)))
+				pool.ring = append(pool.ring, ringNode{hash: hh, up: up})
+			}
+		}
+		slices.SortFunc(pool.ring, func(a, b ringNode) int { return cmp.Compare(a.hash, b.hash) })
+	}
+	if len(pool.ring) == 0 {
+		return nil
+	}
+	key := ""
+	kind, name, _ := strings.Cut(pool.HashKey, ":")
+	switch kind {
+	case "header":
+		key = rr.Header.Get(name)
+	case "cookie":
+		if ck, err := rr.Cookie(name); err == nil {
+			key = ck.Value
+		}
+	default:
+		key = z.GetRemoteIP(rr)
+	}
+	hh := crc32.ChecksumIEEE([]byte(key))
+	idx, _ := slices.BinarySearchFunc(pool.ring, hh, func(n ringNode, t uint32) int { return cmp.Compare(n.hash, t) })
+	for i := range pool.ring {
+		if node := pool.ring[(idx+i)%len(pool.ring)]; pool.available(node.up, now) {
+			return node.up
+		}
+	}
+	return nil
+}
+
+// 请求结束， 被动检查: 连续 MaxFails 次失败(连接错误, 5xx)后剔除 EjectTime
+func (pool *UpstreamPool) Done(up *Upstream, err error, status int) {
+	up.active.Add(-1)
+	if pool.MaxFails <= 0 {
+		return
+	}
+	// 熔断时没有请求上游服务， 不计入失败
+	failed := err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, ErrCircuitOpen) || status >= http.StatusInternalServerError
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	if !failed {
+		up.fails = 0
+		return
+	}
+	if up.fails++; up.fails >= pool.MaxFails {
+		up.fails = 0
+		up.eject = time.Now().Add(pool.EjectTime)
+		z.Logn("[upstream]: eject", up.URL.Host, "for", pool.EjectTime)
+	}
+}
+
+// 上游服务状态， 用于记录日志
+func (pool *UpstreamPool) Status(up *Upstream) string {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	state := up.State()
+	if time.Now().Before(up.eject) {
+		state = "ejected"
+	}
+	return fmt.Sprintf("%s [%s,w=%d,conn=%d,fails=%d]", up.URL.Host, state, up.Weight, up.active.Load(), up.fails)
+}
+
+// -----------------------------------------------------------------------------------
+
+// 启动主动健康检查， 返回停止函数
+func (pool *UpstreamPool) Start() func() {
+	if pool.Health == "" || pool.Interval <= 0 {
+		return func() {}
+	}
+	client := pool.Client
+	if client == nil {
+		client = &http.Client{Timeout: pool.Timeout, Transport: TransportGtw}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(pool.Interval)
+		defer ticker.Stop()
+		for {
+			pool.check(ctx, client)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+func (pool *UpstreamPool) check(ctx context.Context, client *http.Client) {
+	wg := sync.WaitGroup{}
+	for _, up := range pool.Upstreams {
+		wg.Go(func() {
+			target := *up.URL
+			target.Path, target.RawQuery = pool.Health, ""
+			ok := false
+			if req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil); err == nil {
+				if resp, err := client.Do(req); err == nil {
+					resp.Body.Close()
+					ok = resp.StatusCode < http.StatusInternalServerError
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			pool.lock.Lock()
+			defer pool.lock.Unlock()
+			if ok {
+				up.fall = 0
+				if up.rise++; up.rise >= pool.Rise && up.down.Load() {
+					up.down.Store(false)
+					z.Logn("[upstream]: health check up", up.URL.Host)
+				}
+			} else {
+				up.rise = 0
+				if up.fall++; up.fall >= pool.Fall && !up.down.Load() {
+					up.down.Store(true)
+					z.Logn("[upstream]: health check down", up.URL.Host)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// 替换请求的协议和地址
+func (up *Upstream) Rewrite(req *http.Request) {
+	req.URL.Scheme = up.URL.Scheme
+	req.URL.Host = up.URL.Host
+}
@@ -0,0 +1,126 @@
+package gtw_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// go test -v z/ze/gtw/upstream_test.go -run TestUpstreamPool
+func TestUpstreamPool(t *testing.T) {
+	var failA, healthA atomic.Bool
+	healthA.Store(true)
+	backend := func(name string, fail, health *atomic.Bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+			if rr.URL.Path == "/healthz" {
+				if health != nil && !health.Load() {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			if fail != nil && fail.Load() {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			rw.Write([]byte(name))
+		}))
+	}
+	srvA, srvB := backend("a", &failA, &healthA), backend("b", nil, nil)
+	defer srvA.Close()
+	defer srvB.Close()
+
+	pool, err := gtw.ParseUpstreamPool(srvA.URL + "=2|" + srvB.URL + ";policy=weight;fails=2;eject=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, _ := gtw.NewTargetGatewayV2(srvA.URL)
+	gw.Upstreams = pool
+	get := func(user string) string {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		bts, _ := io.ReadAll(rec.Body)
+		return string(bts)
+	}
+	count := func(n int, user string) map[string]int {
+		cnt := map[string]int{}
+		for range n {
+			cnt[get(user)]++
+		}
+		return cnt
+	}
+	// 平滑加权轮询
+	if cnt := count(30, ""); cnt["a"] != 20 || cnt["b"] != 10 {
+		t.Fatal("weight", cnt)
+	}
+	// 一致性哈希
+	pool.Policy, pool.HashKey = gtw.PolicyHash, "header:X-User"
+	for _, user := range []string{"u1", "u2", "u3"} {
+		if cnt := count(5, user); len(cnt) != 1 {
+			t.Fatal("hash", user, cnt)
+		}
+	}
+	// 被动检查， 连续失败后剔除
+	pool.Policy = gtw.PolicyRound
+	failA.Store(true)
+	count(4, "")
+	if cnt := count(4, ""); cnt["b"] != 4 {
+		t.Fatal("eject", cnt)
+	}
+	if st := pool.Status(pool.Upstreams[0]); !strings.Contains(st, "ejected") {
+		t.Fatal(st)
+	}
+	failA.Store(false)
+	time.Sleep(250 * time.Millisecond)
+	if cnt := count(4, ""); cnt["a"] != 2 {
+		t.Fatal("recover", cnt)
+	}
+
+	// 主动健康检查
+	pool.Health, pool.Interval, pool.Rise, pool.Fall = "/healthz", 20*time.Millisecond, 1, 1
+	stop := pool.Start()
+	defer stop()
+	healthA.Store(false)
+	time.Sleep(100 * time.Millisecond)
+	if cnt := count(4, ""); cnt["b"] != 4 {
+		t.Fatal("health down", cnt)
+	}
+	healthA.Store(true)
+	time.Sleep(100 * time.Millisecond)
+	if cnt := count(4, ""); cnt["a"] != 2 {
+		t.Fatal("health up", cnt)
+	}
+
+	// 最少连接
+	pool.Policy = gtw.PolicyLeast
+	up := pool.Pick(httptest.NewRequest("GET", "/", nil))
+	if next := pool.Pick(httptest.NewRequest("GET", "/", nil)); next == up {
+		t.Fatal("least conn")
+	}
+}
+
+// go test -v z/ze/gtw/upstream_test.go -run TestParseUpstreamPool
+func TestParseUpstreamPool(t *testing.T) {
+	pool, err := gtw.ParseUpstreamPool("http://a:80/x=1=3|http://b:80/?k=v=1|http://c:80/?k=v#2;policy=weight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, cc := range []struct {
+		path, query string
+		weight      int
+	}{{"/x=1", "", 3}, {"/", "k=v=1", 1}, {"/", "k=v", 2}} {
+		up := pool.Upstreams[i]
+		if up.URL.Path != cc.path || up.URL.RawQuery != cc.query || up.Weight != cc.weight {
+			t.Fatal(i, up.URL, up.Weight)
+		}
+	}
+	if _, err := gtw.ParseUpstreamPool("http://a:80/?k=v#x"); err == nil {
+		t.Fatal("expect weight error")
+	}
+}
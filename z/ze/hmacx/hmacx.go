@@ -0,0 +1,264 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// HMAC 请求签名， 用于服务间调用， 防止请求被篡改和重放
+//
+//	Authorization: ZGG-HMAC-SHA256 kid=k1,ts=1700000000,nonce=xxx,headers=host;content-type,sig=base64url
+//	X-Content-Sha256: hex(sha256(body))
+
+package hmacx
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Scheme     = "ZGG-HMAC-SHA256"
+	BodyHeader = "X-Content-Sha256"
+)
+
+var (
+	ErrSignMissing  = errors.New("signature missing")
+	ErrSignInvalid  = errors.New("signature invalid")
+	ErrSignFormat   = errors.New("signature format invalid")
+	ErrSignExpired  = errors.New("signature timestamp out of range")
+	ErrSignReplay   = errors.New("signature nonce replayed")
+	ErrKeyNotFound  = errors.New("signature key not found")
+	ErrBodyHash     = errors.New("body hash mismatch")
+	ErrBodyTooLarge = errors.New("body too large")
+)
+
+// 默认签名的请求头
+var DefaultHeaders = []string{"host", "content-type"}
+
+// 签名参数
+type Sign struct {
+	KeyID   string
+	Time    int64
+	Nonce   string
+	Headers []string // 小写
+	Sig     []byte
+}
+
+func (ss *Sign) String() string {
+	return Scheme + " kid=" + ss.KeyID + ",ts=" + strconv.FormatInt(ss.Time, 10) + ",nonce=" + ss.Nonce +
+		",headers=" + strings.Join(ss.Headers, ";") + ",sig=" + base64.RawURLEncoding.EncodeToString(ss.Sig)
+}
+
+// 解析签名参数
+func ParseSign(auth string) (*Sign, error) {
+	if auth == "" {
+		return nil, ErrSignMissing
+	}
+	scheme, params, ok := strings.Cut(auth, " ")
+	if !ok || scheme != Scheme {
+		return nil, ErrSignMissing
+	}
+	ss := &Sign{}
+	var err error
+	for kv := range strings.SplitSeq(params, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch key {
+		case "kid":
+			ss.KeyID = val
+		case "ts":
+			ss.Time, err = strconv.ParseInt(val, 10, 64)
+		case "nonce":
+			ss.Nonce = val
+		case "headers":
+			if val != "" {
+				ss.Headers = strings.Split(val, ";")
+			}
+		case "sig":
+			ss.Sig, err = base64.RawURLEncoding.DecodeString(val)
+		}
+		if err != nil {
+			return nil, ErrSignFormat
+		}
+	}
+	if ss.KeyID == "" || ss.Time == 0 || ss.Nonce == "" || len(ss.Sig) == 0 {
+		return nil, ErrSignFormat
+	}
+	return ss, nil
+}
+
+// 规范查询参数， 使用原始内容按参数排序， 不解码， 避免无法解析的参数被忽略
+func CanonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	pairs := strings.Split(raw, "&")
+	pairs = slices.DeleteFunc(pairs, func(str string) bool { return str == "" })
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// 规范请求， 方法, 路径, 排序的查询参数, 请求头, 签名的请求头, 请求体哈希, 时间戳, 随机数
+func Canonical(rr *http.Request, ss *Sign, bodyHash string) string {
+	buf := strings.Builder{}
+	buf.WriteString(rr.Method)
+	buf.WriteByte('\n')
+	buf.WriteString(rr.URL.EscapedPath())
+	buf.WriteByte('\n')
+	buf.WriteString(CanonicalQuery(rr.URL.RawQuery))
+	buf.WriteByte('\n')
+	for _, hh := range ss.Headers {
+		buf.WriteString(hh)
+		buf.WriteByte(':')
+		if hh == "host" {
+			if host := rr.Host; host != "" {
+				buf.WriteString(host)
+			} else {
+				buf.WriteString(rr.URL.Host) // 客户端请求可能只设置了 URL
+			}
+		} else {
+			vals := rr.Header.Values(hh)
+			for i, val := range vals {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(strings.TrimSpace(val))
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(strings.Join(ss.Headers, ";"))
+	buf.WriteByte('\n')
+	buf.WriteString(bodyHash)
+	buf.WriteByte('\n')
+	buf.WriteString(strconv.FormatInt(ss.Time, 10))
+	buf.WriteByte('\n')
+	buf.WriteString(ss.Nonce)
+	return buf.String()
+}
+
+func Compute(secret []byte, canonical string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return mac.Sum(nil)
+}
+
+// 读取请求体并计算哈希， 请求体会被替换为可重复读取的内容, max <= 0 不限制
+func BodyHash(rr *http.Request, max int64) (string, error) {
+	hash := sha256.New()
+	if rr.Body == nil || rr.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	var reader io.Reader = rr.Body
+	if max > 0 {
+		reader = io.LimitReader(rr.Body, max+1)
+	}
+	bts, err := io.ReadAll(reader)
+	rr.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if max > 0 && int64(len(bts)) > max {
+		return "", ErrBodyTooLarge
+	}
+	rr.Body = io.NopCloser(bytes.NewReader(bts))
+	rr.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(bts)), nil }
+	hash.Write(bts)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func NewNonce() string {
+	bts := make([]byte, 16)
+	rand.Read(bts)
+	return base64.RawURLEncoding.EncodeToString(bts)
+}
+
+// 解析密钥， 格式: kid:secret,kid:secret, 用于密钥轮换
+func ParseSecrets(str string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for kv := range strings.SplitSeq(str, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(kv, ":")
+		if !ok || kid == "" || secret == "" {
+			return nil, fmt.Errorf("hmac secret invalid: %s", kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("hmac secret is empty")
+	}
+	return keys, nil
+}
+
+// -----------------------------------------------------------------------------------
+
+// 签名器
+type Signer struct {
+	KeyID   string
+	Secret  []byte
+	Headers []string // 签名的请求头， 为空使用 DefaultHeaders
+}
+
+func NewSigner(kid string, secret []byte) *Signer {
+	return &Signer{KeyID: kid, Secret: secret}
+}
+
+// 对请求签名， 写入 Authorization 和 X-Content-Sha256 请求头
+func (sn *Signer) Sign(rr *http.Request) error {
+	hash, err := BodyHash(rr, 0)
+	if err != nil {
+		return err
+	}
+	headers := sn.Headers
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	ss := &Sign{KeyID: sn.KeyID, Time: time.Now().Unix(), Nonce: NewNonce()}
+	for _, hh := range headers {
+		if hh = strings.ToLower(hh); !slices.Contains(ss.Headers, hh) {
+			ss.Headers = append(ss.Headers, hh)
+		}
+	}
+	rr.Header.Set(BodyHeader, hash)
+	ss.Sig = Compute(sn.Secret, Canonical(rr, ss, hash))
+	rr.Header.Set("Authorization", ss.String())
+	return nil
+}
+
+// 签名的 http.RoundTripper, 可用于 gtw.NewCustomGatewayV2 和 http.Client
+func NewTransport(signer *Signer, base http.RoundTripper) *Transport {
+	return &Transport{Signer: signer, Base: base}
+}
+
+type Transport struct {
+	Signer *Signer
+	Base   http.RoundTripper // 为空使用 http.DefaultTransport
+}
+
+func (tt *Transport) RoundTrip(rr *http.Request) (*http.Response, error) {
+	// RoundTripper 不应修改原始请求
+	out := rr.Clone(rr.Context())
+	if err := tt.Signer.Sign(out); err != nil {
+		if rr.Body != nil {
+			rr.Body.Close()
+		}
+		return nil, err
+	}
+	base := tt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(out)
+}
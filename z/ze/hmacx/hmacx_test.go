@@ -0,0 +1,114 @@
+package hmacx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/suisrc/zgg/z/ze/hmacx"
+)
+
+// go test -v z/ze/hmacx/hmacx_test.go -run TestSignVerify
+func TestSignVerify(t *testing.T) {
+	vv := hmacx.NewVerifier(map[string][]byte{"k1": []byte("old"), "k2": []byte("new")})
+	var last *http.Request
+	srv := httptest.NewServer(vv.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		last = rr.Clone(rr.Context())
+		bts, _ := io.ReadAll(rr.Body)
+		rw.Write([]byte(hmacx.KeyFrom(rr.Context()) + ":" + string(bts)))
+	})))
+	defer srv.Close()
+
+	// 密钥轮换， 新旧密钥都可以验证
+	for _, kid := range []string{"k1", "k2"} {
+		secret := map[string]string{"k1": "old", "k2": "new"}[kid]
+		client := &http.Client{Transport: hmacx.NewTransport(hmacx.NewSigner(kid, []byte(secret)), nil)}
+		req, _ := http.NewRequest("POST", srv.URL+"/api/x?b=2&a=1", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bts, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(bts) != kid+":hello" {
+			t.Fatal(resp.StatusCode, string(bts))
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Fatal("original request modified")
+		}
+	}
+	if last.Header.Get(hmacx.KeyHeader) != "k2" {
+		t.Fatal("key header", last.Header)
+	}
+
+	do := func(req *http.Request) int {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sign := func(method, path, body string, secret string) *http.Request {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		if err := hmacx.NewSigner("k1", []byte(secret)).Sign(req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	// 重放
+	req := sign("POST", "/api/x", "a", "old")
+	replay, _ := http.NewRequest("POST", req.URL.String(), strings.NewReader("a"))
+	replay.Header = req.Header.Clone()
+	if code := do(req); code != 200 {
+		t.Fatal("expect ok", code)
+	}
+	if code := do(replay); code != http.StatusUnauthorized {
+		t.Fatal("expect replay reject", code)
+	}
+	// 篡改请求体， 路径和查询参数
+	for _, fn := range []func(*http.Request){
+		func(rr *http.Request) { rr.Body = io.NopCloser(strings.NewReader("b")); rr.ContentLength = 1 },
+		func(rr *http.Request) { rr.URL.Path = "/api/y" },
+		func(rr *http.Request) { rr.URL.RawQuery = "a=1" },
+		func(rr *http.Request) { rr.Header.Set("Content-Type", "application/json") },
+	} {
+		req := sign("POST", "/api/x", "a", "old")
+		fn(req)
+		if code := do(req); code != http.StatusUnauthorized {
+			t.Fatal("expect tamper reject", code)
+		}
+	}
+	// 无法解析的查询参数也参与签名
+	req = sign("GET", "/api/x?b=%zz&a=1", "", "old")
+	req.URL.RawQuery = "a=1&b=%yy"
+	if code := do(req); code != http.StatusUnauthorized {
+		t.Fatal("expect raw query tamper reject", code)
+	}
+	if code := do(sign("GET", "/api/x?b=%zz&a=1", "", "old")); code != 200 {
+		t.Fatal("expect raw query ok", code)
+	}
+	// 错误的密钥， 无签名
+	if code := do(sign("GET", "/", "", "bad")); code != http.StatusUnauthorized {
+		t.Fatal("expect bad key reject", code)
+	}
+	req, _ = http.NewRequest("GET", srv.URL+"/", nil)
+	if code := do(req); code != http.StatusUnauthorized {
+		t.Fatal("expect missing reject", code)
+	}
+	// 过期
+	rr := httptest.NewRequest("GET", "/", nil)
+	hmacx.NewSigner("k1", []byte("old")).Sign(rr)
+	auth := rr.Header.Get("Authorization")
+	ss, _ := hmacx.ParseSign(auth)
+	ss.Time -= 3600
+	ss.Sig = hmacx.Compute([]byte("old"), hmacx.Canonical(rr, ss, rr.Header.Get(hmacx.BodyHeader)))
+	rr.Header.Set("Authorization", ss.String())
+	if _, err := vv.Verify(rr); err != hmacx.ErrSignExpired {
+		t.Fatal("expect expired", err)
+	}
+}
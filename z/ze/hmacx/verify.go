@@ -0,0 +1,177 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package hmacx
+
+import (
+	"context"
+	"crypto/hmac"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// 验证成功后写入请求头的密钥 ID
+const KeyHeader = "X-Request-Sky-SignKey"
+
+// 随机数存储， 用于防止重放， 可以使用 redis 等实现多实例共享
+type Nonces interface {
+	// 使用随机数， 已经使用过返回 false
+	Use(nonce string, ttl time.Duration) bool
+}
+
+// 内存随机数存储
+type MemoryNonces struct {
+	Size  int // 最大数量， 超过后清理过期的随机数
+	items map[string]time.Time
+	lock  sync.Mutex
+}
+
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{Size: 100000, items: map[string]time.Time{}}
+}
+
+func (mn *MemoryNonces) Use(nonce string, ttl time.Duration) bool {
+	now := time.Now()
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+	if exp, ok := mn.items[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	if len(mn.items) >= mn.Size {
+		for kk, exp := range mn.items {
+			if now.After(exp) {
+				delete(mn.items, kk)
+			}
+		}
+		if len(mn.items) >= mn.Size {
+			return false // 无法保证不重放， 拒绝
+		}
+	}
+	mn.items[nonce] = now.Add(ttl)
+	return true
+}
+
+// -----------------------------------------------------------------------------------
+
+// 签名验证器
+type Verifier struct {
+	Secrets map[string][]byte // kid = secret, 轮换时同时配置新旧密钥
+	Headers []string          // 必须签名的请求头， 为空使用 DefaultHeaders
+	Skew    time.Duration     // 允许的时间偏差
+	MaxBody int64             // 最大请求体， 超过后拒绝
+	Nonces  Nonces
+}
+
+func NewVerifier(secrets map[string][]byte) *Verifier {
+	return &Verifier{
+		Secrets: secrets,
+		Skew:    5 * time.Minute,
+		MaxBody: 10 << 20,
+		Nonces:  NewMemoryNonces(),
+	}
+}
+
+// 验证请求签名， 返回密钥 ID
+func (vv *Verifier) Verify(rr *http.Request) (string, error) {
+	ss, err := ParseSign(rr.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+	secret, ok := vv.Secrets[ss.KeyID]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	headers := vv.Headers
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	for _, hh := range headers {
+		if !slices.Contains(ss.Headers, hh) {
+			return "", ErrSignFormat
+		}
+	}
+	if ts := time.Unix(ss.Time, 0); time.Since(ts) > vv.Skew || time.Until(ts) > vv.Skew {
+		return "", ErrSignExpired
+	}
+	hash, err := BodyHash(rr, vv.MaxBody)
+	if err != nil {
+		return "", err
+	}
+	if hdr := rr.Header.Get(BodyHeader); hdr != "" && hdr != hash {
+		return "", ErrBodyHash
+	}
+	if !hmac.Equal(ss.Sig, Compute(secret, Canonical(rr, ss, hash))) {
+		return "", ErrSignInvalid
+	}
+	// 签名有效后再记录随机数， 避免伪造请求占用
+	if vv.Nonces != nil && !vv.Nonces.Use(ss.KeyID+":"+ss.Nonce, 2*vv.Skew) {
+		return "", ErrSignReplay
+	}
+	return ss.KeyID, nil
+}
+
+// 验证请求， 成功后写入 X-Request-Sky-SignKey 请求头
+func (vv *Verifier) Authz(rr *http.Request) (string, error) {
+	rr.Header.Del(KeyHeader)
+	kid, err := vv.Verify(rr)
+	if err != nil {
+		return "", err
+	}
+	rr.Header.Set(KeyHeader, kid)
+	return kid, nil
+}
+
+// 验证失败的响应
+func (vv *Verifier) Reject(rr *http.Request, rw http.ResponseWriter, err error) {
+	rw.Header().Set("WWW-Authenticate", Scheme)
+	z.JSON0(rr, rw, &z.Result{ErrCode: "invalid-signature", Message: err.Error(), Status: http.StatusUnauthorized})
+}
+
+// 路由中间件， 使用 KeyFrom(ctx.Ctx) 获取密钥 ID
+func (vv *Verifier) Auth(handle z.HandleFunc) z.HandleFunc {
+	return func(ctx *z.Ctx) {
+		kid, err := vv.Authz(ctx.Request)
+		if err != nil {
+			ctx.Abort()
+			vv.Reject(ctx.Request, ctx.Writer, err)
+			return
+		}
+		ctx.Ctx = WithKey(ctx.Ctx, kid)
+		ctx.Request = ctx.Request.WithContext(WithKey(ctx.Request.Context(), kid))
+		handle(ctx)
+	}
+}
+
+// 过滤器
+func (vv *Verifier) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		kid, err := vv.Authz(rr)
+		if err != nil {
+			vv.Reject(rr, rw, err)
+			return
+		}
+		next.ServeHTTP(rw, rr.WithContext(WithKey(rr.Context(), kid)))
+	})
+}
+
+// -----------------------------------------------------------------------------------
+
+type keyKey struct{}
+
+func WithKey(ctx context.Context, kid string) context.Context {
+	return context.WithValue(ctx, keyKey{}, kid)
+}
+
+func KeyFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	kid, _ := ctx.Value(keyKey{}).(string)
+	return kid
+}
@@ -0,0 +1,330 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package jwtx
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 验证密钥
+type Key struct {
+	Kid string
+	Alg string // 为空时根据密钥类型匹配
+	Key any    // []byte, *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey
+}
+
+// 密钥能否用于指定算法
+func (kk *Key) Match(alg string) bool {
+	if kk.Alg != "" && kk.Alg != alg {
+		return false
+	}
+	switch kk.Key.(type) {
+	case []byte:
+		return alg == HS256
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		return alg == ES256
+	case ed25519.PublicKey:
+		return alg == EdDSA
+	}
+	return false
+}
+
+// 密钥查询， kid 为空时返回所有密钥
+type Keys interface {
+	Lookup(kid string) []*Key
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Keys = (*KeySet)(nil)
+
+// 静态密钥集合
+type KeySet struct {
+	Keys []*Key
+}
+
+func NewKeySet(keys ...*Key) *KeySet {
+	return &KeySet{Keys: keys}
+}
+
+func (ks *KeySet) Lookup(kid string) []*Key {
+	if kid == "" {
+		return ks.Keys
+	}
+	keys := []*Key{}
+	for _, key := range ks.Keys {
+		if key.Kid == kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// HS256 密钥
+func NewSecret(kid string, secret []byte) *Key {
+	return &Key{Kid: kid, Alg: HS256, Key: secret}
+}
+
+// -----------------------------------------------------------------------------------
+
+// JWK 格式， 只处理公钥部分
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+func (jk *JWK) Key() (*Key, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	key := &Key{Kid: jk.Kid, Alg: jk.Alg}
+	switch jk.Kty {
+	case "oct":
+		bts, err := dec(jk.K)
+		if err != nil {
+			return nil, err
+		}
+		key.Key = bts
+	case "RSA":
+		nb, err := dec(jk.N)
+		if err != nil {
+			return nil, err
+		}
+		eb, err := dec(jk.E)
+		if err != nil {
+			return nil, err
+		}
+		key.Key = &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}
+	case "EC":
+		if jk.Crv != "P-256" {
+			return nil, fmt.Errorf("jwk: unsupported curve %s", jk.Crv)
+		}
+		xb, err := dec(jk.X)
+		if err != nil {
+			return nil, err
+		}
+		yb, err := dec(jk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(xb) > 32 || len(yb) > 32 {
+			return nil, errors.New("jwk: invalid ec key")
+		}
+		// 校验坐标在曲线上
+		pt := append([]byte{4}, append(make([]byte, 32-len(xb)), xb...)...)
+		pt = append(pt, append(make([]byte, 32-len(yb)), yb...)...)
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), pt)
+		if err != nil {
+			return nil, err
+		}
+		key.Key = pub
+	case "OKP":
+		if jk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("jwk: unsupported curve %s", jk.Crv)
+		}
+		xb, err := dec(jk.X)
+		if err != nil || len(xb) != ed25519.PublicKeySize {
+			return nil, errors.New("jwk: invalid ed25519 key")
+		}
+		key.Key = ed25519.PublicKey(xb)
+	default:
+		return nil, fmt.Errorf("jwk: unsupported key type %s", jk.Kty)
+	}
+	return key, nil
+}
+
+// 解析 JWKS({"keys":[...]}) 或者 PEM 公钥， 忽略不支持的密钥
+func ParseKeys(bts []byte) (*KeySet, error) {
+	ks := &KeySet{}
+	if blk, _ := pem.Decode(bts); blk != nil {
+		for ; blk != nil; blk, bts = pem.Decode(bts) {
+			pub, err := x509.ParsePKIXPublicKey(blk.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			ks.Keys = append(ks.Keys, &Key{Key: pub})
+		}
+		return ks, nil
+	}
+	set := struct {
+		Keys []*JWK `json:"keys"`
+	}{}
+	if err := json.Unmarshal(bts, &set); err != nil {
+		return nil, err
+	}
+	for _, jk := range set.Keys {
+		if jk.Use != "" && jk.Use != "sig" {
+			continue
+		}
+		if key, err := jk.Key(); err == nil {
+			ks.Keys = append(ks.Keys, key)
+		}
+	}
+	if len(ks.Keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return ks, nil
+}
+
+// 加载密钥， http(s):// 开头使用远程 JWKS， 否则从文件中加载
+func LoadKeys(src string) (Keys, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		rks := NewRemoteKeySet(src)
+		if err := rks.Refresh(); err != nil {
+			return nil, err
+		}
+		return rks, nil
+	}
+	bts, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeys(bts)
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Keys = (*RemoteKeySet)(nil)
+
+// 远程 JWKS， 定期刷新， kid 不存在时立即刷新(限制最小间隔)
+// 刷新不持有锁， 同时只有一个刷新请求， 定期刷新时继续使用旧的密钥
+// 远程 JWKS 只使用公钥， 忽略对称密钥(oct), 防止使用 JWKS 中的密钥签发令牌
+type RemoteKeySet struct {
+	URL      string
+	Client   *http.Client
+	Interval time.Duration // 刷新间隔
+	MinWait  time.Duration // 最小刷新间隔
+	keys     *KeySet
+	last     time.Time
+	wait     chan struct{} // 正在刷新
+	err      error         // 最后一次刷新的错误
+	lock     sync.Mutex
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:      url,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+		Interval: 10 * time.Minute,
+		MinWait:  30 * time.Second,
+	}
+}
+
+// 刷新密钥， 已经在刷新时等待刷新结果
+func (rk *RemoteKeySet) Refresh() error {
+	rk.lock.Lock()
+	wait, lead := rk.wait, rk.wait == nil
+	if lead {
+		wait = rk.begin()
+	}
+	rk.lock.Unlock()
+	if lead {
+		rk.run(wait)
+	} else {
+		<-wait
+	}
+	rk.lock.Lock()
+	defer rk.lock.Unlock()
+	return rk.err
+}
+
+// 开始刷新， 调用方需要持有锁
+func (rk *RemoteKeySet) begin() chan struct{} {
+	rk.wait, rk.last = make(chan struct{}), time.Now()
+	return rk.wait
+}
+
+func (rk *RemoteKeySet) run(wait chan struct{}) {
+	keys, err := rk.fetch()
+	rk.lock.Lock()
+	if err == nil {
+		rk.keys = keys
+	}
+	rk.wait, rk.err = nil, err
+	rk.lock.Unlock()
+	close(wait)
+}
+
+func (rk *RemoteKeySet) fetch() (*KeySet, error) {
+	resp, err := rk.Client.Get(rk.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: %s status %d", rk.URL, resp.StatusCode)
+	}
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseKeys(bts)
+	if err != nil {
+		return nil, err
+	}
+	keys.Keys = slices.DeleteFunc(keys.Keys, func(key *Key) bool {
+		_, ok := key.Key.([]byte)
+		return ok
+	})
+	if len(keys.Keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return keys, nil
+}
+
+func (rk *RemoteKeySet) Lookup(kid string) []*Key {
+	rk.lock.Lock()
+	keys, since := rk.keys, time.Since(rk.last)
+	if keys != nil && since >= rk.Interval && rk.wait == nil {
+		go rk.run(rk.begin()) // 后台刷新， 使用旧的密钥
+	}
+	rk.lock.Unlock()
+	if keys == nil {
+		if since < rk.MinWait || rk.Refresh() != nil {
+			return nil
+		}
+		rk.lock.Lock()
+		keys = rk.keys
+		rk.lock.Unlock()
+		return keys.Lookup(kid)
+	}
+	found := keys.Lookup(kid)
+	if len(found) == 0 && kid != "" && since >= rk.MinWait {
+		// 密钥轮换， 重新获取
+		if rk.Refresh() == nil {
+			rk.lock.Lock()
+			keys = rk.keys
+			rk.lock.Unlock()
+			found = keys.Lookup(kid)
+		}
+	}
+	return found
+}
@@ -0,0 +1,218 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// JWT 验证(HS256, RS256, ES256, EdDSA)， 支持 JWKS 文件和远程地址， 可用于 z 路由和 gtw 网关
+
+package jwtx
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrTokenMissing   = errors.New("jwt: token missing")
+	ErrTokenMalformed = errors.New("jwt: token malformed")
+	ErrTokenAlg       = errors.New("jwt: algorithm not allowed")
+	ErrTokenSignature = errors.New("jwt: signature invalid")
+	ErrTokenExpired   = errors.New("jwt: token expired")
+	ErrTokenNoExp     = errors.New("jwt: token exp missing")
+	ErrTokenNotBefore = errors.New("jwt: token not valid yet")
+	ErrTokenIssuer    = errors.New("jwt: issuer invalid")
+	ErrTokenAudience  = errors.New("jwt: audience invalid")
+	ErrKeyNotFound    = errors.New("jwt: key not found")
+)
+
+// 支持的签名算法
+var Algorithms = []string{HS256, RS256, ES256, EdDSA}
+
+// JWT 头部
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWT 声明
+type Claims map[string]any
+
+func (cc Claims) Str(key string) string {
+	str, _ := cc[key].(string)
+	return str
+}
+
+// 时间声明(exp, nbf, iat), 单位秒
+func (cc Claims) Time(key string) (time.Time, bool) {
+	switch val := cc[key].(type) {
+	case float64:
+		return time.Unix(int64(val), 0), true
+	case json.Number:
+		if num, err := val.Int64(); err == nil {
+			return time.Unix(num, 0), true
+		}
+	case int64:
+		return time.Unix(val, 0), true
+	case int:
+		return time.Unix(int64(val), 0), true
+	}
+	return time.Time{}, false
+}
+
+// aud 可以是字符串或者数组
+func (cc Claims) Audience() []string {
+	switch val := cc["aud"].(type) {
+	case string:
+		return []string{val}
+	case []string:
+		return val
+	case []any:
+		auds := make([]string, 0, len(val))
+		for _, v := range val {
+			if str, ok := v.(string); ok {
+				auds = append(auds, str)
+			}
+		}
+		return auds
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------------
+
+// 解析 JWT, 不验证签名， 返回头部， 声明， 签名内容和签名
+func Parse(token string) (*Header, Claims, string, []byte, error) {
+	idx1 := strings.IndexByte(token, '.')
+	idx2 := strings.LastIndexByte(token, '.')
+	if idx1 <= 0 || idx2 <= idx1 {
+		return nil, nil, "", nil, ErrTokenMalformed
+	}
+	hdr := &Header{}
+	if bts, err := base64.RawURLEncoding.DecodeString(token[:idx1]); err != nil {
+		return nil, nil, "", nil, ErrTokenMalformed
+	} else if err := json.Unmarshal(bts, hdr); err != nil {
+		return nil, nil, "", nil, ErrTokenMalformed
+	}
+	claims := Claims{}
+	if bts, err := base64.RawURLEncoding.DecodeString(token[idx1+1 : idx2]); err != nil {
+		return nil, nil, "", nil, ErrTokenMalformed
+	} else if err := json.Unmarshal(bts, &claims); err != nil {
+		return nil, nil, "", nil, ErrTokenMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[idx2+1:])
+	if err != nil {
+		return nil, nil, "", nil, ErrTokenMalformed
+	}
+	return hdr, claims, token[:idx2], sig, nil
+}
+
+// 签名 JWT, key: HS256 []byte, RS256 *rsa.PrivateKey, ES256 *ecdsa.PrivateKey, EdDSA ed25519.PrivateKey
+func Sign(alg, kid string, key any, claims Claims) (string, error) {
+	hdr, err := json.Marshal(&Header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	pld, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	str := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(pld)
+	var sig []byte
+	switch alg {
+	case HS256:
+		sec, ok := key.([]byte)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		mac := hmac.New(sha256.New, sec)
+		mac.Write([]byte(str))
+		sig = mac.Sum(nil)
+	case RS256:
+		pkey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		sum := sha256.Sum256([]byte(str))
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, pkey, crypto.SHA256, sum[:]); err != nil {
+			return "", err
+		}
+	case ES256:
+		pkey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		sum := sha256.Sum256([]byte(str))
+		r, s, err := ecdsa.Sign(rand.Reader, pkey, sum[:])
+		if err != nil {
+			return "", err
+		}
+		// r || s, 各 32 字节
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case EdDSA:
+		pkey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return "", ErrKeyNotFound
+		}
+		sig = ed25519.Sign(pkey, []byte(str))
+	default:
+		return "", ErrTokenAlg
+	}
+	return str + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// 验证签名, key 为公钥(HS256 为密钥)
+func verify(alg string, key any, str string, sig []byte) bool {
+	switch alg {
+	case HS256:
+		sec, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, sec)
+		mac.Write([]byte(str))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		sum := sha256.Sum256([]byte(str))
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256([]byte(str))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, []byte(str), sig)
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package jwtx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// 用户信息请求头前缀， 验证前删除客户端传入的同名请求头
+const SkyPrefix = "X-Request-Sky-"
+
+// 用户信息请求头， base64(claims), 与 f1kin 鉴权服务返回一致
+const SkyAuthorize = "X-Request-Sky-Authorize"
+
+// JWT 验证器
+type Validator struct {
+	Keys       Keys
+	Algs       []string          // 允许的算法， 为空使用 Algorithms
+	Issuer     string            // 为空不验证
+	Audience   []string          // 匹配任意一个， 为空不验证
+	Skew       time.Duration     // 时钟偏差
+	RequireExp bool              // 必须包含 exp, 默认开启， 防止令牌永久有效
+	Cookie     string            // 从 cookie 中获取令牌， 为空只使用 Authorization: Bearer
+	Headers    map[string]string // 声明映射到请求头， claim = header, 如: sub = X-Request-Sky-UserId
+}
+
+func NewValidator(keys Keys) *Validator {
+	return &Validator{Keys: keys, Skew: time.Minute, RequireExp: true}
+}
+
+// 验证令牌， 返回声明
+func (vv *Validator) Verify(token string) (Claims, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+	hdr, claims, str, sig, err := Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	algs := vv.Algs
+	if len(algs) == 0 {
+		algs = Algorithms
+	}
+	if !slices.Contains(algs, hdr.Alg) {
+		return nil, ErrTokenAlg
+	}
+	keys := vv.Keys.Lookup(hdr.Kid)
+	found := false
+	for _, key := range keys {
+		if !key.Match(hdr.Alg) {
+			continue
+		}
+		if found = verify(hdr.Alg, key.Key, str, sig); found {
+			break
+		}
+	}
+	if !found {
+		if len(keys) == 0 {
+			return nil, ErrKeyNotFound
+		}
+		return nil, ErrTokenSignature
+	}
+	return claims, vv.Check(claims, time.Now())
+}
+
+// 验证声明中的 exp, nbf, iss, aud
+func (vv *Validator) Check(claims Claims, now time.Time) error {
+	if exp, ok := claims.Time("exp"); ok && now.After(exp.Add(vv.Skew)) {
+		return ErrTokenExpired
+	} else if !ok && vv.RequireExp {
+		return ErrTokenNoExp
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(vv.Skew).Before(nbf) {
+		return ErrTokenNotBefore
+	}
+	if vv.Issuer != "" && claims.Str("iss") != vv.Issuer {
+		return ErrTokenIssuer
+	}
+	if len(vv.Audience) > 0 {
+		auds := claims.Audience()
+		if !slices.ContainsFunc(vv.Audience, func(aud string) bool { return slices.Contains(auds, aud) }) {
+			return ErrTokenAudience
+		}
+	}
+	return nil
+}
+
+// 从请求中获取令牌
+func (vv *Validator) Token(rr *http.Request) string {
+	if auth := rr.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if vv.Cookie != "" {
+		if ck, err := rr.Cookie(vv.Cookie); err == nil {
+			return ck.Value
+		}
+	}
+	return ""
+}
+
+// 验证请求， 成功后将声明写入请求头
+func (vv *Validator) Authz(rr *http.Request) (Claims, error) {
+	StripHeaders(rr)
+	claims, err := vv.Verify(vv.Token(rr))
+	if err != nil {
+		return nil, err
+	}
+	SetHeaders(rr, claims, vv.Headers)
+	return claims, nil
+}
+
+// 删除客户端伪造的用户信息
+func StripHeaders(rr *http.Request) {
+	for kk := range rr.Header {
+		if strings.HasPrefix(kk, SkyPrefix) {
+			rr.Header.Del(kk)
+		}
+	}
+}
+
+// 声明写入请求头， X-Request-Sky-Authorize 为全部声明， headers 为声明映射
+func SetHeaders(rr *http.Request, claims Claims, headers map[string]string) {
+	if bts, err := json.Marshal(claims); err == nil {
+		rr.Header.Set(SkyAuthorize, base64.StdEncoding.EncodeToString(bts))
+	}
+	for key, hdr := range headers {
+		switch val := claims[key].(type) {
+		case nil:
+		case string:
+			rr.Header.Set(hdr, val)
+		case []any:
+			strs := make([]string, 0, len(val))
+			for _, v := range val {
+				strs = append(strs, fmt.Sprint(v))
+			}
+			rr.Header.Set(hdr, strings.Join(strs, ","))
+		case float64:
+			rr.Header.Set(hdr, fmt.Sprint(int64(val)))
+		default:
+			rr.Header.Set(hdr, fmt.Sprint(val))
+		}
+	}
+}
+
+// 验证失败的响应
+func (vv *Validator) Reject(rr *http.Request, rw http.ResponseWriter, err error) {
+	rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	z.JSON0(rr, rw, &z.Result{ErrCode: "invalid-token", Message: err.Error(), Status: http.StatusUnauthorized})
+}
+
+// 路由中间件， 使用 ClaimsFrom(ctx.Ctx) 获取声明
+func (vv *Validator) Auth(handle z.HandleFunc) z.HandleFunc {
+	return func(ctx *z.Ctx) {
+		claims, err := vv.Authz(ctx.Request)
+		if err != nil {
+			ctx.Abort()
+			vv.Reject(ctx.Request, ctx.Writer, err)
+			return
+		}
+		ctx.Ctx = WithClaims(ctx.Ctx, claims)
+		ctx.Request = ctx.Request.WithContext(WithClaims(ctx.Request.Context(), claims))
+		handle(ctx)
+	}
+}
+
+// 过滤器
+func (vv *Validator) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		claims, err := vv.Authz(rr)
+		if err != nil {
+			vv.Reject(rr, rw, err)
+			return
+		}
+		next.ServeHTTP(rw, rr.WithContext(WithClaims(rr.Context(), claims)))
+	})
+}
+
+// -----------------------------------------------------------------------------------
+
+type claimsKey struct{}
+
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+func ClaimsFrom(ctx context.Context) Claims {
+	if ctx == nil {
+		return nil
+	}
+	claims, _ := ctx.Value(claimsKey{}).(Claims)
+	return claims
+}
@@ -0,0 +1,168 @@
+package jwtx_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/jwtx"
+)
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+// go test -v z/ze/jwtx/jwtx_test.go -run TestVerify
+func TestVerify(t *testing.T) {
+	rsk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	eck, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	epub, edk, _ := ed25519.GenerateKey(rand.Reader)
+	ecb, _ := eck.PublicKey.Bytes()
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64([]byte("secret"))},
+		{"kty": "RSA", "kid": "rs", "n": b64(rsk.N.Bytes()), "e": b64(big.NewInt(int64(rsk.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64(ecb[1:33]), "y": b64(ecb[33:])},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(epub)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "", "e": ""},
+	}})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		rw.Write(jwks)
+	}))
+	defer srv.Close()
+	keys, err := jwtx.LoadKeys(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vv := jwtx.NewValidator(keys)
+	vv.Issuer, vv.Audience = "zgg", []string{"api"}
+
+	now := time.Now().Unix()
+	claims := jwtx.Claims{"sub": "u1", "iss": "zgg", "aud": []string{"web", "api"}, "exp": now + 60}
+	cases := []struct {
+		alg, kid string
+		key      any
+	}{
+		{jwtx.RS256, "rs", rsk},
+		{jwtx.ES256, "es", eck},
+		{jwtx.EdDSA, "ed", edk},
+	}
+	for _, cc := range cases {
+		tkn, err := jwtx.Sign(cc.alg, cc.kid, cc.key, claims)
+		if err != nil {
+			t.Fatal(cc.alg, err)
+		}
+		if cm, err := vv.Verify(tkn); err != nil || cm.Str("sub") != "u1" {
+			t.Fatal(cc.alg, cm, err)
+		}
+		// 篡改签名
+		if _, err := vv.Verify(tkn[:len(tkn)-4] + "AAAA"); err != jwtx.ErrTokenSignature {
+			t.Fatal(cc.alg, err)
+		}
+	}
+	// 算法与密钥不匹配
+	tkn, _ := jwtx.Sign(jwtx.HS256, "rs", []byte("secret"), claims)
+	if _, err := vv.Verify(tkn); err != jwtx.ErrTokenSignature {
+		t.Fatal(err)
+	}
+	// 远程 JWKS 中的对称密钥不能使用
+	tkn, _ = jwtx.Sign(jwtx.HS256, "hs", []byte("secret"), claims)
+	if _, err := vv.Verify(tkn); err != jwtx.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+	errs := []struct {
+		claims jwtx.Claims
+		err    error
+	}{
+		{jwtx.Claims{"iss": "zgg", "aud": "api", "exp": now - 120}, jwtx.ErrTokenExpired},
+		{jwtx.Claims{"iss": "zgg", "aud": "api", "exp": now - 30}, nil}, // 时钟偏差
+		{jwtx.Claims{"iss": "zgg", "aud": "api", "exp": now + 60, "nbf": now + 120}, jwtx.ErrTokenNotBefore},
+		{jwtx.Claims{"iss": "xxx", "aud": "api", "exp": now + 60}, jwtx.ErrTokenIssuer},
+		{jwtx.Claims{"iss": "zgg", "aud": "web", "exp": now + 60}, jwtx.ErrTokenAudience},
+		{jwtx.Claims{"iss": "zgg", "aud": "api"}, jwtx.ErrTokenNoExp},
+	}
+	for i, cc := range errs {
+		tkn, _ := jwtx.Sign(jwtx.RS256, "rs", rsk, cc.claims)
+		if _, err := vv.Verify(tkn); err != cc.err {
+			t.Fatal(i, err)
+		}
+	}
+	if _, err := vv.Verify("a.b.c"); err != jwtx.ErrTokenMalformed {
+		t.Fatal(err)
+	}
+}
+
+// go test -v z/ze/jwtx/jwtx_test.go -run TestFilter
+func TestFilter(t *testing.T) {
+	vv := jwtx.NewValidator(jwtx.NewKeySet(jwtx.NewSecret("", []byte("secret"))))
+	vv.Headers = map[string]string{"sub": "X-Request-Sky-UserId", "rol": "X-Request-Sky-Roles"}
+	hdl := vv.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		if jwtx.ClaimsFrom(rr.Context()).Str("sub") != "u1" {
+			t.Error("claims not found")
+		}
+		rw.Write([]byte(rr.Header.Get("X-Request-Sky-UserId") + ";" + rr.Header.Get("X-Request-Sky-Roles") + ";" + rr.Header.Get("X-Request-Sky-Fake")))
+	}))
+	tkn, _ := jwtx.Sign(jwtx.HS256, "", []byte("secret"), jwtx.Claims{"sub": "u1", "rol": []string{"admin", "dev"}, "exp": time.Now().Unix() + 60})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tkn)
+	req.Header.Set("X-Request-Sky-Fake", "1")
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, req)
+	if rec.Body.String() != "u1;admin,dev;" || req.Header.Get(jwtx.SkyAuthorize) == "" {
+		t.Fatal(rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+}
+
+// go test -v z/ze/jwtx/jwtx_test.go -run TestRemoteKeySet
+func TestRemoteKeySet(t *testing.T) {
+	rsk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var slow atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		if slow.Load() {
+			time.Sleep(300 * time.Millisecond)
+		}
+		json.NewEncoder(rw).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rs", "n": b64(rsk.N.Bytes()), "e": b64(big.NewInt(int64(rsk.E)).Bytes())},
+		}})
+	}))
+	defer srv.Close()
+	rk := jwtx.NewRemoteKeySet(srv.URL)
+	if err := rk.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	// 定期刷新在后台执行， 使用旧的密钥
+	slow.Store(true)
+	rk.Interval = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	start := time.Now()
+	for range 3 {
+		if keys := rk.Lookup("rs"); len(keys) != 1 {
+			t.Fatal("stale keys", keys)
+		}
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("lookup blocked by refresh", time.Since(start))
+	}
+
+	// 只有对称密钥
+	oct := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		rw.Write([]byte(`{"keys":[{"kty":"oct","kid":"hs","k":"c2VjcmV0"}]}`))
+	}))
+	defer oct.Close()
+	if err := jwtx.NewRemoteKeySet(oct.URL).Refresh(); err != jwtx.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+}
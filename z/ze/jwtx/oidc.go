@@ -0,0 +1,178 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package jwtx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OIDC 服务发现文档
+type Provider struct {
+	Issuer        string `json:"issuer"`
+	AuthURL       string `json:"authorization_endpoint"`
+	TokenURL      string `json:"token_endpoint"`
+	UserInfoURL   string `json:"userinfo_endpoint,omitempty"`
+	JwksURL       string `json:"jwks_uri"`
+	EndSessionURL string `json:"end_session_endpoint,omitempty"`
+}
+
+// 加载服务发现文档， issuer/.well-known/openid-configuration
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Provider, error) {
+	addr := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	pvd := &Provider{}
+	if err := doJSON(client, req, pvd); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(pvd.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("oidc: issuer mismatch, %s != %s", pvd.Issuer, issuer)
+	}
+	if pvd.AuthURL == "" || pvd.TokenURL == "" || pvd.JwksURL == "" {
+		return nil, fmt.Errorf("oidc: invalid discovery document, %s", addr)
+	}
+	return pvd, nil
+}
+
+// 令牌响应
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+// 令牌错误响应
+type TokenError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (te *TokenError) Error() string {
+	return fmt.Sprintf("oauth2: %d %s %s", te.Status, te.Code, te.Description)
+}
+
+// OAuth2 客户端， 授权码模式 + PKCE
+type OAuth2 struct {
+	Provider     *Provider
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // 默认 openid profile email
+	Client       *http.Client
+}
+
+// 授权地址， verifier 使用 NewVerifier 生成
+func (oa *OAuth2) AuthCodeURL(redirect, state, nonce, verifier string) string {
+	scopes := oa.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	qry := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oa.ClientID},
+		"redirect_uri":          {redirect},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if nonce != "" {
+		qry.Set("nonce", nonce)
+	}
+	if strings.Contains(oa.Provider.AuthURL, "?") {
+		return oa.Provider.AuthURL + "&" + qry.Encode()
+	}
+	return oa.Provider.AuthURL + "?" + qry.Encode()
+}
+
+// 使用授权码获取令牌
+func (oa *OAuth2) Exchange(ctx context.Context, redirect, code, verifier string) (*Token, error) {
+	return oa.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+	})
+}
+
+// 刷新令牌
+func (oa *OAuth2) Refresh(ctx context.Context, refresh string) (*Token, error) {
+	return oa.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refresh},
+	})
+}
+
+func (oa *OAuth2) token(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", oa.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oa.Provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if oa.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oa.ClientID), url.QueryEscape(oa.ClientSecret))
+	}
+	tkn := &Token{}
+	if err := doJSON(oa.Client, req, tkn); err != nil {
+		return nil, err
+	}
+	if tkn.AccessToken == "" && tkn.IDToken == "" {
+		return nil, &TokenError{Status: http.StatusOK, Code: "invalid_response"}
+	}
+	return tkn, nil
+}
+
+func doJSON(client *http.Client, req *http.Request, out any) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		te := &TokenError{Status: resp.StatusCode}
+		json.Unmarshal(bts, te)
+		return te
+	}
+	return json.Unmarshal(bts, out)
+}
+
+// -----------------------------------------------------------------------------------
+
+// 随机字符串， 用于 state, nonce, PKCE verifier
+func NewVerifier() string {
+	bts := make([]byte, 32)
+	rand.Read(bts)
+	return base64.RawURLEncoding.EncodeToString(bts)
+}
+
+// PKCE S256
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
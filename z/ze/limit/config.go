@@ -0,0 +1,42 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package limit
+
+import (
+	"flag"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var (
+	G = struct {
+		Server ServerConfig
+	}{}
+)
+
+// 全局限流配置， 合并到 [server] 配置中
+type ServerConfig struct {
+	Limit string `json:"limit"` // 全局限流规则, 参考 Parse, 为空禁用
+}
+
+func init() {
+	z.Config(&G)
+	flag.StringVar(&G.Server.Limit, "limit", "", "global rate limit rule, e.g. key=ip;rate=10/s;burst=20")
+
+	z.Register("09-limit", func(zgg *z.Zgg) z.Closed {
+		if G.Server.Limit == "" {
+			return nil
+		}
+		lm, err := Parse(G.Server.Limit)
+		if err != nil {
+			zgg.ServeStop("[_limit__]: limit rule error:", err.Error())
+			return nil
+		}
+		zgg.Filters.Add(lm.Start().Filter)
+		z.Logn("[_limit__]: global limit enabled,", G.Server.Limit)
+		return lm.Close
+	})
+}
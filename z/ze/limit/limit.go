@@ -0,0 +1,300 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 限流(令牌桶/滑动窗口)和并发限制， 可用于 z 路由和 gtw 网关
+
+package limit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// 限流 key 提取函数
+type KeyFunc func(rr *http.Request) string
+
+func KeyByIP(rr *http.Request) string {
+	return z.GetRemoteIP(rr)
+}
+
+func KeyByRoute(rr *http.Request) string {
+	return rr.Method + " " + rr.Host + rr.URL.Path
+}
+
+func KeyByHeader(name string) KeyFunc {
+	return func(rr *http.Request) string {
+		return rr.Header.Get(name)
+	}
+}
+
+func KeyByCookie(name string) KeyFunc {
+	return func(rr *http.Request) string {
+		if ck, err := rr.Cookie(name); err == nil {
+			return ck.Value
+		}
+		return ""
+	}
+}
+
+// 解析 key 函数, ip, route, header:X-Api-Key, cookie:_xc
+func ParseKey(str string) (KeyFunc, error) {
+	kind, name, _ := strings.Cut(str, ":")
+	switch kind {
+	case "", "ip":
+		return KeyByIP, nil
+	case "route":
+		return KeyByRoute, nil
+	case "header":
+		if name == "" {
+			return nil, errors.New("limit key header name is empty")
+		}
+		return KeyByHeader(name), nil
+	case "cookie":
+		if name == "" {
+			name = "_xc"
+		}
+		return KeyByCookie(name), nil
+	}
+	return nil, fmt.Errorf("limit key unknow: %s", str)
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ gtw.Limiter = (*Limit)(nil)
+
+// 限流规则
+type Limit struct {
+	KeyFunc KeyFunc       // key 提取, 为空使用 KeyByIP
+	Limiter Limiter       // 速率限制, 可为空
+	Concurr *Concurrency  // 并发限制, 可为空
+	TTL     time.Duration // key 空闲多久后清理
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// 解析限流规则, 格式: key=ip;rate=10/s;burst=20;window=100/1m;conc=10;ttl=10m
+// key: ip, route, header:X-Api-Key, cookie:_xc
+// rate: 令牌桶, 个数/单位(s,m,h), burst: 令牌桶容量
+// window: 滑动窗口, 个数/时长, 与 rate 二选一
+// conc: 每个 key 的最大并发数
+func Parse(str string) (*Limit, error) {
+	lm := &Limit{TTL: 10 * time.Minute}
+	rate, burst := 0.0, 0
+	for kv := range strings.SplitSeq(str, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+		var err error
+		switch key {
+		case "":
+			continue
+		case "key":
+			lm.KeyFunc, err = ParseKey(val)
+		case "rate":
+			num, per, _ := strings.Cut(val, "/")
+			if rate, err = strconv.ParseFloat(num, 64); err == nil {
+				var dur time.Duration
+				if dur, err = parseDur(per); err == nil {
+					rate = rate / dur.Seconds()
+				}
+			}
+		case "burst":
+			burst, err = strconv.Atoi(val)
+		case "window":
+			num, per, _ := strings.Cut(val, "/")
+			var cnt int
+			var dur time.Duration
+			if cnt, err = strconv.Atoi(num); err == nil {
+				if dur, err = parseDur(per); err == nil {
+					lm.Limiter = NewSlidingWindow(cnt, dur)
+				}
+			}
+		case "conc":
+			var cnt int
+			if cnt, err = strconv.Atoi(val); err == nil && cnt > 0 {
+				lm.Concurr = NewConcurrency(cnt)
+			}
+		case "ttl":
+			lm.TTL, err = time.ParseDuration(val)
+		default:
+			err = errors.New("unknow field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("limit parse %s error: %v", kv, err)
+		}
+	}
+	if rate > 0 {
+		if lm.Limiter != nil {
+			return nil, errors.New("limit rate and window cannot be used together")
+		}
+		lm.Limiter = NewTokenBucket(rate, burst)
+	}
+	if lm.Limiter == nil && lm.Concurr == nil {
+		return nil, errors.New("limit rate, window or conc is required")
+	}
+	if lm.KeyFunc == nil {
+		lm.KeyFunc = KeyByIP
+	}
+	return lm, nil
+}
+
+// s, m, h 或者 time.ParseDuration 格式
+func parseDur(str string) (time.Duration, error) {
+	switch str {
+	case "", "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	dur, err := time.ParseDuration(str)
+	if err == nil && dur <= 0 {
+		err = errors.New("duration must be positive")
+	}
+	return dur, err
+}
+
+// 获取许可, 返回 release 用于释放并发许可， retry 为建议的重试时间
+func (lm *Limit) Acquire(rr *http.Request) (release func(), retry time.Duration, ok bool) {
+	key := ""
+	if lm.KeyFunc != nil {
+		key = lm.KeyFunc(rr)
+	} else {
+		key = KeyByIP(rr)
+	}
+	now := time.Now()
+	// 先检查并发， 并发拒绝时不消耗速率令牌
+	if lm.Concurr != nil {
+		if release, ok = lm.Concurr.Acquire(key, now); !ok {
+			return nil, time.Second, false
+		}
+	}
+	if lm.Limiter != nil {
+		if retry, ok = lm.Limiter.Allow(key, now); !ok {
+			if release != nil {
+				release()
+			}
+			return nil, retry, false
+		}
+	}
+	return release, 0, true
+}
+
+// 启动清理协程， 定期清理空闲的 key
+func (lm *Limit) Start() *Limit {
+	lm.once.Do(func() {
+		if lm.TTL <= 0 {
+			return
+		}
+		lm.stop = make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(max(lm.TTL/2, time.Second))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-lm.stop:
+					return
+				case now := <-ticker.C:
+					lm.Evict(now.Add(-lm.TTL))
+				}
+			}
+		}()
+	})
+	return lm
+}
+
+// 停止清理协程
+func (lm *Limit) Close() {
+	if lm.stop != nil {
+		close(lm.stop)
+		lm.stop = nil
+	}
+}
+
+// 清理 before 之前未访问的 key
+func (lm *Limit) Evict(before time.Time) int {
+	cnt := 0
+	if lm.Limiter != nil {
+		cnt += lm.Limiter.Evict(before)
+	}
+	if lm.Concurr != nil {
+		cnt += lm.Concurr.Evict(before)
+	}
+	return cnt
+}
+
+// -----------------------------------------------------------------------------------
+
+// 429 响应结果
+func NewResult(retry time.Duration) *z.Result {
+	return &z.Result{
+		ErrCode: "too-many-requests",
+		Message: "请求过于频繁，请稍后再试",
+		Status:  http.StatusTooManyRequests,
+		Header:  z.HM{"Retry-After": RetryAfter(retry)},
+	}
+}
+
+// Retry-After 秒数， 向上取整， 最少 1 秒
+func RetryAfter(retry time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(retry.Seconds()))))
+}
+
+// 路由中间件
+func Wrap(lm *Limit, handle z.HandleFunc) z.HandleFunc {
+	return func(ctx *z.Ctx) {
+		release, retry, ok := lm.Acquire(ctx.Request)
+		if !ok {
+			ctx.JSON(NewResult(retry))
+			return
+		}
+		if release != nil {
+			defer release()
+		}
+		handle(ctx)
+	}
+}
+
+// 全局过滤器， 对所有路由引擎生效
+func (lm *Limit) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		release, retry, ok := lm.Acquire(rr)
+		if !ok {
+			rs := NewResult(retry)
+			rw.Header().Set("Retry-After", rs.Header["Retry-After"])
+			z.JSON0(rr, rw, rs)
+			return
+		}
+		if release != nil {
+			defer release()
+		}
+		next.ServeHTTP(rw, rr)
+	})
+}
+
+// 网关限流
+func (lm *Limit) Limit(gw gtw.IGateway, rw http.ResponseWriter, rr *http.Request, rt gtw.IRecord) (func(), bool) {
+	release, retry, ok := lm.Acquire(rr)
+	if !ok {
+		gw.Logf("[_limit__]: [%s] too many requests: %s %s", gw.GetProxyName(), z.GetRemoteIP(rr), rr.URL.Path)
+		if rt != nil {
+			rt.SetRespBody([]byte("###too many requests, retry after " + RetryAfter(retry) + "s"))
+		}
+		rs := NewResult(retry)
+		rw.Header().Set("Retry-After", rs.Header["Retry-After"])
+		z.JSON0(rr, rw, rs)
+		return nil, false
+	}
+	return release, true
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package limit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/limit"
+)
+
+// go test -v z/ze/limit/limit_test.go -run Test_bucket
+
+func Test_bucket(t *testing.T) {
+	tb := limit.NewTokenBucket(1, 2)
+	now := time.Now()
+	for i := range 2 {
+		if _, ok := tb.Allow("a", now); !ok {
+			t.Fatalf("burst %d denied", i)
+		}
+	}
+	if retry, ok := tb.Allow("a", now); ok || retry <= 0 || retry > time.Second {
+		t.Fatalf("want denied, got %v %v", ok, retry)
+	}
+	if _, ok := tb.Allow("b", now); !ok {
+		t.Fatal("other key denied")
+	}
+	if _, ok := tb.Allow("a", now.Add(time.Second)); !ok {
+		t.Fatal("refill denied")
+	}
+	if cnt := tb.Evict(now.Add(time.Minute)); cnt != 2 {
+		t.Fatalf("evict %d", cnt)
+	}
+}
+
+// go test -v z/ze/limit/limit_test.go -run Test_window
+
+func Test_window(t *testing.T) {
+	sw := limit.NewSlidingWindow(3, time.Minute)
+	now := time.Now().Truncate(time.Minute)
+	for range 3 {
+		if _, ok := sw.Allow("a", now); !ok {
+			t.Fatal("denied")
+		}
+	}
+	retry, ok := sw.Allow("a", now.Add(10*time.Second))
+	if ok || retry <= 0 || retry > time.Minute {
+		t.Fatalf("want denied, got %v %v", ok, retry)
+	}
+	// 下一个窗口的后半段， 前一个窗口的权重衰减
+	if _, ok := sw.Allow("a", now.Add(time.Minute+40*time.Second)); !ok {
+		t.Fatal("sliding denied")
+	}
+}
+
+// go test -v z/ze/limit/limit_test.go -run Test_filter
+
+func Test_filter(t *testing.T) {
+	lm, err := limit.Parse("key=header:X-Api-Key;rate=1/m;conc=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := lm.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("X-Api-Key", "k1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first: %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("second: %d %v", rec.Code, rec.Header())
+	}
+	t.Log(rec.Header().Get("Retry-After"), rec.Body.String())
+
+	// 并发限制
+	cc := limit.NewConcurrency(1)
+	release, ok := cc.Acquire("a", time.Now())
+	if !ok {
+		t.Fatal("acquire")
+	}
+	if _, ok := cc.Acquire("a", time.Now()); ok {
+		t.Fatal("want concurrency denied")
+	}
+	if cc.Evict(time.Now().Add(time.Hour)) != 0 {
+		t.Fatal("evict in use")
+	}
+	release()
+	release()
+	if _, ok := cc.Acquire("a", time.Now()); !ok {
+		t.Fatal("acquire after release")
+	}
+
+	// 并发拒绝时不消耗速率令牌
+	lm, _ = limit.Parse("rate=2/m;burst=2;conc=1")
+	release, _, ok = lm.Acquire(req)
+	if !ok {
+		t.Fatal("acquire")
+	}
+	if _, _, ok := lm.Acquire(req); ok {
+		t.Fatal("want concurrency denied")
+	}
+	release()
+	if _, _, ok := lm.Acquire(req); !ok {
+		t.Fatal("token consumed by concurrency denied")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 速率限制器， 返回是否允许以及需要等待的时间
+type Limiter interface {
+	Allow(key string, now time.Time) (time.Duration, bool)
+	Evict(before time.Time) int // 清理 before 之前未访问的 key, 返回清理数量
+}
+
+// -----------------------------------------------------------------------------------
+
+type entry[T any] struct {
+	val  T
+	seen time.Time
+}
+
+// 按 key 存储的状态
+type keyed[T any] struct {
+	mu    sync.Mutex
+	items map[string]*entry[T]
+}
+
+func (kk *keyed[T]) with(key string, now time.Time, fn func(val *T)) {
+	kk.mu.Lock()
+	defer kk.mu.Unlock()
+	if kk.items == nil {
+		kk.items = make(map[string]*entry[T])
+	}
+	et, ok := kk.items[key]
+	if !ok {
+		et = &entry[T]{}
+		kk.items[key] = et
+	}
+	et.seen = now
+	fn(&et.val)
+}
+
+func (kk *keyed[T]) evict(before time.Time, keep func(val *T) bool) int {
+	kk.mu.Lock()
+	defer kk.mu.Unlock()
+	cnt := 0
+	for key, et := range kk.items {
+		if et.seen.Before(before) && (keep == nil || !keep(&et.val)) {
+			delete(kk.items, key)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func (kk *keyed[T]) size() int {
+	kk.mu.Lock()
+	defer kk.mu.Unlock()
+	return len(kk.items)
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Limiter = (*TokenBucket)(nil)
+
+// 令牌桶， 每秒补充 Rate 个令牌， 最多 Burst 个
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+	state keyed[bucket]
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &TokenBucket{Rate: rate, Burst: burst}
+}
+
+func (tb *TokenBucket) Allow(key string, now time.Time) (retry time.Duration, ok bool) {
+	tb.state.with(key, now, func(bk *bucket) {
+		if bk.last.IsZero() {
+			bk.tokens = float64(tb.Burst)
+		} else if elapsed := now.Sub(bk.last).Seconds(); elapsed > 0 {
+			bk.tokens = min(float64(tb.Burst), bk.tokens+elapsed*tb.Rate)
+		}
+		bk.last = now
+		if bk.tokens >= 1 {
+			bk.tokens--
+			ok = true
+		} else if tb.Rate > 0 {
+			retry = time.Duration((1 - bk.tokens) / tb.Rate * float64(time.Second))
+		} else {
+			retry = time.Second
+		}
+	})
+	return
+}
+
+func (tb *TokenBucket) Evict(before time.Time) int {
+	return tb.state.evict(before, nil)
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Limiter = (*SlidingWindow)(nil)
+
+// 滑动窗口， Window 时间内最多 Limit 个请求， 使用前后两个窗口加权估算
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+	state  keyed[window]
+}
+
+type window struct {
+	start time.Time
+	prev  int
+	curr  int
+}
+
+func NewSlidingWindow(limit int, win time.Duration) *SlidingWindow {
+	return &SlidingWindow{Limit: limit, Window: win}
+}
+
+func (sw *SlidingWindow) Allow(key string, now time.Time) (retry time.Duration, ok bool) {
+	sw.state.with(key, now, func(wd *window) {
+		if wd.start.IsZero() {
+			wd.start = now.Truncate(sw.Window)
+		}
+		if elapsed := now.Sub(wd.start); elapsed >= 2*sw.Window {
+			wd.prev, wd.curr, wd.start = 0, 0, now.Truncate(sw.Window)
+		} else if elapsed >= sw.Window {
+			wd.prev, wd.curr, wd.start = wd.curr, 0, wd.start.Add(sw.Window)
+		}
+		elapsed := now.Sub(wd.start)
+		weight := 1 - float64(elapsed)/float64(sw.Window)
+		if float64(wd.prev)*weight+float64(wd.curr)+1 <= float64(sw.Limit) {
+			wd.curr++
+			ok = true
+			return
+		}
+		// 计算前一个窗口衰减到允许通过的时间
+		if wd.prev > 0 && wd.curr+1 <= sw.Limit {
+			need := 1 - float64(sw.Limit-wd.curr-1)/float64(wd.prev)
+			retry = time.Duration(need*float64(sw.Window)) - elapsed
+		} else {
+			retry = sw.Window - elapsed
+		}
+		retry = max(retry, time.Millisecond)
+	})
+	return
+}
+
+func (sw *SlidingWindow) Evict(before time.Time) int {
+	return sw.state.evict(before, nil)
+}
+
+// -----------------------------------------------------------------------------------
+
+// 并发限制器， 每个 key 同时最多 Max 个请求
+type Concurrency struct {
+	Max   int
+	state keyed[int]
+}
+
+func NewConcurrency(max int) *Concurrency {
+	return &Concurrency{Max: max}
+}
+
+// 获取执行许可， 成功时需要调用 release 释放
+func (cc *Concurrency) Acquire(key string, now time.Time) (release func(), ok bool) {
+	cc.state.with(key, now, func(cnt *int) {
+		if *cnt < cc.Max {
+			*cnt++
+			ok = true
+		}
+	})
+	if !ok {
+		return nil, false
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cc.state.with(key, time.Now(), func(cnt *int) { *cnt-- })
+		})
+	}, true
+}
+
+func (cc *Concurrency) Evict(before time.Time) int {
+	// 正在执行的请求不清理
+	return cc.state.evict(before, func(cnt *int) bool { return *cnt > 0 })
+}
@@ -0,0 +1,319 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// 授权请求
+type Input struct {
+	Subject  string         `json:"subject"`
+	Roles    []string       `json:"roles"`
+	Claims   map[string]any `json:"claims"`
+	Method   string         `json:"method"`
+	Host     string         `json:"host"`
+	Path     string         `json:"path"`
+	Header   http.Header    `json:"header"`
+	Query    url.Values     `json:"query"`
+	RemoteIP string         `json:"ip"`
+}
+
+// 从请求中构建授权请求， subject 和 roles 从声明中获取
+func NewInput(rr *http.Request, claims map[string]any, subClaim, roleClaim string) *Input {
+	in := &Input{
+		Claims:   claims,
+		Method:   rr.Method,
+		Host:     rr.Host,
+		Path:     cleanPath(rr.URL.Path),
+		Header:   rr.Header,
+		Query:    rr.URL.Query(),
+		RemoteIP: z.GetRemoteIP(rr),
+	}
+	if claims != nil {
+		in.Subject = claimStr(claims[subClaim])
+		if roles := claimStr(claims[roleClaim]); roles != "" {
+			for role := range strings.SplitSeq(roles, ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					in.Roles = append(in.Roles, role)
+				}
+			}
+		}
+	}
+	return in
+}
+
+// 清理路径， 避免 /api/../admin 绕过规则， 保留末尾的 /
+func cleanPath(str string) string {
+	if str == "" {
+		return "/"
+	}
+	cp := path.Clean("/" + str)
+	if str[len(str)-1] == '/' && cp != "/" {
+		cp += "/"
+	}
+	return cp
+}
+
+func claimStr(val any) string {
+	switch vv := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case []string:
+		return strings.Join(vv, ",")
+	case []any:
+		strs := make([]string, 0, len(vv))
+		for _, v := range vv {
+			strs = append(strs, fmt.Sprint(v))
+		}
+		return strings.Join(strs, ",")
+	case float64:
+		return fmt.Sprint(int64(vv))
+	}
+	return fmt.Sprint(val)
+}
+
+// 操作数的值， 第二个返回值表示是否存在
+func (in *Input) Value(operand string) (string, bool) {
+	kind, name, _ := strings.Cut(operand, ":")
+	switch kind {
+	case "header":
+		vals := in.Header.Values(name)
+		return strings.Join(vals, ","), len(vals) > 0
+	case "claim":
+		val, ok := in.Claims[name]
+		return claimStr(val), ok
+	case "query":
+		vals, ok := in.Query[name]
+		return strings.Join(vals, ","), ok
+	case "ip":
+		return in.RemoteIP, in.RemoteIP != ""
+	case "method":
+		return in.Method, true
+	case "path":
+		return in.Path, true
+	case "host":
+		return in.Host, true
+	case "subject":
+		return in.Subject, in.Subject != ""
+	}
+	return strings.Trim(operand, `"'`), true // 字面量
+}
+
+// 授权结果
+type Decision struct {
+	Allow   bool     `json:"allow"`
+	Rule    string   `json:"rule,omitempty"`    // 决定结果的规则, 为空表示使用默认策略
+	Matched []string `json:"matched,omitempty"` // 匹配的规则
+	Roles   []string `json:"roles,omitempty"`   // 展开后的角色
+	Trace   []string `json:"trace,omitempty"`   // 规则匹配过程， explain 时记录
+}
+
+func (dd *Decision) String() string {
+	effect := Deny
+	if dd.Allow {
+		effect = Allow
+	}
+	rule := dd.Rule
+	if rule == "" {
+		rule = "(default)"
+	}
+	return effect + " by " + rule + ", roles=" + strings.Join(dd.Roles, ",") + "; " + strings.Join(dd.Trace, "; ")
+}
+
+// 评估授权请求， deny 规则优先， 没有匹配的规则使用默认策略
+func (pp *Policy) Eval(in *Input, explain bool) *Decision {
+	roles := pp.RolesOf(in.Subject, in.Roles)
+	dd := &Decision{Allow: pp.Default == Allow, Roles: roles}
+	trace := func(rule *Rule, msg string) {
+		if explain {
+			dd.Trace = append(dd.Trace, rule.ID+": "+msg)
+		}
+	}
+	denied := false
+	for _, rule := range pp.Rules {
+		if !matchSubject(rule.Subjects, in.Subject, roles) {
+			trace(rule, "subject not match")
+			continue
+		}
+		if !matchHost(in.Host, rule.Hosts) {
+			trace(rule, "host not match")
+			continue
+		}
+		if len(rule.Actions) > 0 && !slices.ContainsFunc(rule.Actions, func(act string) bool {
+			return act == "*" || strings.EqualFold(act, in.Method)
+		}) {
+			trace(rule, "action not match")
+			continue
+		}
+		if len(rule.paths) > 0 && !slices.ContainsFunc(rule.paths, func(re *regexp.Regexp) bool { return re.MatchString(in.Path) }) {
+			trace(rule, "resource not match")
+			continue
+		}
+		if idx := slices.IndexFunc(rule.conds, func(cc *Condition) bool { return !cc.Eval(in) }); idx >= 0 {
+			trace(rule, "condition false, "+rule.conds[idx].Raw)
+			continue
+		}
+		trace(rule, "match "+rule.Effect)
+		dd.Matched = append(dd.Matched, rule.ID)
+		if denied {
+			continue
+		}
+		if rule.Effect == Deny {
+			dd.Allow, dd.Rule, denied = false, rule.ID, true
+			if !explain {
+				break
+			}
+		} else if dd.Rule == "" {
+			dd.Allow, dd.Rule = true, rule.ID
+		}
+	}
+	return dd
+}
+
+func matchSubject(subjects []string, subject string, roles []string) bool {
+	for _, ss := range subjects {
+		kind, name, _ := strings.Cut(ss, ":")
+		switch kind {
+		case "*":
+			return true
+		case "auth":
+			if subject != "" {
+				return true
+			}
+		case "user":
+			if subject != "" && name == subject {
+				return true
+			}
+		case "role":
+			if slices.Contains(roles, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------
+
+// 策略引擎， 支持从文件热加载
+type Engine struct {
+	File      string // 策略文件
+	Explain   bool   // 记录规则匹配过程
+	SubClaim  string // 用户声明, 默认 sub
+	RoleClaim string // 角色声明, 默认 rol
+	policy    atomic.Pointer[Policy]
+	stat      atomic.Value // 文件修改时间
+}
+
+func NewEngine(pp *Policy) *Engine {
+	ee := &Engine{SubClaim: "sub", RoleClaim: "rol"}
+	ee.policy.Store(pp)
+	return ee
+}
+
+// 从文件加载策略
+func LoadEngine(file string) (*Engine, error) {
+	ee := NewEngine(nil)
+	ee.File = file
+	return ee, ee.Reload()
+}
+
+func (ee *Engine) Policy() *Policy {
+	return ee.policy.Load()
+}
+
+func (ee *Engine) SetPolicy(pp *Policy) {
+	ee.policy.Store(pp)
+}
+
+// 重新加载策略文件， 失败时保留原有策略
+func (ee *Engine) Reload() error {
+	info, err := os.Stat(ee.File)
+	if err != nil {
+		return err
+	}
+	bts, err := os.ReadFile(ee.File)
+	if err != nil {
+		return err
+	}
+	pp, err := Parse(bts)
+	ee.stat.Store(info.ModTime().UnixNano() ^ info.Size())
+	if err != nil {
+		return err
+	}
+	ee.policy.Store(pp)
+	return nil
+}
+
+// 定时检查文件变化并重新加载， 返回停止函数
+func (ee *Engine) Watch(interval time.Duration) func() {
+	stop := make(chan z.Sem)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(ee.File)
+				if err != nil || ee.stat.Load() == info.ModTime().UnixNano()^info.Size() {
+					continue
+				}
+				if err := ee.Reload(); err != nil {
+					z.Logn("[_policy_]: reload policy error,", ee.File, err)
+				} else {
+					z.Logn("[_policy_]: reload policy,", ee.File)
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// 评估授权请求， 没有策略时拒绝
+func (ee *Engine) Eval(in *Input) *Decision {
+	pp := ee.policy.Load()
+	if pp == nil {
+		return &Decision{Trace: []string{"policy not loaded"}}
+	}
+	return pp.Eval(in, ee.Explain)
+}
+
+// 测试接口， POST Input, 返回 Decision(包含匹配过程)
+func (ee *Engine) Check(ctx *z.Ctx) {
+	in, err := z.ReadBody(ctx.Request, &Input{})
+	if err != nil {
+		ctx.JSON(&z.Result{ErrCode: "invalid-input", Message: err.Error(), Status: http.StatusBadRequest})
+		return
+	}
+	if in.Method == "" {
+		in.Method = http.MethodGet
+	}
+	if in.Header == nil {
+		in.Header = http.Header{}
+	}
+	pp := ee.policy.Load()
+	if pp == nil {
+		ctx.JSON(&z.Result{ErrCode: "policy-not-loaded", Message: "策略未加载"})
+		return
+	}
+	z.JSON(ctx, &z.Result{Success: true, Data: pp.Eval(in, true)})
+}
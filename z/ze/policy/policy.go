@@ -0,0 +1,309 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 策略授权(RBAC/ABAC)， 规则文件支持 toml 和 json, 可用于 z 路由和 gtw 网关
+
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/zc"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// 策略文件
+//
+//	default = "deny"
+//	[roles]                     # 角色继承, 子角色 = [父角色]
+//	admin = ["editor"]
+//	[subjects]                  # 用户绑定角色
+//	u1 = ["admin"]
+//	[[rules]]
+//	id = "doc-read"
+//	effect = "allow"            # allow, deny, deny 优先
+//	subjects = ["role:editor"]  # *, auth, user:name, role:name
+//	resources = ["/api/doc/**"] # * 单级, ** 多级, {id} 单级参数
+//	actions = ["GET", "HEAD"]   # HTTP 方法, * 所有
+//	conditions = ["header:X-Tenant == claim:tco"]
+type Policy struct {
+	Default  string              `json:"default"`
+	Roles    map[string][]string `json:"roles"`
+	Subjects map[string][]string `json:"subjects"`
+	Rules    []*Rule             `json:"rules"`
+	parents  map[string][]string // 角色继承展开
+}
+
+type Rule struct {
+	ID         string   `json:"id"`
+	Effect     string   `json:"effect"`
+	Subjects   []string `json:"subjects"`
+	Resources  []string `json:"resources"`
+	Actions    []string `json:"actions"`
+	Hosts      []string `json:"hosts"` // 为空匹配所有, 支持 *.example.com
+	Conditions []string `json:"conditions"`
+	paths      []*regexp.Regexp
+	conds      []*Condition
+}
+
+// 解析策略， 根据内容判断 json 或者 toml
+func Parse(bts []byte) (*Policy, error) {
+	pp := &Policy{}
+	if str := strings.TrimSpace(string(bts)); strings.HasPrefix(str, "{") {
+		if err := json.Unmarshal(bts, pp); err != nil {
+			return nil, err
+		}
+	} else {
+		tmap := map[string]any{}
+		if err := zc.ParseTOML(bts, tmap); err != nil {
+			return nil, err
+		}
+		// 通过 json 转换， 处理嵌套的数组和表
+		if tbs, err := json.Marshal(tmap); err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(tbs, pp); err != nil {
+			return nil, err
+		}
+	}
+	return pp, pp.Compile()
+}
+
+// 编译规则
+func (pp *Policy) Compile() error {
+	if pp.Default == "" {
+		pp.Default = Deny
+	}
+	if pp.Default != Allow && pp.Default != Deny {
+		return fmt.Errorf("policy default must be allow or deny: %s", pp.Default)
+	}
+	// 展开角色继承
+	pp.parents = map[string][]string{}
+	for role := range pp.Roles {
+		all, err := pp.expand(role, []string{role})
+		if err != nil {
+			return err
+		}
+		pp.parents[role] = all
+	}
+	ids := map[string]bool{}
+	for i, rule := range pp.Rules {
+		if rule.ID == "" {
+			rule.ID = "rule-" + strconv.Itoa(i+1)
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("policy rule id duplicate: %s", rule.ID)
+		}
+		ids[rule.ID] = true
+		if rule.Effect == "" {
+			rule.Effect = Allow
+		}
+		if rule.Effect != Allow && rule.Effect != Deny {
+			return fmt.Errorf("policy rule %s effect invalid: %s", rule.ID, rule.Effect)
+		}
+		rule.paths = rule.paths[:0]
+		for _, res := range rule.Resources {
+			rule.paths = append(rule.paths, CompilePath(res))
+		}
+		rule.conds = rule.conds[:0]
+		for _, str := range rule.Conditions {
+			cond, err := ParseCondition(str)
+			if err != nil {
+				return fmt.Errorf("policy rule %s condition: %v", rule.ID, err)
+			}
+			rule.conds = append(rule.conds, cond)
+		}
+	}
+	return nil
+}
+
+// 角色及其继承的所有角色
+func (pp *Policy) expand(role string, path []string) ([]string, error) {
+	all := []string{role}
+	for _, parent := range pp.Roles[role] {
+		if slices.Contains(path, parent) {
+			return nil, errors.New("policy role cycle: " + strings.Join(append(path, parent), " -> "))
+		}
+		sub, err := pp.expand(parent, append(path, parent))
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range sub {
+			if !slices.Contains(all, rr) {
+				all = append(all, rr)
+			}
+		}
+	}
+	return all, nil
+}
+
+// 用户的所有角色， 包括绑定的角色和继承的角色
+func (pp *Policy) RolesOf(subject string, roles []string) []string {
+	all := []string{}
+	add := func(role string) {
+		parents := pp.parents[role]
+		if parents == nil {
+			parents = []string{role}
+		}
+		for _, rr := range parents {
+			if !slices.Contains(all, rr) {
+				all = append(all, rr)
+			}
+		}
+	}
+	for _, role := range roles {
+		add(role)
+	}
+	if subject != "" {
+		for _, role := range pp.Subjects[subject] {
+			add(role)
+		}
+	}
+	return all
+}
+
+// -----------------------------------------------------------------------------------
+
+// 路径模式转正则， * 单级, ** 多级, {name} 单级
+func CompilePath(pattern string) *regexp.Regexp {
+	if pattern == "*" || pattern == "/**" {
+		return regexp.MustCompile(`^.*$`)
+	}
+	buf := strings.Builder{}
+	buf.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				buf.WriteString(".*")
+				i++
+			} else {
+				buf.WriteString("[^/]*")
+			}
+		case '{':
+			if end := strings.IndexByte(pattern[i:], '}'); end > 0 {
+				buf.WriteString("[^/]+")
+				i += end
+			} else {
+				buf.WriteString(`\{`)
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.MustCompile(buf.String())
+}
+
+func matchHost(host string, hosts []string) bool {
+	if len(hosts) == 0 {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, hh := range hosts {
+		if hh == "*" || hh == host || strings.HasPrefix(hh, "*.") && strings.HasSuffix(host, hh[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------
+
+// 条件， 格式: left op right, 操作数: header:Name, claim:name, query:name, ip, method, path, host 或者字面量
+// op: ==, !=, in, !in, ~(正则), prefix, cidr, exists
+type Condition struct {
+	Raw   string
+	Left  string
+	Op    string
+	Right string
+	regex *regexp.Regexp
+	nets  []*net.IPNet
+	list  []string
+}
+
+func ParseCondition(str string) (*Condition, error) {
+	parts := strings.Fields(str)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("condition invalid: %s", str)
+	}
+	cond := &Condition{Raw: str, Left: parts[0], Op: parts[1]}
+	if len(parts) > 2 {
+		cond.Right = strings.Join(parts[2:], " ")
+	}
+	var err error
+	switch cond.Op {
+	case "exists":
+	case "==", "!=", "prefix":
+		if cond.Right == "" {
+			err = errors.New("right operand is empty")
+		}
+	case "in", "!in":
+		for item := range strings.SplitSeq(strings.Trim(cond.Right, "[]"), ",") {
+			if item = strings.Trim(strings.TrimSpace(item), `"'`); item != "" {
+				cond.list = append(cond.list, item)
+			}
+		}
+	case "~":
+		cond.regex, err = regexp.Compile(strings.Trim(cond.Right, "/"))
+	case "cidr":
+		cidrs := []string{}
+		for item := range strings.SplitSeq(strings.Trim(cond.Right, "[]"), ",") {
+			cidrs = append(cidrs, strings.TrimSpace(item))
+		}
+		cond.nets, err = z.ParseCIDRs(cidrs)
+	default:
+		err = errors.New("unknow operator " + cond.Op)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("condition %s: %v", str, err)
+	}
+	return cond, nil
+}
+
+func (cc *Condition) Eval(in *Input) bool {
+	left, ok := in.Value(cc.Left)
+	switch cc.Op {
+	case "exists":
+		return ok && left != ""
+	case "==":
+		right, _ := in.Value(cc.Right)
+		return ok && left == right
+	case "!=":
+		right, _ := in.Value(cc.Right)
+		return left != right
+	case "prefix":
+		right, _ := in.Value(cc.Right)
+		return ok && strings.HasPrefix(left, right)
+	case "in", "!in":
+		found := false
+		for val := range strings.SplitSeq(left, ",") {
+			if slices.Contains(cc.list, strings.TrimSpace(val)) {
+				found = true
+				break
+			}
+		}
+		return ok && found == (cc.Op == "in")
+	case "~":
+		return ok && cc.regex.MatchString(left)
+	case "cidr":
+		ip := net.ParseIP(left)
+		return ip != nil && z.ContainsIP(cc.nets, ip)
+	}
+	return false
+}
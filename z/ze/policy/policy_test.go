@@ -0,0 +1,136 @@
+package policy_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z/ze/policy"
+)
+
+// go test -v z/ze/policy/policy_test.go -run TestPolicy
+func TestPolicy(t *testing.T) {
+	bts, err := os.ReadFile("../../../doc/policy.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp, err := policy.Parse(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := pp.RolesOf("u1", nil); !slices.Equal(roles, []string{"admin", "editor", "viewer"}) {
+		t.Fatal("roles", roles)
+	}
+	input := func(sub, method, path string) *policy.Input {
+		return &policy.Input{
+			Subject:  sub,
+			Claims:   map[string]any{"sub": sub, "tco": "t1"},
+			Method:   method,
+			Path:     path,
+			Header:   http.Header{"X-Tenant": {"t1"}},
+			RemoteIP: "192.168.1.1",
+		}
+	}
+	for _, cc := range []struct {
+		in    *policy.Input
+		allow bool
+		rule  string
+	}{
+		{input("", "GET", "/api/pub/a/b"), true, "public"},
+		{input("", "GET", "/api/doc/1"), false, ""},
+		{input("u1", "GET", "/api/doc/1/x"), true, "doc-read"},
+		{input("u1", "PUT", "/api/doc/1"), true, "doc-write"},
+		{input("u1", "PUT", "/api/doc/1/x"), false, ""},
+		{input("u2", "GET", "/api/doc/1"), false, ""},
+		{input("u1", "GET", "/api/admin/x"), false, "office-only"},
+	} {
+		dd := pp.Eval(cc.in, true)
+		if dd.Allow != cc.allow || dd.Rule != cc.rule {
+			t.Fatal(cc.in.Method, cc.in.Path, dd)
+		}
+	}
+	// 条件不满足
+	in := input("u1", "PUT", "/api/doc/1")
+	in.Header.Set("X-Tenant", "t2")
+	if dd := pp.Eval(in, true); dd.Allow || !strings.Contains(dd.String(), "doc-write: condition false") {
+		t.Fatal(dd)
+	}
+	// 角色来自令牌
+	in = input("u3", "GET", "/api/doc/1")
+	in.Roles = []string{"editor"}
+	if dd := pp.Eval(in, false); !dd.Allow || dd.Trace != nil {
+		t.Fatal(dd)
+	}
+
+	// deny 优先
+	pp, err = policy.Parse([]byte(`{"default":"allow","rules":[
+		{"id":"a","subjects":["*"],"resources":["/x/*"]},
+		{"id":"b","effect":"deny","subjects":["*"],"resources":["/x/**"],"conditions":["ip cidr 10.0.0.0/8"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd := pp.Eval(&policy.Input{Method: "GET", Path: "/x/1", RemoteIP: "10.1.1.1"}, true); dd.Allow || dd.Rule != "b" || len(dd.Matched) != 2 {
+		t.Fatal(dd)
+	}
+	if dd := pp.Eval(&policy.Input{Method: "GET", Path: "/y", RemoteIP: "10.1.1.1"}, true); !dd.Allow || dd.Rule != "" {
+		t.Fatal(dd)
+	}
+
+	// 请求路径清理， 保留末尾的 /
+	for src, dst := range map[string]string{"/api/pub/../doc/1": "/api/doc/1", "/api//doc/./": "/api/doc/", "/": "/"} {
+		req, _ := http.NewRequest("GET", "http://localhost"+src, nil)
+		if in := policy.NewInput(req, nil, "sub", "rol"); in.Path != dst {
+			t.Fatal("path", src, in.Path)
+		}
+	}
+
+	// 错误的策略
+	for _, str := range []string{
+		`{"roles":{"a":["b"],"b":["a"]}}`,
+		`{"rules":[{"id":"a"},{"id":"a"}]}`,
+		`{"rules":[{"effect":"maybe"}]}`,
+		`{"rules":[{"conditions":["ip like x"]}]}`,
+	} {
+		if _, err := policy.Parse([]byte(str)); err == nil {
+			t.Fatal("expect error", str)
+		}
+	}
+}
+
+// go test -v z/ze/policy/policy_test.go -run TestEngineReload
+func TestEngineReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.toml")
+	write := func(str string) {
+		if err := os.WriteFile(file, []byte(str), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("default = \"deny\"\n")
+	ee, err := policy.LoadEngine(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &policy.Input{Method: "GET", Path: "/"}
+	if ee.Eval(in).Allow {
+		t.Fatal("expect deny")
+	}
+	stop := ee.Watch(10 * time.Millisecond)
+	defer stop()
+	// 错误的文件不替换原有策略
+	write("default = \"maybe\"\n")
+	time.Sleep(50 * time.Millisecond)
+	if ee.Policy() == nil || ee.Policy().Default != policy.Deny {
+		t.Fatal("expect old policy")
+	}
+	write("default = \"allow\"\n")
+	for i := 0; !ee.Eval(in).Allow && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ee.Eval(in).Allow {
+		t.Fatal("expect reload")
+	}
+}
@@ -35,10 +35,18 @@ const (
 	stateStopping = "stopping"
 )
 
+// 进程启动选项
+type Options struct {
+	Env        []string   // 追加的环境变量, key=value
+	ExtraFiles []*os.File // 继承的文件, 子进程中文件描述符从 3 开始
+	Detached   bool       // 独立进程, 父进程退出后不会终止子进程
+}
+
 type process0 struct {
 	logger  io.Writer
 	command string
 	args    []string
+	options Options
 
 	mu    sync.Mutex
 	cmd   *exec.Cmd
@@ -59,6 +67,13 @@ func NewProcess(logger io.Writer, command string, args ...string) Process {
 	}
 }
 
+// 使用启动选项创建进程
+func NewProcessOpts(logger io.Writer, opts Options, command string, args ...string) Process {
+	p := NewProcess(logger, command, args...).(*process0)
+	p.options = opts
+	return p
+}
+
 func (p *process0) String() string {
 	// return p.command + " " + strings.Join(p.args, " ")
 	buf := strings.Builder{}
@@ -118,8 +133,12 @@ func (p *process0) enforce() (func(), error) {
 	cmd := exec.Command(p.command, p.args...)
 	cmd.Stdout = p.logger
 	cmd.Stderr = p.logger
+	if len(p.options.Env) > 0 {
+		cmd.Env = append(os.Environ(), p.options.Env...)
+	}
+	cmd.ExtraFiles = p.options.ExtraFiles
 	// 在非 Windows 系统上，设置 SysProcAttr 以创建新的进程组
-	if attr := newSysProcAttr(); attr != nil {
+	if attr := newSysProcAttr(p.options.Detached); attr != nil {
 		cmd.SysProcAttr = attr
 	}
 	// 启动进程
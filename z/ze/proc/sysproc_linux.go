@@ -4,9 +4,14 @@ package proc
 
 import "syscall"
 
-func newSysProcAttr() *syscall.SysProcAttr {
+func newSysProcAttr(detached bool) *syscall.SysProcAttr {
+	if detached {
+		return &syscall.SysProcAttr{
+			Setpgid: true,
+		}
+	}
 	return &syscall.SysProcAttr{
 		Setpgid:   true,
 		Pdeathsig: syscall.SIGKILL,
 	}
-}
\ No newline at end of file
+}
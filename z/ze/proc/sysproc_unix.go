@@ -4,7 +4,7 @@ package proc
 
 import "syscall"
 
-func newSysProcAttr() *syscall.SysProcAttr {
+func newSysProcAttr(detached bool) *syscall.SysProcAttr {
 	return &syscall.SysProcAttr{
 		Setpgid: true,
 	}
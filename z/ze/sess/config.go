@@ -0,0 +1,106 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package sess
+
+import (
+	"crypto/rand"
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var (
+	G = struct {
+		Server ServerConfig
+	}{}
+)
+
+// 会话配置， 合并到 [server] 配置中
+type ServerConfig struct {
+	SessStore    string   `json:"sessstore"`    // 会话存储, cookie, memory, file:/path, 为空禁用
+	SessName     string   `json:"sessname"`     // cookie 名称
+	SessSecret   string   `json:"sesssecret"`   // 签名/加密密钥, 为空时随机生成(重启后会话失效)
+	SessMaxAge   int      `json:"sessmaxage"`   // 会话有效期(秒)
+	SessSecure   bool     `json:"sesssecure"`   // cookie secure
+	SessSameSite string   `json:"sesssamesite"` // cookie samesite, lax, strict, none
+	SessDomain   string   `json:"sessdomain"`   // cookie domain
+	SessSites    []string `json:"sesssites"`    // 站点域名, 根据请求 host 匹配 cookie domain
+	SessCSRF     bool     `json:"sesscsrf"`     // 验证 CSRF 令牌
+}
+
+func init() {
+	z.Config(&G)
+	flag.StringVar(&G.Server.SessStore, "sessstore", "", "session store, cookie, memory, file:/path")
+	flag.StringVar(&G.Server.SessName, "sessname", "_zs", "session cookie name")
+	flag.StringVar(&G.Server.SessSecret, "sesssecret", "", "session secret")
+	flag.IntVar(&G.Server.SessMaxAge, "sessmaxage", 86400, "session max age(second)")
+	flag.BoolVar(&G.Server.SessSecure, "sesssecure", false, "session cookie secure")
+	flag.StringVar(&G.Server.SessSameSite, "sesssamesite", "lax", "session cookie samesite, lax, strict, none")
+	flag.StringVar(&G.Server.SessDomain, "sessdomain", "", "session cookie domain")
+	flag.Var(z.NewStrArr(&G.Server.SessSites, []string{}), "sesssites", "session cookie site hosts")
+	flag.BoolVar(&G.Server.SessCSRF, "sesscsrf", false, "session csrf token verify")
+
+	z.Register("10-sess", func(zgg *z.Zgg) z.Closed {
+		if G.Server.SessStore == "" {
+			return nil
+		}
+		secret := []byte(G.Server.SessSecret)
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			rand.Read(secret)
+			z.Logn("[_session]: session secret is empty, use random secret")
+		}
+		maxAge := time.Duration(G.Server.SessMaxAge) * time.Second
+		var store Store
+		var closed z.Closed
+		switch kind := G.Server.SessStore; {
+		case kind == "cookie":
+			cs, err := NewCookieStore(secret)
+			if err != nil {
+				zgg.ServeStop("[_session]: cookie store error:", err.Error())
+				return nil
+			}
+			store = cs
+		case kind == "memory":
+			ms := NewMemoryStore(secret)
+			ms.Start(time.Minute)
+			store, closed = ms, ms.Close
+		case strings.HasPrefix(kind, "file:"):
+			fst, err := NewFileStore(secret, strings.TrimPrefix(kind, "file:"))
+			if err != nil {
+				zgg.ServeStop("[_session]: file store error:", err.Error())
+				return nil
+			}
+			fst.Evict(time.Now())
+			fst.Start(10 * time.Minute)
+			store, closed = fst, fst.Close
+		default:
+			zgg.ServeStop("[_session]: unknown session store:", kind)
+			return nil
+		}
+		mm := NewManager(store, G.Server.SessName, maxAge)
+		mm.Secure, mm.Domain, mm.Sites, mm.CSRF = G.Server.SessSecure, G.Server.SessDomain, G.Server.SessSites, G.Server.SessCSRF
+		mm.SameSite = ParseSameSite(G.Server.SessSameSite)
+		zgg.Filters.Add(mm.Filter)
+		z.Logn("[_session]: session filter enabled, store=", G.Server.SessStore)
+		return closed
+	})
+}
+
+func ParseSameSite(str string) http.SameSite {
+	switch strings.ToLower(str) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "", "lax":
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteDefaultMode
+}
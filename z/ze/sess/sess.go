@@ -0,0 +1,319 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package sess
+
+// 会话管理， 使用 Filter 加载会话， 处理函数中使用 ctx.Session() 获取会话
+// 会话在响应头写出之前保存， 并更新 cookie
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/gtw"
+)
+
+// CSRF 令牌请求头
+const CSRFHeader = "X-CSRF-Token"
+
+// 会话管理
+type Manager struct {
+	Store    Store
+	Name     string        // cookie 名称
+	MaxAge   time.Duration // 会话有效期
+	Path     string
+	Domain   string   // 为空时使用 Sites 匹配的域名
+	Sites    []string // 站点域名， 与 gtw.AuthRecord.SiteHosts 一致
+	Secure   bool
+	SameSite http.SameSite
+	CSRF     bool // 验证非安全方法(POST, PUT, PATCH, DELETE)的 CSRF 令牌
+}
+
+func NewManager(store Store, name string, maxAge time.Duration) *Manager {
+	return &Manager{Store: store, Name: name, MaxAge: maxAge, Path: "/", SameSite: http.SameSiteLaxMode}
+}
+
+// 加载会话， 不存在或者无效时创建新的会话(只有修改后才会保存)
+func (mm *Manager) Load(rr *http.Request) *Session {
+	ss := &Session{mgr: mm, host: rr.Host}
+	if ck, err := rr.Cookie(mm.Name); err == nil && ck.Value != "" {
+		ss.cookie = true
+		id, data, err := mm.Store.Load(ck.Value)
+		if err != nil && z.IsDebug() {
+			z.Logn("[_session]: load error:", err)
+		}
+		if err == nil && data != nil {
+			ss.id, ss.cid, ss.data = id, id, data
+			return ss
+		}
+	}
+	ss.id, ss.data, ss.isNew = NewID(), map[string]any{}, true
+	return ss
+}
+
+// 过滤器， 加载会话并存放到请求 context 中
+func (mm *Manager) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		ss := mm.Load(rr)
+		rr = rr.WithContext(z.WithSession(rr.Context(), ss))
+		// 非安全方法都需要验证 CSRF 令牌， 没有会话时需要先通过安全方法获取令牌
+		if mm.CSRF && !IsSafeMethod(rr.Method) && !Verify(rr, ss) {
+			z.JSON0(rr, rw, &z.Result{ErrCode: "invalid-csrf-token", Message: "无效的CSRF令牌", Status: http.StatusForbidden})
+			return
+		}
+		ww := &Writer{ResponseWriter: rw, ss: ss}
+		next.ServeHTTP(ww, rr)
+		if !ww.wrote {
+			ss.commit(rw, true)
+		} else {
+			ss.commit(rw, false) // 响应头已经写出， 只能保存服务端数据
+		}
+	})
+}
+
+// 路由中间件
+func Wrap(mm *Manager, handle z.HandleFunc) z.HandleFunc {
+	return func(ctx *z.Ctx) {
+		mm.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+			ctx.Request, ctx.Writer = rr, rw
+			handle(ctx)
+		})).ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
+
+func (mm *Manager) cookie(host, val string, maxAge int) *http.Cookie {
+	domain := mm.Domain
+	if domain == "" {
+		domain = gtw.MatchSiteHost(host, mm.Sites)
+	}
+	return &http.Cookie{
+		Name:     mm.Name,
+		Value:    val,
+		Path:     mm.Path,
+		Domain:   domain,
+		MaxAge:   maxAge,
+		Secure:   mm.Secure,
+		HttpOnly: true,
+		SameSite: mm.SameSite,
+	}
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ z.Session = (*Session)(nil)
+
+type Session struct {
+	mgr    *Manager
+	id     string
+	old    string // 重新生成前的 ID, 保存时删除
+	host   string
+	data   map[string]any
+	lock   sync.Mutex
+	isNew  bool // 新建的会话
+	cookie bool // 请求携带了会话 cookie
+	dirty  bool
+	delete bool
+	cid    string // 客户端 cookie 中的会话 ID
+}
+
+func (ss *Session) ID() string {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.id
+}
+
+func (ss *Session) IsNew() bool {
+	return ss.isNew
+}
+
+func (ss *Session) Get(key string) any {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.data[key]
+}
+
+func (ss *Session) Set(key string, val any) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.data[key] = val
+	ss.dirty = true
+}
+
+func (ss *Session) Delete(key string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if _, ok := ss.data[key]; ok {
+		delete(ss.data, key)
+		ss.dirty = true
+	}
+}
+
+// 数据副本
+func (ss *Session) Values() map[string]any {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return maps.Clone(ss.data)
+}
+
+func (ss *Session) Regenerate() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.delete {
+		return errors.New("session destroyed")
+	}
+	if !ss.isNew && ss.old == "" {
+		ss.old = ss.id
+	}
+	ss.id, ss.dirty = NewID(), true
+	delete(ss.data, CSRFField()) // 重新生成 CSRF 令牌
+	return nil
+}
+
+func (ss *Session) Destroy() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.data, ss.delete = map[string]any{}, true
+	return nil
+}
+
+// 保存会话， header 表示是否可以写出 cookie
+func (ss *Session) commit(rw http.ResponseWriter, header bool) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	mm := ss.mgr
+	if ss.delete {
+		if !ss.isNew {
+			mm.Store.Delete(ss.id)
+		}
+		if ss.old != "" {
+			mm.Store.Delete(ss.old)
+		}
+		if header && ss.cookie {
+			http.SetCookie(rw, mm.cookie(ss.host, "", -1))
+		}
+		ss.delete, ss.isNew = false, true
+		return
+	}
+	if !ss.dirty {
+		return
+	}
+	if !header {
+		// 响应头已经写出， 客户端无法获取新的 cookie, 只能更新服务端已有的会话
+		if _, ok := mm.Store.(*CookieStore); ok || ss.id != ss.cid {
+			z.Logn("[_session]: session changed after response header written, id:", ss.id)
+			return
+		}
+	}
+	if ss.old != "" {
+		mm.Store.Delete(ss.old)
+		ss.old = ""
+	}
+	val, err := mm.Store.Save(ss.id, ss.data, mm.MaxAge)
+	if err != nil {
+		z.Logn("[_session]: save error:", err)
+		return
+	}
+	if header {
+		http.SetCookie(rw, mm.cookie(ss.host, val, int(mm.MaxAge.Seconds())))
+		ss.cid = ss.id
+	}
+	ss.dirty, ss.isNew = false, false
+}
+
+// -----------------------------------------------------------------------------------
+
+// 在写出响应头之前保存会话
+type Writer struct {
+	http.ResponseWriter
+	ss    *Session
+	wrote bool
+}
+
+func (ww *Writer) Unwrap() http.ResponseWriter {
+	return ww.ResponseWriter
+}
+
+func (ww *Writer) WriteHeader(code int) {
+	if !ww.wrote {
+		ww.wrote = true
+		ww.ss.commit(ww.ResponseWriter, true)
+	}
+	ww.ResponseWriter.WriteHeader(code)
+}
+
+func (ww *Writer) Write(bts []byte) (int, error) {
+	if !ww.wrote {
+		ww.WriteHeader(http.StatusOK)
+	}
+	return ww.ResponseWriter.Write(bts)
+}
+
+func (ww *Writer) Flush() {
+	if !ww.wrote {
+		ww.WriteHeader(http.StatusOK)
+	}
+	if fw, ok := ww.ResponseWriter.(http.Flusher); ok {
+		fw.Flush()
+	}
+}
+
+func (ww *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := ww.ResponseWriter.(http.Hijacker); ok {
+		ww.wrote = true
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// -----------------------------------------------------------------------------------
+// CSRF
+
+// 会话中 CSRF 令牌的 key, 与表单字段名称一致
+func CSRFField() string {
+	return z.CSRFField
+}
+
+// 获取 CSRF 令牌， 不存在时创建， 模版中使用 {{csrf .CSRF}} 输出隐藏字段
+func Token(ss z.Session) string {
+	if tkn, ok := ss.Get(CSRFField()).(string); ok && tkn != "" {
+		return tkn
+	}
+	bts := make([]byte, 24)
+	rand.Read(bts)
+	tkn := base64.RawURLEncoding.EncodeToString(bts)
+	ss.Set(CSRFField(), tkn)
+	return tkn
+}
+
+// 验证 CSRF 令牌， 从请求头 X-CSRF-Token 或者表单字段中获取
+func Verify(rr *http.Request, ss z.Session) bool {
+	tkn, ok := ss.Get(CSRFField()).(string)
+	if !ok || tkn == "" {
+		return false
+	}
+	val := rr.Header.Get(CSRFHeader)
+	if val == "" {
+		val = rr.PostFormValue(CSRFField())
+	}
+	return hmac.Equal([]byte(val), []byte(tkn))
+}
+
+// 安全方法， 不需要验证 CSRF 令牌
+func IsSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package sess_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/sess"
+)
+
+// go test -v z/ze/sess/sess_test.go -run TestStores
+func TestStores(t *testing.T) {
+	secret := []byte("secret")
+	cs, _ := sess.NewCookieStore(secret)
+	fst, err := sess.NewFileStore(secret, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]sess.Store{"cookie": cs, "memory": sess.NewMemoryStore(secret), "file": fst}
+	for name, store := range stores {
+		id := sess.NewID()
+		val, err := store.Save(id, map[string]any{"user": "zgg"}, time.Minute)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		id1, data, err := store.Load(val)
+		if err != nil || id1 != id || data["user"] != "zgg" {
+			t.Fatal(name, id1, data, err)
+		}
+		// 篡改的 cookie
+		if _, _, err := store.Load(val[:len(val)-2] + "xx"); err == nil {
+			t.Fatal(name, "expect invalid")
+		}
+		// 过期
+		val, _ = store.Save(id, map[string]any{"user": "zgg"}, -time.Minute)
+		if _, data, err := store.Load(val); err != nil || data != nil {
+			t.Fatal(name, data, err)
+		}
+	}
+	ms := stores["memory"].(*sess.MemoryStore)
+	if n := ms.Evict(time.Now()); n != 1 {
+		t.Fatal(n)
+	}
+	if n := fst.Evict(time.Now()); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+// go test -v z/ze/sess/sess_test.go -run TestManager
+func TestManager(t *testing.T) {
+	mm := sess.NewManager(sess.NewMemoryStore([]byte("secret")), "_zs", time.Hour)
+	mm.Sites, mm.CSRF = []string{"example.com"}, true
+	hdl := mm.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		ss := z.SessionFrom(rr.Context())
+		switch rr.URL.Path {
+		case "/login":
+			ss.Regenerate()
+			ss.Set("user", "zgg")
+		case "/logout":
+			ss.Destroy()
+		case "/form":
+			rw.Write([]byte(sess.Token(ss)))
+			return
+		}
+		user, _ := ss.Get("user").(string)
+		rw.Write([]byte(user))
+	}))
+	serve := func(method, path string, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+		var req *http.Request
+		if form != nil {
+			req = httptest.NewRequest(method, "http://www.example.com"+path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, "http://www.example.com"+path, nil)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	cookieOf := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, ck := range rec.Result().Cookies() {
+			if ck.Name == "_zs" {
+				return ck
+			}
+		}
+		return nil
+	}
+
+	// 未修改的会话不保存
+	if rec := serve("GET", "/", nil, nil); cookieOf(rec) != nil {
+		t.Fatal("expect no cookie")
+	}
+	// 没有会话也需要 CSRF 令牌
+	if rec := serve("POST", "/login", nil, url.Values{}); rec.Code != http.StatusForbidden {
+		t.Fatal("expect csrf error", rec.Code)
+	}
+	tkn := serve("GET", "/form", nil, nil)
+	rec := serve("POST", "/login", cookieOf(tkn), url.Values{z.CSRFField: {tkn.Body.String()}})
+	ck1 := cookieOf(rec)
+	if ck1 == nil || ck1.Domain != "example.com" || !ck1.HttpOnly || ck1.SameSite != http.SameSiteLaxMode {
+		t.Fatal(ck1)
+	}
+	if rec := serve("GET", "/", ck1, nil); rec.Body.String() != "zgg" {
+		t.Fatal(rec.Body.String())
+	}
+	// 重新生成会话 ID, 旧的会话失效
+	rec = serve("POST", "/login", ck1, url.Values{})
+	if rec.Code != http.StatusForbidden {
+		t.Fatal("expect csrf error", rec.Code)
+	}
+	tkn = serve("GET", "/form", ck1, nil)
+	ck1 = cookieOf(tkn)
+	rec = serve("POST", "/login", ck1, url.Values{z.CSRFField: {tkn.Body.String()}})
+	ck2 := cookieOf(rec)
+	if ck2 == nil || ck2.Value == ck1.Value {
+		t.Fatal(rec.Code, ck2)
+	}
+	if rec := serve("GET", "/", ck1, nil); rec.Body.String() != "" {
+		t.Fatal(rec.Body.String())
+	}
+	// 销毁会话
+	tkn = serve("GET", "/form", ck2, nil)
+	ck2 = cookieOf(tkn)
+	req := url.Values{}
+	rec = serve("POST", "/logout", ck2, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code)
+	}
+	req.Set(z.CSRFField, tkn.Body.String())
+	rec = serve("POST", "/logout", ck2, req)
+	if ck := cookieOf(rec); ck == nil || ck.MaxAge >= 0 {
+		t.Fatal(ck)
+	}
+	if rec := serve("GET", "/", ck2, nil); rec.Body.String() != "" {
+		t.Fatal(rec.Body.String())
+	}
+}
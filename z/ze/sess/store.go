@@ -0,0 +1,341 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package sess
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var (
+	ErrInvalid = errors.New("session: invalid cookie value")
+)
+
+// 会话存储， val 为 cookie 的值
+// 服务端存储(memory, file) cookie 中只保存签名的会话 ID, cookie 存储将加密的数据保存在 cookie 中
+type Store interface {
+	Load(val string) (id string, data map[string]any, err error) // 会话不存在或者过期返回 nil 数据
+	Save(id string, data map[string]any, ttl time.Duration) (val string, err error)
+	Delete(id string) error
+}
+
+// 生成会话 ID
+func NewID() string {
+	bts := make([]byte, 16)
+	rand.Read(bts)
+	return hex.EncodeToString(bts)
+}
+
+// 会话 ID 只能是 hex 字符， 防止文件存储路径穿越
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// 会话数据， 存储使用
+type entry struct {
+	ID   string         `json:"id,omitempty"`
+	Exp  int64          `json:"exp"`
+	Data map[string]any `json:"data"`
+}
+
+// -----------------------------------------------------------------------------------
+
+// 签名会话 ID, id.sign
+type Signer []byte
+
+func (ss Signer) Sign(id string) string {
+	mac := hmac.New(sha256.New, ss)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (ss Signer) Verify(val string) (string, bool) {
+	id, _, ok := strings.Cut(val, ".")
+	if !ok || !validID(id) || !hmac.Equal([]byte(ss.Sign(id)), []byte(val)) {
+		return "", false
+	}
+	return id, true
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Store = (*MemoryStore)(nil)
+
+// 内存存储， 定时清理过期会话
+type MemoryStore struct {
+	Signer
+	data map[string]*memEntry
+	lock sync.Mutex
+	stop chan z.Sem
+}
+
+type memEntry struct {
+	exp int64
+	bts []byte // 使用 JSON 复制数据， 避免并发请求共享同一个 map
+}
+
+func NewMemoryStore(secret []byte) *MemoryStore {
+	return &MemoryStore{Signer: secret, data: map[string]*memEntry{}}
+}
+
+func (ms *MemoryStore) Load(val string) (string, map[string]any, error) {
+	id, ok := ms.Verify(val)
+	if !ok {
+		return "", nil, ErrInvalid
+	}
+	ms.lock.Lock()
+	me := ms.data[id]
+	ms.lock.Unlock()
+	if me == nil || me.exp < time.Now().Unix() {
+		return id, nil, nil
+	}
+	data := map[string]any{}
+	return id, data, json.Unmarshal(me.bts, &data)
+}
+
+func (ms *MemoryStore) Save(id string, data map[string]any, ttl time.Duration) (string, error) {
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	ms.lock.Lock()
+	ms.data[id] = &memEntry{exp: time.Now().Add(ttl).Unix(), bts: bts}
+	ms.lock.Unlock()
+	return ms.Sign(id), nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.lock.Lock()
+	delete(ms.data, id)
+	ms.lock.Unlock()
+	return nil
+}
+
+// 清理过期会话， 返回清理数量
+func (ms *MemoryStore) Evict(now time.Time) int {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	count := 0
+	for id, me := range ms.data {
+		if me.exp < now.Unix() {
+			delete(ms.data, id)
+			count++
+		}
+	}
+	return count
+}
+
+// 启动定时清理
+func (ms *MemoryStore) Start(interval time.Duration) {
+	ms.stop = make(chan z.Sem)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ms.stop:
+				return
+			case now := <-ticker.C:
+				ms.Evict(now)
+			}
+		}
+	}()
+}
+
+func (ms *MemoryStore) Close() {
+	if ms.stop != nil {
+		close(ms.stop)
+		ms.stop = nil
+	}
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Store = (*FileStore)(nil)
+
+// 文件存储， 每个会话一个文件 <dir>/<id>.json, 定时清理过期会话
+type FileStore struct {
+	Signer
+	Dir  string
+	stop chan z.Sem
+}
+
+func NewFileStore(secret []byte, dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{Signer: secret, Dir: dir}, nil
+}
+
+func (fst *FileStore) path(id string) string {
+	return filepath.Join(fst.Dir, id+".json")
+}
+
+func (fst *FileStore) Load(val string) (string, map[string]any, error) {
+	id, ok := fst.Verify(val)
+	if !ok {
+		return "", nil, ErrInvalid
+	}
+	bts, err := os.ReadFile(fst.path(id))
+	if os.IsNotExist(err) {
+		return id, nil, nil
+	} else if err != nil {
+		return id, nil, err
+	}
+	ent := entry{}
+	if err := json.Unmarshal(bts, &ent); err != nil {
+		return id, nil, err
+	}
+	if ent.Exp < time.Now().Unix() {
+		return id, nil, nil
+	}
+	return id, ent.Data, nil
+}
+
+func (fst *FileStore) Save(id string, data map[string]any, ttl time.Duration) (string, error) {
+	if !validID(id) {
+		return "", ErrInvalid
+	}
+	bts, err := json.Marshal(&entry{Exp: time.Now().Add(ttl).Unix(), Data: data})
+	if err != nil {
+		return "", err
+	}
+	// 先写临时文件， 再重命名， 保证写入的原子性
+	tmp := fst.path(id) + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, fst.path(id)); err != nil {
+		return "", err
+	}
+	return fst.Sign(id), nil
+}
+
+func (fst *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return ErrInvalid
+	}
+	if err := os.Remove(fst.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 清理过期会话， 返回清理数量
+func (fst *FileStore) Evict(now time.Time) int {
+	files, _ := filepath.Glob(filepath.Join(fst.Dir, "*.json"))
+	count := 0
+	for _, file := range files {
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		ent := entry{}
+		if json.Unmarshal(bts, &ent) != nil || ent.Exp < now.Unix() {
+			if os.Remove(file) == nil {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// 启动定时清理
+func (fst *FileStore) Start(interval time.Duration) {
+	fst.stop = make(chan z.Sem)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-fst.stop:
+				return
+			case now := <-ticker.C:
+				fst.Evict(now)
+			}
+		}
+	}()
+}
+
+func (fst *FileStore) Close() {
+	if fst.stop != nil {
+		close(fst.stop)
+		fst.stop = nil
+	}
+}
+
+// -----------------------------------------------------------------------------------
+
+var _ Store = (*CookieStore)(nil)
+
+// cookie 存储， 数据使用 AES-GCM 加密并认证后保存在 cookie 中， 注意 cookie 大小限制(4KB)
+type CookieStore struct {
+	aead cipher.AEAD
+}
+
+func NewCookieStore(secret []byte) (*CookieStore, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieStore{aead: aead}, nil
+}
+
+func (cs *CookieStore) Load(val string) (string, map[string]any, error) {
+	bts, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil || len(bts) < cs.aead.NonceSize() {
+		return "", nil, ErrInvalid
+	}
+	nonce, data := bts[:cs.aead.NonceSize()], bts[cs.aead.NonceSize():]
+	if data, err = cs.aead.Open(nil, nonce, data, nil); err != nil {
+		return "", nil, ErrInvalid
+	}
+	ent := entry{}
+	if err := json.Unmarshal(data, &ent); err != nil {
+		return "", nil, ErrInvalid
+	}
+	if ent.Exp < time.Now().Unix() {
+		return ent.ID, nil, nil
+	}
+	return ent.ID, ent.Data, nil
+}
+
+func (cs *CookieStore) Save(id string, data map[string]any, ttl time.Duration) (string, error) {
+	bts, err := json.Marshal(&entry{ID: id, Exp: time.Now().Add(ttl).Unix(), Data: data})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, cs.aead.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(cs.aead.Seal(nonce, nonce, bts, nil)), nil
+}
+
+func (cs *CookieStore) Delete(id string) error {
+	return nil // 数据在 cookie 中， 删除 cookie 即可
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// SSE 广播中心， 支持多订阅者和断线重放
+
+package ssez
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var _ z.SSEReplay = (*Ring)(nil)
+
+// 环形重放缓冲， 事件 ID 为递增序号
+type Ring struct {
+	mu    sync.RWMutex
+	items []*z.SSEvent
+	seqs  []uint64
+	next  int
+	full  bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{items: make([]*z.SSEvent, max(size, 1)), seqs: make([]uint64, max(size, 1))}
+}
+
+func (rg *Ring) Add(seq uint64, ev *z.SSEvent) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.items[rg.next], rg.seqs[rg.next] = ev, seq
+	rg.next = (rg.next + 1) % len(rg.items)
+	if rg.next == 0 {
+		rg.full = true
+	}
+}
+
+// 返回 lastID 之后的事件， lastID 无法解析时返回空
+func (rg *Ring) Since(lastID string) []*z.SSEvent {
+	last, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	rg.mu.RLock()
+	defer rg.mu.RUnlock()
+	start, size := 0, rg.next
+	if rg.full {
+		start, size = rg.next, len(rg.items)
+	}
+	evs := []*z.SSEvent{}
+	for i := range size {
+		idx := (start + i) % len(rg.items)
+		if rg.seqs[idx] > last {
+			evs = append(evs, rg.items[idx])
+		}
+	}
+	return evs
+}
+
+// -----------------------------------------------------------------------------------
+
+// 广播中心
+type Hub struct {
+	Buffer    int           // 订阅者通道缓存, 缓存满时断开订阅者， 由客户端重连后重放
+	Heartbeat time.Duration // 心跳间隔
+	Ring      *Ring         // 重放缓冲
+	mu        sync.RWMutex
+	seq       uint64
+	subs      map[chan *z.SSEvent]struct{}
+	closed    bool
+}
+
+// size: 重放缓冲大小
+func NewHub(size int) *Hub {
+	return &Hub{Buffer: 64, Heartbeat: 15 * time.Second, Ring: NewRing(size), subs: map[chan *z.SSEvent]struct{}{}}
+}
+
+// 发布事件， 自动分配 ID
+func (hub *Hub) Publish(event, data string) *z.SSEvent {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		return nil
+	}
+	hub.seq++
+	ev := &z.SSEvent{ID: strconv.FormatUint(hub.seq, 10), Event: event, Data: data}
+	hub.Ring.Add(hub.seq, ev)
+	for ch := range hub.subs {
+		select {
+		case ch <- ev:
+		default:
+			// 订阅者处理过慢， 断开连接
+			delete(hub.subs, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// 订阅事件， cancel 取消订阅
+func (hub *Hub) Subscribe() (<-chan *z.SSEvent, func()) {
+	ch := make(chan *z.SSEvent, max(hub.Buffer, 1))
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	hub.subs[ch] = struct{}{}
+	return ch, func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		if _, ok := hub.subs[ch]; ok {
+			delete(hub.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// 订阅者数量
+func (hub *Hub) Size() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.subs)
+}
+
+func (hub *Hub) Since(lastID string) []*z.SSEvent {
+	return hub.Ring.Since(lastID)
+}
+
+// 关闭广播中心， 断开所有订阅者
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.closed = true
+	for ch := range hub.subs {
+		close(ch)
+	}
+	clear(hub.subs)
+}
+
+// SSE 路由处理函数
+func (hub *Hub) Serve(ctx *z.Ctx) {
+	// 先订阅， 后重放， 避免丢失事件
+	events, cancel := hub.Subscribe()
+	defer cancel()
+	ss, err := ctx.SSE(hub, hub.Heartbeat)
+	if err != nil {
+		z.Logn("[_ssez___]: sse stream error:", err)
+		return
+	}
+	defer ss.Close()
+	last, _ := strconv.ParseUint(ss.LastID, 10, 64)
+	for {
+		select {
+		case <-ss.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if seq, _ := strconv.ParseUint(ev.ID, 10, 64); seq <= last {
+				continue // 已经重放
+			}
+			if err := ss.Send(ev); err != nil {
+				return
+			}
+		}
+	}
+}
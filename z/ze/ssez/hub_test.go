@@ -0,0 +1,65 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package ssez_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/ssez"
+)
+
+// go test -v z/ze/ssez/hub_test.go -run Test_hub
+
+func Test_hub(t *testing.T) {
+	hub := ssez.NewHub(2)
+	defer hub.Close()
+	hub.Publish("msg", "one")
+	hub.Publish("msg", "two")
+	hub.Publish("msg", "three\nlines")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		ctx := z.NewCtx(nil, rr, rw, "test")
+		defer ctx.Clear()
+		hub.Serve(ctx)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("content-type: %s", ct)
+	}
+	for hub.Size() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	hub.Publish("msg", "four")
+
+	want := []string{
+		"id: 2", "event: msg", "data: two", "",
+		"id: 3", "event: msg", "data: three", "data: lines", "",
+		"id: 4", "event: msg", "data: four", "",
+	}
+	scan := bufio.NewScanner(res.Body)
+	for i, line := range want {
+		if !scan.Scan() {
+			t.Fatalf("line %d: %v", i, scan.Err())
+		}
+		if scan.Text() != line {
+			t.Fatalf("line %d: got %q, want %q", i, scan.Text(), line)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -353,3 +354,41 @@ func CreateCE(certConfig CertConfig, commonName string, dns []string, ips []net.
 
 	return SignResult{Crt: string(crtBytes), Key: string(keyBytes)}, nil
 }
+
+// 创建一个客户端证书， 用于双向 TLS, uris 可以包含 SPIFFE ID, 默认有效期10年
+func CreateCC(certConfig CertConfig, commonName string, uris []*url.URL, emails []string, caCrtPemBts, caKeyPemBts []byte) (SignResult, error) {
+	if commonName == "" {
+		return SignResult{}, fmt.Errorf("invalid commonName")
+	}
+	if caCrtPemBts == nil || caKeyPemBts == nil {
+		return SignResult{}, fmt.Errorf("client cert need ca")
+	}
+	ata, err := _cdata(certConfig, commonName, caCrtPemBts, caKeyPemBts)
+	if err != nil {
+		return SignResult{}, err
+	}
+	pkey, _ := rsa.GenerateKey(rand.Reader, ata.KeySize) //生成一对具有指定字位数的RSA密钥
+
+	sermax := new(big.Int).Lsh(big.NewInt(1), 128) //把 1 左移 128 位，返回给 big.Int
+	serial, _ := rand.Int(rand.Reader, sermax)     //返回在 [0, max) 区间均匀随机分布的一个随机值
+	pder := x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            ata.Subject,
+		NotBefore:          time.Now(),
+		NotAfter:           ata.NotAfter,
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		SignatureAlgorithm: ata.Algorithm,
+		URIs:               uris,
+		EmailAddresses:     emails,
+	}
+	// ----------------------------------------------------------------------------
+	derBytes, err := x509.CreateCertificate(rand.Reader, &pder, ata.CaCrt, &pkey.PublicKey, ata.CaKey)
+	if err != nil {
+		return SignResult{}, err
+	}
+	crtBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pkey)})
+
+	return SignResult{Crt: string(crtBytes), Key: string(keyBytes)}, nil
+}
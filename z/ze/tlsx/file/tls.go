@@ -12,6 +12,7 @@ import (
 	"flag"
 
 	"github.com/suisrc/zgg/z"
+	"github.com/suisrc/zgg/z/ze/tlsx"
 )
 
 var (
@@ -23,12 +24,17 @@ var (
 type ServerConfig struct {
 	CrtFile string `json:"crtfile"`
 	KeyFile string `json:"keyfile"`
+	// 双向 TLS, 客户端证书 CA 和验证模式(none, request, require, optional, verify)
+	ClientCA   []string `json:"clientca"`
+	ClientAuth string   `json:"clientauth"`
 }
 
 func init() {
 	z.Config(&G)
 	flag.StringVar(&(G.Server.CrtFile), "crt", "", "http server crt file")
 	flag.StringVar(&(G.Server.KeyFile), "key", "", "http server key file")
+	flag.Var(z.NewStrArr(&G.Server.ClientCA, []string{}), "clientca", "http server client ca file")
+	flag.StringVar(&G.Server.ClientAuth, "clientauth", "", "client cert auth mode: none, request, require, optional, verify")
 
 	z.Register("10-tlsfile", func(zgg *z.Zgg) z.Closed {
 		if G.Server.CrtFile == "" || G.Server.KeyFile == "" {
@@ -45,6 +51,10 @@ func init() {
 			zgg.ServeStop("[_tlsfile]: error:", err.Error())
 			return nil
 		}
+		if err := tlsx.SetClientAuth(cfg, G.Server.ClientCA, G.Server.ClientAuth); err != nil {
+			zgg.ServeStop("[_tlsfile]: client auth error:", err.Error())
+			return nil
+		}
 		zgg.TLSConf = cfg
 
 		return nil
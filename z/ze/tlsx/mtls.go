@@ -0,0 +1,272 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 双向 TLS， 客户端证书验证和身份提取
+
+package tlsx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// 客户端证书身份请求头前缀， 验证前删除客户端传入的同名请求头
+const CertPrefix = "X-Request-Sky-Cert-"
+
+var (
+	ErrCertMissing  = errors.New("client certificate missing")
+	ErrCertNotAllow = errors.New("client certificate not allowed")
+)
+
+// 解析客户端证书验证模式
+// none: 不请求, request: 请求不验证, require: 必须提供不验证, optional: 提供时验证, verify: 必须提供并验证
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "verify":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknow client auth mode: %s", mode)
+}
+
+// 加载 CA 证书， 每个文件可以包含多个证书
+func LoadCertPool(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, fmt.Errorf("no certificate in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+// 配置客户端证书验证， 配置了 CA 但没有指定模式时使用 verify
+func SetClientAuth(cfg *tls.Config, cafiles []string, mode string) error {
+	if mode == "" && len(cafiles) > 0 {
+		mode = "verify"
+	}
+	auth, err := ParseClientAuth(mode)
+	if err != nil {
+		return err
+	}
+	if auth >= tls.VerifyClientCertIfGiven && len(cafiles) == 0 {
+		return fmt.Errorf("client auth %s need client ca", mode)
+	}
+	if len(cafiles) > 0 {
+		if cfg.ClientCAs, err = LoadCertPool(cafiles); err != nil {
+			return err
+		}
+	}
+	cfg.ClientAuth = auth
+	return nil
+}
+
+// -----------------------------------------------------------------------------------
+
+// 客户端证书身份
+type Identity struct {
+	Subject     string    `json:"subject"`
+	CommonName  string    `json:"cn"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	Fingerprint string    `json:"fingerprint"` // sha256
+	DNS         []string  `json:"dns,omitempty"`
+	Emails      []string  `json:"emails,omitempty"`
+	IPs         []string  `json:"ips,omitempty"`
+	URIs        []string  `json:"uris,omitempty"`
+	SPIFFE      string    `json:"spiffe,omitempty"` // spiffe://trust-domain/path
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+func NewIdentity(crt *x509.Certificate) *Identity {
+	sum := sha256.Sum256(crt.Raw)
+	id := &Identity{
+		Subject:     crt.Subject.String(),
+		CommonName:  crt.Subject.CommonName,
+		Issuer:      crt.Issuer.String(),
+		Serial:      crt.SerialNumber.Text(16),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		DNS:         crt.DNSNames,
+		Emails:      crt.EmailAddresses,
+		NotAfter:    crt.NotAfter,
+	}
+	for _, ip := range crt.IPAddresses {
+		id.IPs = append(id.IPs, ip.String())
+	}
+	for _, uri := range crt.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if uri.Scheme == "spiffe" && id.SPIFFE == "" {
+			id.SPIFFE = uri.String()
+		}
+	}
+	return id
+}
+
+// 获取已验证的客户端证书身份， 未验证(request, require 模式)的证书不可信， 返回 nil
+func PeerIdentity(rr *http.Request) *Identity {
+	if rr.TLS == nil || len(rr.TLS.VerifiedChains) == 0 || len(rr.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return NewIdentity(rr.TLS.VerifiedChains[0][0])
+}
+
+// 身份名称， 优先级: SPIFFE, CN
+func (id *Identity) Name() string {
+	if id.SPIFFE != "" {
+		return id.SPIFFE
+	}
+	return id.CommonName
+}
+
+// 身份写入请求头
+func (id *Identity) SetHeaders(rr *http.Request) {
+	set := func(key string, vals ...string) {
+		if val := strings.Join(vals, ","); val != "" {
+			rr.Header.Set(CertPrefix+key, val)
+		}
+	}
+	set("Subject", id.Subject)
+	set("Cn", id.CommonName)
+	set("Serial", id.Serial)
+	set("Fingerprint", id.Fingerprint)
+	set("Dns", id.DNS...)
+	set("Email", id.Emails...)
+	set("Uri", id.URIs...)
+	set("Spiffe", id.SPIFFE)
+}
+
+// 删除客户端伪造的身份信息
+func StripHeaders(rr *http.Request) {
+	for kk := range rr.Header {
+		if strings.HasPrefix(kk, CertPrefix) {
+			rr.Header.Del(kk)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------------
+
+// 客户端证书验证器， 证书链由 tls.Config 验证， 这里只验证身份
+func NewClientVerifier(allow []string) *ClientVerifier {
+	return &ClientVerifier{Allow: allow, Required: true}
+}
+
+type ClientVerifier struct {
+	Allow    []string // 允许的身份， 匹配 SPIFFE, URI, CN, DNS, 支持 path.Match 通配符， 为空允许所有
+	Required bool     // 必须提供客户端证书
+}
+
+// 验证请求， 成功后将身份写入请求头， 没有证书且不是必须时返回 nil
+func (cv *ClientVerifier) Authz(rr *http.Request) (*Identity, error) {
+	StripHeaders(rr)
+	id := PeerIdentity(rr)
+	if id == nil {
+		if cv.Required {
+			return nil, ErrCertMissing
+		}
+		return nil, nil
+	}
+	if !cv.Match(id) {
+		return nil, ErrCertNotAllow
+	}
+	id.SetHeaders(rr)
+	return id, nil
+}
+
+func (cv *ClientVerifier) Match(id *Identity) bool {
+	if len(cv.Allow) == 0 {
+		return true
+	}
+	names := append([]string{id.CommonName}, id.URIs...)
+	names = append(names, id.DNS...)
+	for _, pattern := range cv.Allow {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok && name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 验证失败的响应
+func (cv *ClientVerifier) Reject(rr *http.Request, rw http.ResponseWriter, err error) {
+	status := http.StatusUnauthorized
+	if err == ErrCertNotAllow {
+		status = http.StatusForbidden
+	}
+	z.JSON0(rr, rw, &z.Result{ErrCode: "invalid-certificate", Message: err.Error(), Status: status})
+}
+
+// 路由中间件， 使用 IdentityFrom(ctx.Ctx) 获取身份
+func (cv *ClientVerifier) Auth(handle z.HandleFunc) z.HandleFunc {
+	return func(ctx *z.Ctx) {
+		id, err := cv.Authz(ctx.Request)
+		if err != nil {
+			ctx.Abort()
+			cv.Reject(ctx.Request, ctx.Writer, err)
+			return
+		}
+		if id != nil {
+			ctx.Ctx = WithIdentity(ctx.Ctx, id)
+			ctx.Request = ctx.Request.WithContext(WithIdentity(ctx.Request.Context(), id))
+		}
+		handle(ctx)
+	}
+}
+
+// 过滤器
+func (cv *ClientVerifier) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		id, err := cv.Authz(rr)
+		if err != nil {
+			cv.Reject(rr, rw, err)
+			return
+		}
+		if id != nil {
+			rr = rr.WithContext(WithIdentity(rr.Context(), id))
+		}
+		next.ServeHTTP(rw, rr)
+	})
+}
+
+// -----------------------------------------------------------------------------------
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+func IdentityFrom(ctx context.Context) *Identity {
+	if ctx == nil {
+		return nil
+	}
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
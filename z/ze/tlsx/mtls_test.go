@@ -0,0 +1,92 @@
+package tlsx_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/suisrc/zgg/z/ze/tlsx"
+)
+
+// go test -v z/ze/tlsx/mtls_test.go -run TestMtls
+func TestMtls(t *testing.T) {
+	ca, err := tlsx.CreateCA(nil, "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := tlsx.CreateSA(nil, "sa", []byte(ca.Crt), []byte(ca.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvCrt, err := tlsx.CreateCE(nil, "server", nil, []net.IP{net.ParseIP("127.0.0.1")}, []byte(sa.Crt), []byte(sa.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.com/ns/default/sa/app")
+	cliCrt, err := tlsx.CreateCC(nil, "app", []*url.URL{spiffe}, nil, []byte(sa.Crt), []byte(sa.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCrt, _ := tlsx.CreateCC(nil, "other", nil, nil, []byte(sa.Crt), []byte(sa.Key))
+	// 客户端证书只能用于客户端
+	blk, _ := tls.X509KeyPair([]byte(cliCrt.Crt), []byte(cliCrt.Key))
+	if leaf, _ := x509.ParseCertificate(blk.Certificate[0]); len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatal("expect client auth only")
+	}
+
+	cafile := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(cafile, []byte(ca.Crt+sa.Crt), 0644)
+	srvPair, _ := tls.X509KeyPair([]byte(srvCrt.Crt+sa.Crt), []byte(srvCrt.Key))
+	cfg := &tls.Config{Certificates: []tls.Certificate{srvPair}}
+	if err := tlsx.SetClientAuth(cfg, []string{cafile}, "optional"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tlsx.SetClientAuth(&tls.Config{}, nil, "verify"); err == nil {
+		t.Fatal("expect need ca error")
+	}
+
+	cv := tlsx.NewClientVerifier([]string{"spiffe://example.com/ns/default/sa/*"})
+	srv := httptest.NewUnstartedServer(cv.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		id := tlsx.IdentityFrom(rr.Context())
+		rw.Write([]byte(id.Name() + "|" + rr.Header.Get(tlsx.CertPrefix+"Cn")))
+	})))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(ca.Crt))
+	get := func(crt *tlsx.SignResult) (int, string) {
+		tc := &tls.Config{RootCAs: roots}
+		if crt != nil {
+			pair, _ := tls.X509KeyPair([]byte(crt.Crt+sa.Crt), []byte(crt.Key))
+			tc.Certificates = []tls.Certificate{pair}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set(tlsx.CertPrefix+"Cn", "fake")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bts, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(bts)
+	}
+	if code, body := get(&cliCrt); code != 200 || body != spiffe.String()+"|app" {
+		t.Fatal(code, body)
+	}
+	if code, _ := get(&otherCrt); code != http.StatusForbidden {
+		t.Fatal("expect forbidden", code)
+	}
+	if code, _ := get(nil); code != http.StatusUnauthorized {
+		t.Fatal("expect unauthorized", code)
+	}
+}
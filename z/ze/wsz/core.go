@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"net/http"
 
+	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/zc"
 	"github.com/suisrc/zgg/z/ze/wsg"
 )
@@ -69,6 +70,20 @@ func NewHandler(newHook NewHookFunc, kind int) http.Handler {
 	}
 }
 
+// NewScopedHandler 创建一个新的 Handler 实例， 每个连接使用 kit 创建作用域， 用于连接级别的服务
+func NewScopedHandler(kit z.SvcKit, newHook NewHookFunc, kind int) http.Handler {
+	switch hdl := NewHandler(newHook, kind).(type) {
+	case *Handler0:
+		hdl.SvcKit = kit
+		return hdl
+	case *Handler1:
+		hdl.SvcKit = kit
+		return hdl
+	default:
+		return nil
+	}
+}
+
 // ---------------------------------------------------------------------------------------------------------
 
 // IsWebSocket 判断请求是否为 WebSocket 升级请求
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/ze/wsg"
 )
 
@@ -17,6 +18,7 @@ type Handler1 struct {
 	NewHook  NewHookFunc
 	Clients  sync.Map
 	Upgrader wsg.Upgrader
+	SvcKit   z.SvcKit // 连接作用域的服务， 可为空
 }
 
 // ServeHTTP 处理 HTTP 请求，如果是 WebSocket 升级请求，完成握手并处理 WebSocket 连接
@@ -41,6 +43,11 @@ func (ss *Handler1) ServeHTTP(wr http.ResponseWriter, rr *http.Request) {
 	}
 	// 获取一个索引主键， 用于标记链接
 	accept, _ := GenUUID()
+	// 连接作用域， hook 中使用 z.ScopeFrom(req.Context()) 获取， 连接关闭时释放
+	scope := z.NewScope(ss.SvcKit)
+	scope.Set("request", rr).Set("context", ctx).Set("sender", SendFunc(sender))
+	defer scope.Close()
+	rr = rr.WithContext(z.WithScope(ctx, scope))
 	// 如果定义了 NewHook 回调函数，调用它创建一个新的 hook
 	if ss.NewHook != nil {
 		// 通过 NewHook 创建一个新的 hook，并将它存储在 clients 中，连接关闭时删除它
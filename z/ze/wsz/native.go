@@ -13,12 +13,15 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+
+	"github.com/suisrc/zgg/z"
 )
 
 // Handler WebSocket 服务，支持自定义的 NewHook 回调函数来处理连接和消息
 type Handler0 struct {
 	NewHook NewHookFunc
 	Clients sync.Map
+	SvcKit  z.SvcKit // 连接作用域的服务， 可为空
 }
 
 // ServeHTTP 处理 HTTP 请求，如果是 WebSocket 升级请求，完成握手并处理 WebSocket 连接
@@ -61,6 +64,11 @@ func (ss *Handler0) ServeHTTP(wr http.ResponseWriter, rr *http.Request) {
 	accept := ComputeAccept(key)
 	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
 		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	// 连接作用域， hook 中使用 z.ScopeFrom(req.Context()) 获取， 连接关闭时释放
+	scope := z.NewScope(ss.SvcKit)
+	scope.Set("request", rr).Set("context", ctx).Set("sender", SendFunc(sender))
+	defer scope.Close()
+	rr = rr.WithContext(z.WithScope(ctx, scope))
 	// 如果定义了 NewHook 回调函数，调用它创建一个新的 hook
 	if ss.NewHook != nil {
 		// 通过 NewHook 创建一个新的 hook，并将它存储在 clients 中，连接关闭时删除它
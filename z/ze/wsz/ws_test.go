@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/suisrc/zgg/z"
 	"github.com/suisrc/zgg/z/ze/wsg"
 	"github.com/suisrc/zgg/z/ze/wsz"
 )
@@ -142,3 +144,47 @@ func TestWsHandler3(t *testing.T) {
 		t.Fatalf("unexpected msg: %s", msg)
 	}
 }
+
+type ConnState struct {
+	count  int
+	closed chan struct{}
+}
+
+func (cs *ConnState) Close() error { close(cs.closed); return nil }
+
+// go test -v z/ze/wsz/ws_test.go -run TestWsScoped
+
+func TestWsScoped(t *testing.T) {
+	state := &ConnState{closed: make(chan struct{})}
+	kit := z.NewSvcKit(nil).(z.SvcProvider)
+	kit.ProvideScoped("", func(sender wsz.SendFunc) *ConnState { return state })
+	server := httptest.NewServer(wsz.NewScopedHandler(kit, func(key string, req *http.Request, sender wsz.SendFunc, cancel func()) (string, wsz.Hook, error) {
+		cs, err := z.ScopeGet[*ConnState](z.ScopeFrom(req.Context()))
+		if err != nil {
+			return "", nil, err
+		}
+		cs.count++
+		return key, &MyHook{}, nil
+	}, 0))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: x3JJHMbDL1EzLkh9GBhXDw==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	resp := make([]byte, 1024)
+	if n, _ := conn.Read(resp); !bytes.Contains(resp[:n], []byte("101 Switching Protocols")) {
+		t.Fatalf("handshake failed: %s", resp[:n])
+	}
+	conn.Close()
+	select {
+	case <-state.closed:
+	case <-time.After(time.Second):
+		t.Fatal("scope not closed")
+	}
+	if state.count != 1 {
+		t.Fatal(state.count)
+	}
+}
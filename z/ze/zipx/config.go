@@ -0,0 +1,43 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package zipx
+
+import (
+	"flag"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var (
+	G = struct {
+		Server ServerConfig
+	}{}
+)
+
+// 压缩配置， 合并到 [server] 配置中
+type ServerConfig struct {
+	Compress bool     `json:"compress"` // 启用全局响应压缩
+	ZipLevel int      `json:"ziplevel"` // 压缩级别, 1~9, 无效的级别使用默认级别
+	ZipMinSz int      `json:"zipminsz"` // 最小压缩长度
+	ZipTypes []string `json:"ziptypes"` // 允许压缩的内容类型
+}
+
+func init() {
+	z.Config(&G)
+	flag.BoolVar(&G.Server.Compress, "compress", false, "enable response compression")
+	flag.IntVar(&G.Server.ZipLevel, "ziplevel", 0, "compression level, 1~9")
+	flag.IntVar(&G.Server.ZipMinSz, "zipminsz", 1024, "minimum response size to compress")
+	flag.Var(z.NewStrArr(&G.Server.ZipTypes, []string{}), "ziptypes", "compressible content types")
+
+	z.Register("07-zipx", func(zgg *z.Zgg) z.Closed {
+		if !G.Server.Compress {
+			return nil
+		}
+		zgg.Filters.Add(New(G.Server.ZipLevel, G.Server.ZipMinSz, G.Server.ZipTypes).Filter)
+		z.Logn("[_zipx___]: response compression enabled")
+		return nil
+	})
+}
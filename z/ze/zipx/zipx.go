@@ -0,0 +1,317 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+// 响应压缩(gzip, deflate)， 标准库不支持 brotli, br 请求会降级为 gzip/deflate
+
+package zipx
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/suisrc/zgg/z"
+)
+
+var (
+	// 默认压缩的内容类型， 前缀匹配
+	DefTypes = []string{
+		"text/",
+		"application/json",
+		"application/javascript",
+		"application/x-javascript",
+		"application/xml",
+		"application/wasm",
+		"image/svg+xml",
+	}
+)
+
+// 压缩配置
+type Compress struct {
+	Level   int      // 压缩级别, -2 ~ 9, 0 使用默认级别
+	MinSize int      // 最小压缩长度
+	Types   []string // 允许压缩的内容类型, 前缀匹配, * 表示所有
+	gzPool  sync.Pool
+	flPool  sync.Pool
+}
+
+func New(level, minSize int, types []string) *Compress {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		// 无效的级别创建压缩器失败， 使用默认级别
+		z.Logn("[_zipx___]: invalid compression level", level, "use default")
+		level = gzip.DefaultCompression
+	} else if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if len(types) == 0 {
+		types = DefTypes
+	}
+	cc := &Compress{Level: level, MinSize: minSize, Types: types}
+	cc.gzPool.New = func() any {
+		zw, _ := gzip.NewWriterLevel(io.Discard, cc.Level)
+		return zw
+	}
+	cc.flPool.New = func() any {
+		zw, _ := flate.NewWriter(io.Discard, cc.Level)
+		return zw
+	}
+	return cc
+}
+
+// 协商压缩算法, 返回 gzip, deflate 或者空
+func Negotiate(accept string) string {
+	gzq, flq, anq := -1.0, -1.0, -1.0
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if kk, vv, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(kk) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(vv), 64); err == nil {
+				q = v
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "x-gzip":
+			gzq = q
+		case "deflate":
+			flq = q
+		case "*":
+			anq = q
+		}
+	}
+	if gzq < 0 {
+		gzq = anq
+	}
+	if flq < 0 {
+		flq = anq
+	}
+	if gzq <= 0 && flq <= 0 {
+		return ""
+	}
+	if gzq >= flq {
+		return "gzip"
+	}
+	return "deflate"
+}
+
+// 增加 Vary: Accept-Encoding, 已经存在时忽略
+func AddVary(header http.Header) {
+	for _, vv := range header.Values("Vary") {
+		for name := range strings.SplitSeq(vv, ",") {
+			if name = strings.TrimSpace(name); name == "*" || strings.EqualFold(name, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept-Encoding")
+}
+
+// 判断请求是否可以压缩， 返回压缩算法
+func (cc *Compress) Accept(rr *http.Request) string {
+	if rr.Method == http.MethodHead || rr.Header.Get("Range") != "" || rr.Header.Get("Upgrade") != "" {
+		return ""
+	}
+	return Negotiate(rr.Header.Get("Accept-Encoding"))
+}
+
+// 判断内容类型是否可以压缩
+func (cc *Compress) AllowType(ctype string) bool {
+	if idx := strings.IndexByte(ctype, ';'); idx >= 0 {
+		ctype = ctype[:idx]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	if ctype == "text/event-stream" {
+		return false // 事件流需要实时推送
+	}
+	for _, tt := range cc.Types {
+		if tt == "*" || strings.HasPrefix(ctype, tt) {
+			return true
+		}
+	}
+	return false
+}
+
+// 创建压缩响应， 使用完成后必须调用 Close
+func (cc *Compress) NewWriter(rw http.ResponseWriter, encoding string) *Writer {
+	return &Writer{ResponseWriter: rw, cc: cc, encoding: encoding}
+}
+
+// 全局过滤器， 对所有路由引擎生效
+func (cc *Compress) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		AddVary(rw.Header())
+		enc := cc.Accept(rr)
+		if enc == "" {
+			next.ServeHTTP(rw, rr)
+			return
+		}
+		zw := cc.NewWriter(rw, enc)
+		defer zw.Close()
+		next.ServeHTTP(zw, rr)
+	})
+}
+
+// 路由中间件
+func Wrap(cc *Compress, handle z.HandleFunc) z.HandleFunc {
+	return func(ctx *z.Ctx) {
+		AddVary(ctx.Writer.Header())
+		enc := cc.Accept(ctx.Request)
+		if enc == "" {
+			handle(ctx)
+			return
+		}
+		rw := ctx.Writer
+		zw := cc.NewWriter(rw, enc)
+		ctx.Writer = zw
+		defer func() {
+			zw.Close()
+			if ctx.Writer == zw {
+				ctx.Writer = rw
+			}
+		}()
+		handle(ctx)
+	}
+}
+
+// -----------------------------------------------------------------------------------
+
+var (
+	_ http.Flusher  = (*Writer)(nil)
+	_ http.Hijacker = (*Writer)(nil)
+)
+
+// 压缩响应， 缓存 MinSize 长度的数据后决定是否压缩
+type Writer struct {
+	http.ResponseWriter
+	cc       *Compress
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	zw       io.WriteCloser
+}
+
+func (ww *Writer) Unwrap() http.ResponseWriter {
+	return ww.ResponseWriter
+}
+
+func (ww *Writer) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		ww.ResponseWriter.WriteHeader(code) // 1xx 直接写出
+		return
+	}
+	if ww.decided || ww.status != 0 {
+		return
+	}
+	ww.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		ww.decide(false)
+	}
+}
+
+func (ww *Writer) Write(bts []byte) (int, error) {
+	if ww.decided {
+		if ww.zw != nil {
+			return ww.zw.Write(bts)
+		}
+		return ww.ResponseWriter.Write(bts)
+	}
+	ww.buf = append(ww.buf, bts...)
+	if len(ww.buf) >= max(ww.cc.MinSize, 1) {
+		if err := ww.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(bts), nil
+}
+
+func (ww *Writer) Flush() {
+	if !ww.decided {
+		ww.decide(len(ww.buf) >= ww.cc.MinSize)
+	}
+	if fw, ok := ww.zw.(interface{ Flush() error }); ok {
+		fw.Flush()
+	}
+	http.NewResponseController(ww.ResponseWriter).Flush()
+}
+
+func (ww *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if ww.decided {
+		return nil, nil, errors.New("zipx: response already written")
+	}
+	ww.decided = true
+	return http.NewResponseController(ww.ResponseWriter).Hijack()
+}
+
+// 结束压缩， 写出剩余数据
+func (ww *Writer) Close() error {
+	if !ww.decided {
+		ww.decide(len(ww.buf) >= ww.cc.MinSize)
+	}
+	if ww.zw == nil {
+		return nil
+	}
+	err := ww.zw.Close()
+	switch zw := ww.zw.(type) {
+	case *gzip.Writer:
+		zw.Reset(io.Discard)
+		ww.cc.gzPool.Put(zw)
+	case *flate.Writer:
+		zw.Reset(io.Discard)
+		ww.cc.flPool.Put(zw)
+	}
+	ww.zw = nil
+	return err
+}
+
+// 确定是否压缩， 写出响应头和缓存数据
+func (ww *Writer) decide(enough bool) error {
+	ww.decided = true
+	header := ww.Header()
+	if ww.status == 0 {
+		ww.status = http.StatusOK
+	}
+	ctype := header.Get("Content-Type")
+	if _, has := header["Content-Type"]; !has && len(ww.buf) > 0 {
+		// 压缩后无法嗅探内容类型， 需要提前设置
+		ctype = http.DetectContentType(ww.buf)
+		header.Set("Content-Type", ctype)
+	}
+	if enough && ww.status >= 200 &&
+		ww.status != http.StatusNoContent && ww.status != http.StatusNotModified && ww.status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" && ww.cc.AllowType(ctype) {
+		if size, err := strconv.Atoi(header.Get("Content-Length")); err != nil || size >= ww.cc.MinSize {
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", ww.encoding)
+			if ww.encoding == "gzip" {
+				zw := ww.cc.gzPool.Get().(*gzip.Writer)
+				zw.Reset(ww.ResponseWriter)
+				ww.zw = zw
+			} else {
+				zw := ww.cc.flPool.Get().(*flate.Writer)
+				zw.Reset(ww.ResponseWriter)
+				ww.zw = zw
+			}
+		}
+	}
+	ww.ResponseWriter.WriteHeader(ww.status)
+	if len(ww.buf) == 0 {
+		return nil
+	}
+	var err error
+	if ww.zw != nil {
+		_, err = ww.zw.Write(ww.buf)
+	} else {
+		_, err = ww.ResponseWriter.Write(ww.buf)
+	}
+	ww.buf = nil
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package zipx_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/suisrc/zgg/z/ze/zipx"
+)
+
+// go test -v z/ze/zipx/zipx_test.go -run Test_negotiate
+
+func Test_negotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                          "",
+		"gzip, deflate, br":         "gzip",
+		"br":                        "",
+		"deflate":                   "deflate",
+		"gzip;q=0.5, deflate;q=0.8": "deflate",
+		"gzip;q=0, *":               "deflate",
+		"*;q=0":                     "",
+	} {
+		if got := zipx.Negotiate(accept); got != want {
+			t.Errorf("%q: got %q, want %q", accept, got, want)
+		}
+	}
+}
+
+// go test -v z/ze/zipx/zipx_test.go -run Test_filter
+
+func Test_filter(t *testing.T) {
+	body := strings.Repeat(`{"name":"zgg"},`, 200)
+	cc := zipx.New(0, 1024, nil)
+	handler := cc.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+		if rr.URL.Path == "/small" {
+			rw.Write([]byte("small"))
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Content-Length", "3000")
+		io.WriteString(rw, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/big", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" ||
+		rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("header: %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bts, _ := io.ReadAll(zr); string(bts) != body {
+		t.Fatal("body mismatch")
+	}
+
+	// 小于最小长度
+	req = httptest.NewRequest(http.MethodGet, "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "small" {
+		t.Fatalf("small: %v %s", rec.Header(), rec.Body.String())
+	}
+
+	// Range 请求不压缩
+	req = httptest.NewRequest(http.MethodGet, "/big", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-10")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("range: %v", rec.Header())
+	}
+}
+
+// go test -v z/ze/zipx/zipx_test.go -run Test_level
+
+func Test_level(t *testing.T) {
+	body := strings.Repeat(`{"name":"zgg"},`, 200)
+	for _, level := range []int{-5, 100} {
+		cc := zipx.New(level, 1024, nil)
+		if cc.Level != gzip.DefaultCompression {
+			t.Fatalf("level %d: got %d", level, cc.Level)
+		}
+		handler := cc.Filter(http.HandlerFunc(func(rw http.ResponseWriter, rr *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("Vary", "Origin, accept-encoding")
+			io.WriteString(rw, body)
+		}))
+		for _, enc := range []string{"gzip", "deflate"} {
+			req := httptest.NewRequest(http.MethodGet, "/big", nil)
+			req.Header.Set("Accept-Encoding", enc)
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Vary", "Accept-Encoding")
+			handler.ServeHTTP(rec, req)
+			if rec.Header().Get("Content-Encoding") != enc || len(rec.Header().Values("Vary")) != 1 {
+				t.Fatalf("level %d %s: %v", level, enc, rec.Header())
+			}
+		}
+	}
+}
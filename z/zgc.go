@@ -8,17 +8,23 @@
 package z
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"reflect"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
-	"text/template"
+	"time"
 )
 
 // 定义处理函数
@@ -57,6 +63,8 @@ type Ctx struct {
 	TraceID string
 	// X-Request-Rt
 	ReqType string
+	// Request Scope, 请求作用域的服务， 使用 Scoped 获取
+	_scope *Scope
 	// flag router name
 	_router string
 	// flag action abort
@@ -90,10 +98,7 @@ func (ctx *Ctx) HTML(tpl string, res any, hss int) {
 	if ctx.TraceID != "" {
 		ctx.Writer.Header().Set("X-Request-Id", ctx.TraceID)
 	}
-	if hss > 0 {
-		ctx.Writer.WriteHeader(hss)
-	}
-	HTML0(ctx.SvcKit.Zgg(), ctx.Request, ctx.Writer, res, tpl)
+	HTML1(ctx.SvcKit.Zgg(), ctx.Request, ctx.Writer, res, tpl, hss)
 }
 
 // 已 TEXT 模板格式写出响应
@@ -155,6 +160,10 @@ func NewCtx(svckit SvcKit, request *http.Request, writer http.ResponseWriter, ro
 // 清理访问资源
 func (ctx *Ctx) Clear() {
 	ctx.Cancel()
+	if ctx._scope != nil {
+		ctx._scope.Close() // 释放请求作用域的服务
+		ctx._scope = nil
+	}
 	// 重点是清除指针，防止内存泄漏，
 	// 因此如果是延迟或多线程处理时候，一定要 Clone Ctx, 否则无法在请求结束后使用
 	ctx.Ctx = nil
@@ -179,6 +188,7 @@ func (ctx *Ctx) Clone(hasContext, hasRequest bool) *Ctx {
 	clo.ReqType = ctx.ReqType
 	clo.TraceID = ctx.TraceID
 	clo._router = ctx._router
+	clo._scope = ctx._scope // 共享作用域， 请求结束后会被释放
 	// 拷贝参数
 	if hasRequest {
 		clo.Params = ctx.Params
@@ -189,6 +199,48 @@ func (ctx *Ctx) Clone(hasContext, hasRequest bool) *Ctx {
 	return &clo
 }
 
+// 请求作用域， 第一次使用时创建， 包含 *Ctx, *http.Request, context.Context
+func (ctx *Ctx) Scope() *Scope {
+	if ctx._scope == nil {
+		ctx._scope = NewScope(ctx.SvcKit)
+		ctx._scope.Set("ctx", ctx).Set("request", ctx.Request).Set("context", ctx.Ctx)
+	}
+	return ctx._scope
+}
+
+// 获取请求作用域中的服务， 如: tx, err := z.Scoped[*sql.Tx](ctx)
+func Scoped[T any](ctx *Ctx) (T, error) {
+	return ScopeGet[T](ctx.Scope())
+}
+
+// 会话接口， 由 ze/sess 模块实现， 数据需要可以 JSON 序列化
+type Session interface {
+	ID() string
+	Get(key string) any
+	Set(key string, val any)
+	Delete(key string)
+	Regenerate() error // 重新生成会话 ID, 登录等权限变化后调用， 防止会话固定攻击
+	Destroy() error    // 销毁会话， 同时删除 cookie
+}
+
+type sessionKey struct{}
+
+// 将会话存放到 context 中
+func WithSession(ctx context.Context, ss Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, ss)
+}
+
+// 从 context 中获取会话， 不存在返回 nil
+func SessionFrom(ctx context.Context) Session {
+	ss, _ := ctx.Value(sessionKey{}).(Session)
+	return ss
+}
+
+// 请求会话， 需要启用 ze/sess 模块， 未启用时返回 nil
+func (ctx *Ctx) Session() Session {
+	return SessionFrom(ctx.Request.Context())
+}
+
 // 获取请求 action
 // 1. 优先使用 query.action
 // 2. 其次使用 path[1:] 作为 action, 注意，如果需要补全path， 需要增加 /
@@ -270,21 +322,46 @@ func JSON0(rr *http.Request, rw http.ResponseWriter, rs *Result) {
 
 // 响应 HTML 模板结果: content-type http-status html-data
 func HTML0(zg *Zgg, rr *http.Request, rw http.ResponseWriter, rs any, tp string) {
+	HTML1(zg, rr, rw, rs, tp, 0)
+}
+
+// 响应 HTML 模板结果， 渲染完成后再写出 content-type 和状态码(hss > 0)， 渲染错误时使用 500
+func HTML1(zg *Zgg, rr *http.Request, rw http.ResponseWriter, rs any, tp string, hss int) {
 	// 响应结果
 	if zg == nil {
 		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusInternalServerError)
 		rw.Write([]byte("template render error: server not found"))
+		return
+	}
+	// 先渲染到缓存， 避免渲染错误时输出不完整的页面
+	buf := &bytes.Buffer{}
+	err := zg.TplKit.Render(buf, tp, rs)
+	if err != nil && IsDebug() {
+		// 调试模式， 输出错误页面， 便于修改模版
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write(TplErrorPage(tp, err))
+	} else if err != nil {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write([]byte("template render error: " + err.Error()))
 	} else {
-		err := zg.TplKit.Render(rw, tp, rs)
-		if err != nil {
-			rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			rw.Write([]byte("template render error: " + err.Error()))
-		} else {
-			rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if hss > 0 {
+			rw.WriteHeader(hss)
 		}
+		buf.WriteTo(rw)
 	}
 }
 
+// 模版错误页面
+func TplErrorPage(key string, err error) []byte {
+	return fmt.Appendf(nil, `<!DOCTYPE html><html><head><meta charset="UTF-8" /><title>template error</title></head>`+
+		`<body><h2>template render error: %s</h2><pre style="color:#c00;white-space:pre-wrap">%s</pre></body></html>`,
+		template.HTMLEscapeString(key), template.HTMLEscapeString(err.Error()))
+}
+
 type Result2 struct {
 	Success bool   `json:"success"`
 	Data    any    `json:"data,omitempty"`
@@ -337,10 +414,197 @@ func EncodeHtml3(rr *http.Request, rw http.ResponseWriter, rs *Result) {
 			tmpl = "error.html"
 		}
 	}
-	if rs.Status > 0 {
-		rw.WriteHeader(rs.Status)
+	HTML1(rs.Ctx.SvcKit.Zgg(), rr, rw, rs, tmpl, rs.Status)
+}
+
+// ----------------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+// Server-Sent Events
+
+var (
+	ErrSSEClosed = errors.New("sse stream closed")
+	ErrSSEField  = errors.New("sse id or event contains newline")
+)
+
+// SSE 事件
+type SSEvent struct {
+	ID    string // 事件 ID, 用于 Last-Event-ID 断线续传
+	Event string // 事件类型
+	Data  string // 事件数据, 多行数据会拆分为多个 data 字段
+	Retry int    // 重连时间(毫秒)
+}
+
+// SSE 重放缓冲, 根据 Last-Event-ID 返回之后的事件
+type SSEReplay interface {
+	Since(lastID string) []*SSEvent
+}
+
+// SSE 事件流
+type SSEStream struct {
+	LastID string // 最后发送的事件 ID
+	done   <-chan struct{}
+	stop   chan struct{}
+	writer http.ResponseWriter
+	rctrl  *http.ResponseController
+	mutex  sync.Mutex
+	_close bool
+}
+
+// 开启 SSE 事件流, replay 可为空, heartbeat <= 0 时不发送心跳
+// 请求上下文取消时，Done() 会关闭， 使用完成后需要调用 Close
+func (ctx *Ctx) SSE(replay SSEReplay, heartbeat time.Duration) (*SSEStream, error) {
+	ctx._abort = true
+	rw := ctx.Writer
+	ss := &SSEStream{done: ctx.Request.Context().Done(), stop: make(chan struct{}), writer: rw, rctrl: http.NewResponseController(rw)}
+	ss.rctrl.SetWriteDeadline(time.Time{}) // 长连接， 取消写超时
+	header := rw.Header()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // nginx 禁用缓冲
+	header.Del("Content-Length")
+	if ctx.TraceID != "" {
+		header.Set("X-Request-Id", ctx.TraceID)
+	}
+	rw.WriteHeader(http.StatusOK)
+	if err := ss.rctrl.Flush(); err != nil {
+		return nil, err
+	}
+	// 断线续传
+	if lastID := GetLastEventID(ctx.Request); lastID != "" && replay != nil {
+		for _, ev := range replay.Since(lastID) {
+			if err := ss.Send(ev); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if heartbeat > 0 {
+		go ss.heartbeat(heartbeat)
+	}
+	return ss, nil
+}
+
+// Last-Event-ID， 请求头优先， 其次是 query 参数
+func GetLastEventID(rr *http.Request) string {
+	if id := rr.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return rr.URL.Query().Get("lastEventId")
+}
+
+func (ss *SSEStream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ss.done:
+			return
+		case <-ss.stop:
+			return
+		case <-ticker.C:
+			if ss.write([]byte(": ping\n\n")) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ss *SSEStream) write(bts []byte) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss._close {
+		return ErrSSEClosed
+	}
+	if _, err := ss.writer.Write(bts); err != nil {
+		return err
+	}
+	return ss.rctrl.Flush()
+}
+
+// 发送事件， ID 和 Event 不能包含换行， 防止注入额外的字段或者事件
+func (ss *SSEStream) Send(ev *SSEvent) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return ErrSSEField
+	}
+	buf := make([]byte, 0, len(ev.Data)+64)
+	if ev.ID != "" {
+		buf = append(buf, "id: "...)
+		buf = append(buf, ev.ID...)
+		buf = append(buf, '\n')
+	}
+	if ev.Event != "" {
+		buf = append(buf, "event: "...)
+		buf = append(buf, ev.Event...)
+		buf = append(buf, '\n')
+	}
+	if ev.Retry > 0 {
+		buf = append(buf, "retry: "...)
+		buf = strconv.AppendInt(buf, int64(ev.Retry), 10)
+		buf = append(buf, '\n')
+	}
+	// \r\n, \r, \n 都是换行
+	data := strings.ReplaceAll(strings.ReplaceAll(ev.Data, "\r\n", "\n"), "\r", "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		buf = append(buf, "data: "...)
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	buf = append(buf, '\n')
+	if err := ss.write(buf); err != nil {
+		return err
+	}
+	if ev.ID != "" {
+		ss.LastID = ev.ID
+	}
+	return nil
+}
+
+// 发送数据， 非字符串数据使用 JSON 编码
+func (ss *SSEStream) SendData(event string, data any) error {
+	switch val := data.(type) {
+	case string:
+		return ss.Send(&SSEvent{Event: event, Data: val})
+	case []byte:
+		return ss.Send(&SSEvent{Event: event, Data: string(val)})
+	}
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return ss.Send(&SSEvent{Event: event, Data: string(bts)})
+}
+
+// 循环发送通道中的事件， 直到请求取消或者通道关闭
+func (ss *SSEStream) Loop(events <-chan *SSEvent) error {
+	for {
+		select {
+		case <-ss.done:
+			return nil
+		case <-ss.stop:
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := ss.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 请求结束信号
+func (ss *SSEStream) Done() <-chan struct{} {
+	return ss.done
+}
+
+// 关闭事件流， 停止心跳
+func (ss *SSEStream) Close() {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if !ss._close {
+		ss._close = true
+		close(ss.stop)
 	}
-	HTML0(rs.Ctx.SvcKit.Zgg(), rr, rw, rs, tmpl)
 }
 
 // ----------------------------------------------------------------------------
@@ -443,6 +707,7 @@ type TplKit interface {
 	Render(wr io.Writer, name string, data any) error
 	Load(key string, str string) *Tpl
 	Preload(dir string) error
+	PreloadFS(fsys fs.FS, dir string) error
 }
 
 // 服务工具接口
@@ -454,6 +719,27 @@ type SvcKit interface {
 	Inj(obj any) SvcKit             // 注册服务 injec 使用 `svckit:"xxx"` 初始化服务
 }
 
+// 服务提供者和生命周期接口， 默认的 SvcKit(*Svc) 实现， 使用 kit.(z.SvcProvider) 获取
+type SvcProvider interface {
+	SvcKit
+	Provide(key string, fun any, group ...string) SvcKit // 注册服务提供者 func(deps...) (T, error), 延迟创建
+	ProvideScoped(key string, fun any) SvcKit            // 注册作用域服务提供者, 在请求或连接作用域中创建
+	Group(name string) []any                             // 获取分组中的服务
+	Start() error                                        // 启动服务, 按依赖顺序调用 Starter
+	Stop()                                               // 停止服务, 按启动倒序调用 Stopper
+}
+
+// 服务生命周期， 由 SvcProvider.Start 按依赖顺序启动
+// 使用 OnStart 而不是 Start, 只有显式实现的服务才会被启动， 避免误启动(如 proc 进程)
+type Starter interface {
+	OnStart() error
+}
+
+// 服务生命周期， 由 SvcProvider.Stop 按启动倒序停止
+type Stopper interface {
+	OnStop() error
+}
+
 // 引擎接口, Engine, 不适用 Router 是为了和 其他 Router 名字上区分开。以便于支持多 Router 而不会出现冲突
 type Engine interface {
 	Name() string                                       // router engine name
@@ -14,19 +14,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
 	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
-	"text/template"
 	"time"
 	"unsafe"
 )
@@ -72,6 +74,41 @@ type ServerConfig struct {
 	ApiRoot string `json:"root"`   // root api root
 	TplPath string `json:"tpl"`    // templates folder path
 	ReqXrtd string `json:"xrt"`    // X-Request-Rt default value, 1: zgg, 2: ali, 3: html
+	// 协议和超时配置， 超时单位为秒， 0 表示不限制
+	H2c      bool   `json:"h2c"`                    // 启用 h2c(HTTP/2 cleartext), 用于非 TLS 服务
+	H2Stream int    `json:"h2stream"`               // HTTP/2 最大并发流, 0 使用默认值
+	H2PingTm int    `json:"h2pingtm"`               // HTTP/2 空闲连接 ping 检测间隔, 0 不检测
+	RTimeout int    `json:"rtimeout"`               // 读取请求超时(含 body)
+	WTimeout int    `json:"wtimeout"`               // 写出响应超时, SSE 等长连接需要为 0
+	ITimeout int    `json:"itimeout" default:"120"` // keep-alive 空闲超时, HTTP/2 空闲连接也使用该值
+	HTimeout int    `json:"htimeout" default:"10"`  // 读取请求头超时
+	AltSvc   string `json:"altsvc"`                 // Alt-Svc 响应头， 用于通告 HTTP/3 服务, 如: h3=":443"; ma=86400
+	// 监听地址， 为空使用 addr:port, 格式参考 Listen 函数
+	Listen []string `json:"listen"`
+	// PROXY protocol v1/v2, 用于 L4 代理之后获取真实客户端地址
+	ProxyProto bool     `json:"proxyproto"`
+	ProxyFrom  []string `json:"proxyfrom"` // 可信来源 CIDR, "*" 信任所有来源, 为空不信任任何来源
+	// 可信代理 CIDR, 只解析来自可信代理的 Forwarded, X-Forwarded-* 头部, "*" 信任所有来源
+	Trusted []string `json:"trusted"`
+}
+
+// 使用配置初始化 http.Server 的协议和超时
+func (cfg *ServerConfig) Apply(hsv *http.Server) {
+	hsv.ReadTimeout = time.Duration(cfg.RTimeout) * time.Second
+	hsv.WriteTimeout = time.Duration(cfg.WTimeout) * time.Second
+	hsv.IdleTimeout = time.Duration(cfg.ITimeout) * time.Second
+	hsv.ReadHeaderTimeout = time.Duration(cfg.HTimeout) * time.Second
+	if cfg.H2c && hsv.TLSConfig == nil {
+		hsv.Protocols = new(http.Protocols)
+		hsv.Protocols.SetHTTP1(true)
+		hsv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if cfg.H2Stream > 0 || cfg.H2PingTm > 0 {
+		hsv.HTTP2 = &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.H2Stream,
+			SendPingTimeout:      time.Duration(cfg.H2PingTm) * time.Second,
+		}
+	}
 }
 
 // -----------------------------------------------------------------------------------
@@ -84,18 +121,31 @@ type Zgg struct {
 	Closeds Slice[Closed] // 模块关闭函数列表
 	TLSConf *tls.Config   // Certificates, GetCertificate
 
-	Engine Engine // 路由引擎
-	SvcKit SvcKit // 服务工具
-	TplKit TplKit // 模版工具
-	_abort bool   // 终止标记
+	Engine  Engine        // 路由引擎
+	SvcKit  SvcKit        // 服务工具
+	TplKit  TplKit        // 模版工具
+	Filters Slice[Filter] // 请求过滤器, 在路由引擎之前执行
+	_handle http.Handler  // 过滤器 + 路由引擎
+	_abort  bool          // 终止标记
+
+	Started Slice[func()]            // 服务启动后执行
+	Signals map[os.Signal]SignalFunc // 信号处理, 扩展 WaitFor 的信号
 }
 
+// 信号处理函数, 返回 true 继续关闭服务, 返回 false 忽略该信号继续等待
+type SignalFunc func(sig os.Signal) bool
+
+// 请求过滤器, 用于 cors, 限流, 压缩等对所有路由引擎生效的处理
+type Filter func(next http.Handler) http.Handler
+
 // -----------------------------------------------------------------------------------
 
 // 服务初始化
 func (aa *Zgg) ServeInit() bool {
 	aa.Servers = Slice[Server]{}
 	aa.Closeds = Slice[Closed]{}
+	aa.Filters = Slice[Filter]{}
+	aa.Started = Slice[func()]{}
 	if aa.SvcKit == nil {
 		aa.SvcKit = NewSvcKit(aa)
 	}
@@ -113,6 +163,15 @@ func (aa *Zgg) ServeInit() bool {
 			if err != nil {
 				Logf("[_tplkit_]: Preload error: %v\n", err)
 			}
+			// 调试模式， 监听模版变化， 自动重新加载
+			if tw, ok := aa.TplKit.(interface{ Watch(time.Duration) func() }); ok && IsDebug() {
+				aa.Closeds.Add(tw.Watch(time.Second))
+			}
+		} else if TplFS != nil {
+			err := aa.TplKit.PreloadFS(TplFS, ".")
+			if err != nil {
+				Logf("[_tplkit_]: Preload error: %v\n", err)
+			}
 		}
 	}
 	// -----------------------------------------------
@@ -137,7 +196,20 @@ func (aa *Zgg) ServeInit() bool {
 			return false // 退出
 		}
 	}
+	// 启动服务， 服务在模块之后启动， 先于模块停止
+	if kit, ok := aa.SvcKit.(SvcProvider); ok {
+		if err := kit.Start(); err != nil {
+			Logn("[register]: svckit start error:", err)
+			return false
+		}
+		aa.Closeds.Add(kit.Stop)
+	}
 	slices.Reverse(aa.Closeds) // 倒序, 后进先出
+	// 构建过滤器链， 先注册的过滤器在外层
+	aa._handle = aa.Engine
+	for i := len(aa.Filters) - 1; i >= 0; i-- {
+		aa._handle = aa.Filters[i](aa._handle)
+	}
 	return true
 }
 
@@ -164,10 +236,20 @@ func (aa *Zgg) RunServe() {
 	defer aa.ServeStop()
 	// 启动HTTP服务， 并可优雅的终止
 	for _, srv := range aa.Servers {
-		if srv != nil {
-			Logn("[_server_]: http server booting... linsten:", srv.Name(), srv.Addr())
-			go srv.RunServe()
+		if srv == nil {
+			continue
+		}
+		Logn("[_server_]: http server booting... linsten:", srv.Name(), srv.Addr())
+		if ls, ok := srv.(interface{ Listen() error }); ok {
+			// 提前监听， 确保 Started 执行时服务已经可以接收请求
+			if err := ls.Listen(); err != nil {
+				Exit(fmt.Sprintf("[_server_]: server listen error: %s %s\n", srv.Name(), err))
+			}
 		}
+		go srv.RunServe()
+	}
+	for _, fn := range aa.Started {
+		fn()
 	}
 	// 等待中断信号以优雅地关闭服务器（设置 5 秒的超时时间）
 	aa.WaitFor()
@@ -181,7 +263,14 @@ func (aa *Zgg) WaitFor() {
 	}
 	ssc := make(chan os.Signal, 1)
 	signal.Notify(ssc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	<-ssc
+	for sig := range aa.Signals {
+		signal.Notify(ssc, sig)
+	}
+	for sig := range ssc {
+		if fn, ok := aa.Signals[sig]; !ok || fn(sig) {
+			break
+		}
+	}
 	Logn("[_server_]: services is shutting down...")
 	// 等待中断信号以优雅地关闭服务器（设置 5 秒的超时时间）
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -203,14 +292,43 @@ type Server interface {
 	Shutdown(ctx context.Context) error
 }
 
+// 创建 HTTP 服务, 协议和超时使用 G.Server 配置
+// addr 支持多个监听地址， 使用 ',' 分隔， 格式参考 Listen 函数
+// 其他协议(如 HTTP/3) 的服务可以实现 Server 接口后加入 Zgg.Servers
 func NewServer(name string, handler http.Handler, addr string, conf *tls.Config) Server {
-	return &servez{Server: http.Server{Handler: handler, Addr: addr, TLSConfig: conf}, ErrExit: true, SrvName: name}
+	srv := &servez{Server: http.Server{Handler: handler, Addr: addr, TLSConfig: conf}, ErrExit: true, SrvName: name}
+	for spec := range strings.SplitSeq(addr, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			srv.Listens = append(srv.Listens, spec)
+		}
+	}
+	G.Server.Apply(&srv.Server)
+	if G.Server.ProxyProto {
+		pp, err := NewProxyProtocol(G.Server.ProxyFrom, time.Duration(G.Server.HTimeout)*time.Second)
+		if err != nil {
+			Exit(fmt.Sprintf("[_server_]: proxy protocol config error: %s\n", err))
+		}
+		if len(G.Server.ProxyFrom) == 0 {
+			Logn("[_server_]: proxy protocol enabled but proxyfrom is empty, only unix socket is trusted")
+		}
+		srv.Proxy = pp
+	}
+	return srv
 }
 
 type servez struct {
 	http.Server
 	ErrExit bool
 	SrvName string
+	Listens []string // 监听地址
+	lns     []net.Listener
+	lnsLock sync.Mutex
+	Proxy   *ProxyProtocol // PROXY protocol, 为空不解析
+}
+
+// 提供监听器的服务， 用于平滑升级
+type Listeners interface {
+	Listeners() map[string]net.Listener // spec -> listener
 }
 
 func (srv *servez) Name() string {
@@ -221,22 +339,144 @@ func (srv *servez) Addr() string {
 	return srv.Server.Addr
 }
 
+// 创建监听器, 可以在 RunServe 之前调用， 用于提前发现监听错误
+func (srv *servez) Listen() error {
+	srv.lnsLock.Lock()
+	defer srv.lnsLock.Unlock()
+	if srv.lns != nil {
+		return nil
+	}
+	lns := []net.Listener{}
+	for _, spec := range srv.Listens {
+		ln, err := Listen(spec)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	srv.lns = lns
+	return nil
+}
+
+// 监听器列表， 与 Listens 一一对应， 用于平滑升级时传递给子进程
+func (srv *servez) Listeners() map[string]net.Listener {
+	srv.lnsLock.Lock()
+	defer srv.lnsLock.Unlock()
+	lns := map[string]net.Listener{}
+	for i, ln := range srv.lns {
+		lns[srv.Listens[i]] = ln
+	}
+	return lns
+}
+
 func (srv *servez) RunServe() {
-	if srv.Server.TLSConfig != nil {
-		if err := srv.Server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
-			if srv.ErrExit {
-				Exit(fmt.Sprintf("[_server_]: server exit error: %s\n", err))
+	if err := srv.Listen(); err != nil {
+		srv.onError(err)
+		return
+	}
+	// Serve 会初始化 HTTP/2 并设置 TLSConfig， 需要提前确定是否使用 TLS
+	useTLS := srv.Server.TLSConfig != nil
+	errc := make(chan error, len(srv.lns))
+	for _, ln := range srv.lns {
+		if srv.Proxy != nil {
+			ln = srv.Proxy.Listener(ln)
+		}
+		go func() {
+			if useTLS {
+				errc <- srv.Server.ServeTLS(ln, "", "")
 			} else {
-				Logn(fmt.Sprintf("[_server_]: server error: %s\n", err))
+				errc <- srv.Server.Serve(ln)
 			}
+		}()
+	}
+	for range srv.lns {
+		if err := <-errc; err != nil && err != http.ErrServerClosed {
+			srv.onError(err)
 		}
-	} else if err := srv.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		if srv.ErrExit {
-			Exit(fmt.Sprintf("[_server_]: server exit error: %s\n", err))
-		} else {
-			Logn(fmt.Sprintf("[_server_]: server error: %s\n", err))
+	}
+}
+
+func (srv *servez) onError(err error) {
+	if srv.ErrExit {
+		Exit(fmt.Sprintf("[_server_]: server exit error: %s\n", err))
+	} else {
+		Logn(fmt.Sprintf("[_server_]: server error: %s\n", err))
+	}
+}
+
+// 创建监听器, 支持格式:
+// host:port, tcp://host:port: TCP 监听
+// unix:///run/app.sock: Unix Domain Socket, 启动时会删除已经存在的 socket 文件
+// fd://3, fd://name: 继承的文件描述符, 支持 systemd socket activation(LISTEN_FDS, LISTEN_FDNAMES)
+// 平滑升级时， 优先使用父进程传递的监听器(EnvListenFds)
+func Listen(spec string) (net.Listener, error) {
+	if fd := InheritedFd(spec); fd != "" {
+		ln, err := ListenFd(fd)
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+		return ln, err
+	}
+	switch {
+	case strings.HasPrefix(spec, "unix://"):
+		path := spec[7:]
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path) // 删除残留的 socket 文件
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
 		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(true)
+		return ln, nil
+	case strings.HasPrefix(spec, "fd://"):
+		return ListenFd(spec[5:])
+	case strings.HasPrefix(spec, "tcp://"):
+		return net.Listen("tcp", spec[6:])
 	}
+	return net.Listen("tcp", spec)
+}
+
+// 父进程传递的监听器, 格式: spec=fd;spec=fd
+const EnvListenFds = "ZGG_LISTEN_FDS"
+
+// 获取父进程传递的监听器文件描述符
+func InheritedFd(spec string) string {
+	for kv := range strings.SplitSeq(os.Getenv(EnvListenFds), ";") {
+		if key, fd, ok := strings.Cut(kv, "="); ok && key == spec {
+			return fd
+		}
+	}
+	return ""
+}
+
+// 通过文件描述符创建监听器, name 为数字或者 LISTEN_FDNAMES 中的名称
+func ListenFd(name string) (net.Listener, error) {
+	fd, err := strconv.Atoi(name)
+	if err != nil {
+		fd = -1
+		if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == 0 || pid == os.Getpid() {
+			nfds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+			for i, fdn := range strings.Split(os.Getenv("LISTEN_FDNAMES"), ":") {
+				if fdn == name && i < nfds {
+					fd = 3 + i // SD_LISTEN_FDS_START
+					break
+				}
+			}
+		}
+		if fd < 0 {
+			return nil, fmt.Errorf("listen fd not found: %s", name)
+		}
+	}
+	file := os.NewFile(uintptr(fd), "fd://"+name)
+	if file == nil {
+		return nil, fmt.Errorf("listen fd invalid: %s", name)
+	}
+	defer file.Close() // FileListener 会复制文件描述符
+	return net.FileListener(file)
 }
 
 // -----------------------------------------------------------------------------------
@@ -250,7 +490,14 @@ func (aa *Zgg) ServeHTTP(rw http.ResponseWriter, rr *http.Request) {
 		rw.Header().Set("Xser-Routerz", aa.Engine.Name())
 		rw.Header().Set("Xser-Version", AppName+":"+Version)
 	}
-	aa.Engine.ServeHTTP(rw, rr)
+	if G.Server.AltSvc != "" {
+		rw.Header().Set("Alt-Svc", G.Server.AltSvc)
+	}
+	if aa._handle != nil {
+		aa._handle.ServeHTTP(rw, rr)
+	} else {
+		aa.Engine.ServeHTTP(rw, rr)
+	}
 }
 
 // 增加处理函数
@@ -297,25 +544,40 @@ func (aa *Zgg) AddRouter(key string, handle HandleFunc) {
 // -----------------------------------------------------------------------------------
 // service 管理工具
 
-var _ SvcKit = (*Svc)(nil)
+var _ SvcProvider = (*Svc)(nil)
 
 type Svc struct {
 	server *Zgg
 	svcmap map[string]any
-	typmap map[reflect.Type]any
+	svckey []string             // 注册顺序， 保证按类型注入的结果是确定的
+	provs  map[string]*provider // 服务提供者， 延迟创建
+	groups map[string][]string  // 服务分组
+	depmap map[string][]string  // 服务依赖， 用于生命周期排序
+	starts []any                // 已经启动的服务
+	scopes map[string]*provider // 作用域服务提供者， 每个作用域创建一次
+	scpkey []string             // 作用域服务注册顺序
 	svclck sync.RWMutex
 }
 
+// 服务提供者， 构造函数 func(deps...) T 或者 func(deps...) (T, error), deps 按类型解析
+type provider struct {
+	key string
+	typ reflect.Type
+	fun reflect.Value
+	lck sync.Mutex
+}
+
 func NewSvcKit(server *Zgg) SvcKit {
 	svckit := &Svc{
 		server: server,
 		svcmap: make(map[string]any),
-		typmap: make(map[reflect.Type]any),
+		provs:  make(map[string]*provider),
+		groups: make(map[string][]string),
+		depmap: make(map[string][]string),
+		scopes: make(map[string]*provider),
 	}
-	svckit.svcmap["svckit"] = svckit
-	svckit.svcmap["server"] = server
-	svckit.typmap[reflect.TypeFor[*Svc]()] = svckit
-	svckit.typmap[reflect.TypeFor[*Zgg]()] = server
+	svckit.Set("svckit", svckit)
+	svckit.Set("server", server)
 	return svckit
 }
 
@@ -323,10 +585,20 @@ func (aa *Svc) Zgg() *Zgg {
 	return aa.server
 }
 
+// 获取服务， 服务由提供者创建时， 第一次获取时创建
 func (aa *Svc) Get(key string) any {
 	aa.svclck.RLock()
-	defer aa.svclck.RUnlock()
-	return aa.svcmap[key]
+	val, ok := aa.svcmap[key]
+	pro := aa.provs[key]
+	aa.svclck.RUnlock()
+	if ok || pro == nil {
+		return val
+	}
+	val, err := aa.resolve(key, nil)
+	if err != nil {
+		Logn("[_svckit_]: [resolve]", err)
+	}
+	return val
 }
 
 func (aa *Svc) Set(key string, val any) SvcKit {
@@ -334,20 +606,18 @@ func (aa *Svc) Set(key string, val any) SvcKit {
 	defer aa.svclck.Unlock()
 	if val != nil {
 		// create or update
+		if _, ok := aa.svcmap[key]; !ok && aa.provs[key] == nil {
+			aa.svckey = append(aa.svckey, key)
+		}
 		aa.svcmap[key] = val
-		aa.typmap[reflect.TypeOf(val)] = val
 	} else {
 		// delete
-		val := aa.svcmap[key]
-		if val != nil {
-			delete(aa.svcmap, key)
-			// delete value by type
-			for kk, vv := range aa.typmap {
-				if vv == val {
-					delete(aa.typmap, kk)
-					break
-				}
-			}
+		delete(aa.svcmap, key)
+		delete(aa.provs, key)
+		delete(aa.depmap, key)
+		aa.svckey = slices.DeleteFunc(aa.svckey, func(kk string) bool { return kk == key })
+		for name, keys := range aa.groups {
+			aa.groups[name] = slices.DeleteFunc(keys, func(kk string) bool { return kk == key })
 		}
 	}
 	return aa
@@ -361,71 +631,505 @@ func (aa *Svc) Map() map[string]any {
 	return ckv
 }
 
+// 注册服务提供者， key 为空时使用返回值类型名称， 服务在第一次使用时创建
+func (aa *Svc) Provide(key string, fun any, group ...string) SvcKit {
+	pro := newProvider(key, fun)
+	aa.svclck.Lock()
+	defer aa.svclck.Unlock()
+	if _, ok := aa.svcmap[pro.key]; ok || aa.provs[pro.key] != nil {
+		Exit(fmt.Sprintf("[_svckit_]: [provide] %s error, service already exists", pro.key))
+	}
+	aa.svckey = append(aa.svckey, pro.key)
+	aa.provs[pro.key] = pro
+	for _, name := range group {
+		aa.groups[name] = append(aa.groups[name], pro.key)
+	}
+	return aa
+}
+
+// 注册作用域服务提供者， 服务在作用域(请求, 连接)中第一次使用时创建， 作用域结束时释放(io.Closer)
+// 依赖优先从作用域中查找， 其次从全局服务中查找
+func (aa *Svc) ProvideScoped(key string, fun any) SvcKit {
+	pro := newProvider(key, fun)
+	aa.svclck.Lock()
+	defer aa.svclck.Unlock()
+	if aa.scopes[pro.key] != nil {
+		Exit(fmt.Sprintf("[_svckit_]: [provide] %s error, scoped service already exists", pro.key))
+	}
+	aa.scpkey = append(aa.scpkey, pro.key)
+	aa.scopes[pro.key] = pro
+	return aa
+}
+
+func newProvider(key string, fun any) *provider {
+	fv := reflect.ValueOf(fun)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.IsVariadic() || ft.NumOut() < 1 || ft.NumOut() > 2 ||
+		ft.NumOut() == 2 && ft.Out(1) != reflect.TypeFor[error]() {
+		Exit(fmt.Sprintf("[_svckit_]: [provide] %s error, need func(deps...) (T, error)", ft))
+	}
+	typ := ft.Out(0)
+	if key == "" {
+		key = typ.Name()
+		if typ.Kind() == reflect.Pointer {
+			key = typ.Elem().Name()
+		}
+		if key == "" {
+			key = typ.String()
+		}
+	}
+	return &provider{key: key, typ: typ, fun: fv}
+}
+
+// 获取分组中的服务， 按注册顺序
+func (aa *Svc) Group(name string) []any {
+	aa.svclck.RLock()
+	keys := slices.Clone(aa.groups[name])
+	aa.svclck.RUnlock()
+	vals := []any{}
+	for _, key := range keys {
+		if val, err := aa.resolve(key, nil); err != nil {
+			Logn("[_svckit_]: [resolve]", err)
+		} else {
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
+
+// 创建服务， path 为创建路径， 用于检测循环依赖
+func (aa *Svc) resolve(key string, path []string) (any, error) {
+	aa.svclck.RLock()
+	val, ok := aa.svcmap[key]
+	pro := aa.provs[key]
+	aa.svclck.RUnlock()
+	if ok {
+		return val, nil
+	} else if pro == nil {
+		return nil, fmt.Errorf("service not found: %s", key)
+	} else if slices.Contains(path, key) {
+		return nil, fmt.Errorf("service cycle: %s -> %s", strings.Join(path, " -> "), key)
+	}
+	path = append(path, key)
+	pro.lck.Lock()
+	defer pro.lck.Unlock()
+	aa.svclck.RLock()
+	val, ok = aa.svcmap[key]
+	aa.svclck.RUnlock()
+	if ok {
+		return val, nil // 其他协程已经创建
+	}
+	ft := pro.fun.Type()
+	args := make([]reflect.Value, ft.NumIn())
+	deps := make([]string, ft.NumIn())
+	for i := range args {
+		dkey := aa.lookup(ft.In(i))
+		if dkey == "" {
+			return nil, fmt.Errorf("service %s: dependency %s not found", strings.Join(path, " -> "), ft.In(i))
+		}
+		dval, err := aa.resolve(dkey, path)
+		if err != nil {
+			return nil, err
+		}
+		args[i], deps[i] = reflect.ValueOf(dval), dkey
+	}
+	outs := pro.fun.Call(args)
+	if len(outs) == 2 && !outs[1].IsNil() {
+		return nil, fmt.Errorf("service %s: %w", strings.Join(path, " -> "), outs[1].Interface().(error))
+	}
+	val = outs[0].Interface()
+	aa.svclck.Lock()
+	aa.svcmap[key] = val
+	aa.depmap[key] = deps
+	aa.svclck.Unlock()
+	if IsDebug() {
+		Logf("[_svckit_]: [provide] %s <- %s\n", aa.toInjName(key, ""), ft)
+	}
+	return val, nil
+}
+
+// 按类型查找服务， 优先类型相同， 其次实现接口， 多个匹配时使用先注册的服务
+func (aa *Svc) lookup(typ reflect.Type) string {
+	aa.svclck.RLock()
+	defer aa.svclck.RUnlock()
+	found := ""
+	for _, key := range aa.svckey {
+		vtyp := reflect.TypeOf(aa.svcmap[key])
+		if pro := aa.provs[key]; pro != nil {
+			vtyp = pro.typ
+		}
+		if vtyp == typ {
+			return key
+		}
+		if found == "" && typ.Kind() == reflect.Interface && vtyp != nil && vtyp.Implements(typ) {
+			found = key
+		}
+	}
+	return found
+}
+
+// 查找服务的 key, 只比较指针类型的服务
+func (aa *Svc) keyOf(obj any) string {
+	ov := reflect.ValueOf(obj)
+	if ov.Kind() != reflect.Pointer {
+		return ""
+	}
+	aa.svclck.RLock()
+	defer aa.svclck.RUnlock()
+	for _, key := range aa.svckey {
+		if vv := reflect.ValueOf(aa.svcmap[key]); vv.Kind() == reflect.Pointer && vv.Pointer() == ov.Pointer() {
+			return key
+		}
+	}
+	return ""
+}
+
 func (aa *Svc) toInjName(tType, tField string) string {
-	name := fmt.Sprintf("%s.%s", tType, tField)
+	name := tType
+	if tField != "" {
+		name = fmt.Sprintf("%s.%s", tType, tField)
+	}
 	if size := len(name); size < 36 {
 		name += strings.Repeat(" ", 36-size)
 	}
 	return name
 }
 
+// 注入服务， 使用 `svckit:"xxx"` 标记需要注入的属性
+// type/auto: 按类型注入; group:(name): 注入分组， 属性为切片; (name): 按名称注入; -: 不注入
 func (aa *Svc) Inj(obj any) SvcKit {
-	aa.svclck.RLock()
-	defer aa.svclck.RUnlock()
 	// 构建注入映射
 	tType := reflect.TypeOf(obj).Elem()
 	tElem := reflect.ValueOf(obj).Elem()
+	deps := []string{}
 	for i := 0; i < tType.NumField(); i++ {
 		tField := tType.Field(i)
 		tagVal := tField.Tag.Get("svckit")
 		if tagVal == "" || tagVal == "-" {
 			continue // 忽略
 		}
+		var err error
+		var val any
+		key, ktyp := tagVal, "name"
 		if tagVal == "type" || tagVal == "auto" {
 			// 通过 `svckit:'type/auto'` 中的接口匹配注入
-			found := false
-			for vType, value := range aa.typmap {
-				if tField.Type == vType || // 属性是一个接口，判断接口是否可以注入
-					tField.Type.Kind() == reflect.Interface && vType.Implements(tField.Type) {
-					tElem.Field(i).Set(reflect.ValueOf(value))
-					if IsDebug() {
-						Logf("[_svckit_]: [inject] %s <- %s\n", aa.toInjName(tType.String(), tField.Name), vType)
+			key, ktyp = aa.lookup(tField.Type), "type"
+			if key == "" {
+				err = fmt.Errorf("%s.(type) error, service not found", tField.Type)
+			}
+		} else if name, ok := strings.CutPrefix(tagVal, "group:"); ok {
+			// 通过 `svckit:'group:(name)'` 注入分组
+			if tField.Type.Kind() != reflect.Slice {
+				err = fmt.Errorf("%s.(group) error, field must be slice", name)
+			} else {
+				vals := reflect.MakeSlice(tField.Type, 0, 0)
+				for _, vv := range aa.Group(name) {
+					if rv := reflect.ValueOf(vv); rv.Type().AssignableTo(tField.Type.Elem()) {
+						vals = reflect.Append(vals, rv)
 					}
-					found = true
-					break
 				}
-			}
-			if !found {
-				errstr := fmt.Sprintf("[_svckit_]: [inject] %s <- %s.(type) error, service not found", //
-					aa.toInjName(tType.String(), tField.Name), tField.Type)
+				tElem.Field(i).Set(vals)
+				aa.svclck.RLock()
+				deps = append(deps, aa.groups[name]...)
+				aa.svclck.RUnlock()
 				if IsDebug() {
-					Logn(errstr)
-				} else {
-					Exit(errstr) // 生产环境，注入失败，则 panic
+					Logf("[_svckit_]: [inject] %s <- %s.(group) %d\n", aa.toInjName(tType.String(), tField.Name), name, vals.Len())
 				}
+				continue
 			}
-		} else {
+		}
+		if err == nil {
 			// 通过 `svckit:'(name)'` 中的 (name) 注入
-			val := aa.svcmap[tagVal]
-			if val == nil {
-				errstr := fmt.Sprintf("[_svckit_]: [inject] %s <- %s.(name) error, service not found", //
-					aa.toInjName(tType.String(), tField.Name), tagVal)
-				if IsDebug() {
-					Logn(errstr)
-				} else {
-					Exit(errstr) // 生产环境，注入失败，则 panic
-				}
-				continue
+			if val, err = aa.resolve(key, nil); err != nil {
+				err = fmt.Errorf("%s.(%s) error, %w", tagVal, ktyp, err)
+			} else if val == nil || !reflect.TypeOf(val).AssignableTo(tField.Type) {
+				err = fmt.Errorf("%s.(%s) error, service type %T mismatch", tagVal, ktyp, val)
 			}
-			tElem.Field(i).Set(reflect.ValueOf(val))
+		}
+		if err != nil {
+			errstr := fmt.Sprintf("[_svckit_]: [inject] %s <- %s", aa.toInjName(tType.String(), tField.Name), err)
 			if IsDebug() {
-				Logf("[_svckit_]: [inject] %s <- %s\n", aa.toInjName(tType.String(), tField.Name), reflect.TypeOf(val))
+				Logn(errstr)
+			} else {
+				Exit(errstr) // 生产环境，注入失败，则 panic
 			}
+			continue
 		}
+		tElem.Field(i).Set(reflect.ValueOf(val))
+		deps = append(deps, key)
+		if IsDebug() {
+			Logf("[_svckit_]: [inject] %s <- %s\n", aa.toInjName(tType.String(), tField.Name), reflect.TypeOf(val))
+		}
+	}
+	// 记录依赖， 用于生命周期排序
+	if key := aa.keyOf(obj); key != "" && len(deps) > 0 {
+		aa.svclck.Lock()
+		aa.depmap[key] = append(aa.depmap[key], deps...)
+		aa.svclck.Unlock()
 	}
 	return aa
 }
 
+// 启动服务， 创建所有的提供者服务， 按照依赖顺序调用 Starter.OnStart
+func (aa *Svc) Start() error {
+	aa.svclck.RLock()
+	keys := slices.Clone(aa.svckey)
+	aa.svclck.RUnlock()
+	for _, key := range keys {
+		if _, err := aa.resolve(key, nil); err != nil {
+			return err
+		}
+	}
+	// 依赖优先， 深度优先排序
+	order, visit := []string{}, map[string]bool{}
+	var sortFn func(key string)
+	sortFn = func(key string) {
+		if visit[key] {
+			return
+		}
+		visit[key] = true
+		aa.svclck.RLock()
+		deps := aa.depmap[key]
+		aa.svclck.RUnlock()
+		for _, dep := range deps {
+			sortFn(dep)
+		}
+		order = append(order, key)
+	}
+	for _, key := range keys {
+		sortFn(key)
+	}
+	for _, key := range order {
+		val := aa.Get(key)
+		if val == any(aa) || slices.ContainsFunc(aa.starts, func(vv any) bool { return sameSvc(vv, val) }) {
+			continue
+		}
+		_, ok1 := val.(Starter)
+		_, ok2 := val.(Stopper)
+		if !ok1 && !ok2 {
+			continue
+		}
+		if ok1 {
+			if IsDebug() {
+				Logf("[_svckit_]: [start] %s\n", key)
+			}
+			if err := val.(Starter).OnStart(); err != nil {
+				aa.Stop() // 停止已经启动的服务
+				return fmt.Errorf("service %s start error: %w", key, err)
+			}
+		}
+		aa.starts = append(aa.starts, val)
+	}
+	return nil
+}
+
+// 停止服务， 按照启动的倒序调用 Stopper.OnStop
+func (aa *Svc) Stop() {
+	for i := len(aa.starts) - 1; i >= 0; i-- {
+		if val, ok := aa.starts[i].(Stopper); ok {
+			if err := val.OnStop(); err != nil {
+				Logf("[_svckit_]: [stop] %T error: %v\n", val, err)
+			}
+		}
+	}
+	aa.starts = nil
+}
+
+func sameSvc(a, b any) bool {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	if av.Kind() == reflect.Pointer && bv.Kind() == reflect.Pointer {
+		return av.Pointer() == bv.Pointer()
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------
+// service 作用域: 请求、连接级别的服务
+
+type Scope struct {
+	svc    *Svc
+	svcmap map[string]any
+	svckey []string
+	values []any // 提供者创建的服务， 用于释放
+	lck    sync.Mutex
+	closed bool
+}
+
+type scopeKey struct{}
+
+// 创建服务作用域， kit 为空时只能使用 Set 的服务
+func NewScope(kit SvcKit) *Scope {
+	sc := &Scope{svcmap: map[string]any{}}
+	sc.svc, _ = kit.(*Svc)
+	return sc
+}
+
+// 将作用域存放到 context 中， 用于 websocket 等连接级别的作用域
+func WithScope(ctx context.Context, sc *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, sc)
+}
+
+// 从 context 中获取作用域， 不存在返回 nil
+func ScopeFrom(ctx context.Context) *Scope {
+	sc, _ := ctx.Value(scopeKey{}).(*Scope)
+	return sc
+}
+
+// 设置作用域中的服务， 不会被释放
+func (sc *Scope) Set(key string, val any) *Scope {
+	sc.lck.Lock()
+	defer sc.lck.Unlock()
+	if _, ok := sc.svcmap[key]; !ok {
+		sc.svckey = append(sc.svckey, key)
+	}
+	sc.svcmap[key] = val
+	return sc
+}
+
+// 获取作用域中的服务， 不存在时使用作用域服务提供者创建
+func (sc *Scope) Get(key string) (any, error) {
+	sc.lck.Lock()
+	defer sc.lck.Unlock()
+	return sc.resolve(key, nil)
+}
+
+// 按类型获取服务， 优先作用域中的服务， 其次全局服务
+func (sc *Scope) Resolve(typ reflect.Type) (any, error) {
+	sc.lck.Lock()
+	defer sc.lck.Unlock()
+	return sc.resolveType(typ, nil)
+}
+
+func (sc *Scope) resolveType(typ reflect.Type, path []string) (any, error) {
+	if key := sc.lookup(typ); key != "" {
+		return sc.resolve(key, path)
+	}
+	if sc.svc != nil {
+		if key := sc.svc.lookup(typ); key != "" {
+			return sc.svc.resolve(key, nil)
+		}
+	}
+	return nil, fmt.Errorf("scoped service %s not found", typ)
+}
+
+func (sc *Scope) resolve(key string, path []string) (any, error) {
+	if sc.closed {
+		return nil, errors.New("scope already closed")
+	}
+	if val, ok := sc.svcmap[key]; ok {
+		return val, nil
+	}
+	var pro *provider
+	if sc.svc != nil {
+		sc.svc.svclck.RLock()
+		pro = sc.svc.scopes[key]
+		sc.svc.svclck.RUnlock()
+	}
+	if pro == nil {
+		if sc.svc != nil {
+			return sc.svc.resolve(key, nil) // 全局服务
+		}
+		return nil, fmt.Errorf("scoped service not found: %s", key)
+	} else if slices.Contains(path, key) {
+		return nil, fmt.Errorf("scoped service cycle: %s -> %s", strings.Join(path, " -> "), key)
+	}
+	path = append(path, key)
+	ft := pro.fun.Type()
+	args := make([]reflect.Value, ft.NumIn())
+	for i := range args {
+		dval, err := sc.resolveType(ft.In(i), path)
+		if err != nil {
+			return nil, fmt.Errorf("scoped service %s: %w", strings.Join(path, " -> "), err)
+		}
+		args[i] = reflect.ValueOf(dval)
+	}
+	// 调用提供者时释放锁， 提供者中可以再次使用当前作用域
+	sc.lck.Unlock()
+	outs := pro.fun.Call(args)
+	sc.lck.Lock()
+	if len(outs) == 2 && !outs[1].IsNil() {
+		return nil, fmt.Errorf("scoped service %s: %w", strings.Join(path, " -> "), outs[1].Interface().(error))
+	}
+	val := outs[0].Interface()
+	if old, ok := sc.svcmap[key]; ok || sc.closed {
+		// 其他协程已经创建或者作用域已经关闭， 释放当前创建的服务
+		if cls, ok := val.(io.Closer); ok {
+			cls.Close()
+		}
+		if sc.closed {
+			return nil, errors.New("scope already closed")
+		}
+		return old, nil
+	}
+	sc.svcmap[key] = val
+	sc.svckey = append(sc.svckey, key)
+	sc.values = append(sc.values, val)
+	return val, nil
+}
+
+// 按类型查找作用域中的服务， 优先类型相同， 其次实现接口
+func (sc *Scope) lookup(typ reflect.Type) string {
+	keys := sc.svckey
+	if sc.svc != nil {
+		sc.svc.svclck.RLock()
+		keys = append(slices.Clone(keys), sc.svc.scpkey...)
+		defer sc.svc.svclck.RUnlock()
+	}
+	found := ""
+	for _, key := range keys {
+		vtyp := reflect.TypeOf(sc.svcmap[key])
+		if pro := sc.svc.scoped(key); vtyp == nil && pro != nil {
+			vtyp = pro.typ
+		}
+		if vtyp == typ {
+			return key
+		}
+		if found == "" && typ.Kind() == reflect.Interface && vtyp != nil && vtyp.Implements(typ) {
+			found = key
+		}
+	}
+	return found
+}
+
+// 调用方需要持有 svclck
+func (aa *Svc) scoped(key string) *provider {
+	if aa == nil {
+		return nil
+	}
+	return aa.scopes[key]
+}
+
+// 关闭作用域， 按创建的倒序释放服务(io.Closer)
+func (sc *Scope) Close() {
+	sc.lck.Lock()
+	defer sc.lck.Unlock()
+	if sc.closed {
+		return
+	}
+	sc.closed = true
+	for i := len(sc.values) - 1; i >= 0; i-- {
+		if val, ok := sc.values[i].(io.Closer); ok {
+			if err := val.Close(); err != nil {
+				Logf("[_svckit_]: [scope] %T close error: %v\n", val, err)
+			}
+		}
+	}
+	sc.svcmap, sc.svckey, sc.values = nil, nil, nil
+}
+
+// 按类型获取作用域中的服务
+func ScopeGet[T any](sc *Scope) (T, error) {
+	var zero T
+	val, err := sc.Resolve(reflect.TypeFor[T]())
+	if err != nil {
+		return zero, err
+	}
+	if tv, ok := val.(T); ok {
+		return tv, nil
+	}
+	return zero, fmt.Errorf("scoped service %T is not %s", val, reflect.TypeFor[T]())
+}
+
 // -----------------------------------------------------------------------------------
 // -----------------------------------------------------------------------------------
 // -----------------------------------------------------------------------------------
@@ -433,20 +1137,87 @@ func (aa *Svc) Inj(obj any) SvcKit {
 
 var (
 	ErrTplNotFound = errors.New("tpl not found")
+
+	// 嵌入的模版文件， 没有配置 -tpl 时使用， 如: //go:embed tmpl/*
+	TplFS fs.FS
+	// 公共模版目录， 目录中的模版会加载到所有模版中， 可以使用 {{template "sub/xxx.html" .}} 引用
+	TplSub = "sub/"
+	// 布局继承， 模版第一行使用 {{/* extends "layout.html" */}} 声明， 模版中使用 {{define "xxx"}} 覆盖布局中的 {{block "xxx" .}}
+	TplExt = regexp.MustCompile(`^\s*\{\{/\*\s*extends\s+"([^"]+)"\s*\*/\}\}`)
+
+	// CSRF 表单字段名称， 模版中使用 {{csrf .CSRF}} 输出隐藏字段
+	CSRFField = "_csrf"
+
+	// 国际化函数， 模版中使用 {{i18n "key" args...}}, 默认使用 key 格式化参数
+	I18n = func(key string, args ...any) string {
+		if len(args) == 0 {
+			return key
+		}
+		return fmt.Sprintf(key, args...)
+	}
 )
 
 var _ TplKit = (*Tvc)(nil)
 
 type Tvc struct {
-	tpls map[string]*Tpl // 所有模版集合
-	lock sync.RWMutex    // 读写锁
+	tpls map[string]*Tpl   // 所有模版集合
+	subs map[string]string // 公共模版
+	lock sync.RWMutex      // 读写锁
+	fsys fs.FS             // 模版来源， 用于热加载
+	fdir string            // 模版目录
+	keys []string          // 从来源中加载的模版
+	stat map[string]string // 模版文件状态， 用于检测变化
 
 	FuncMap template.FuncMap // 支持链式调用
 }
 
 func NewTplKit(server *Zgg) TplKit {
 	return &Tvc{
-		tpls: make(map[string]*Tpl),
+		tpls:    make(map[string]*Tpl),
+		subs:    make(map[string]string),
+		FuncMap: TplFuncs(),
+	}
+}
+
+// 内置模版函数, json, date, default, i18n, csrf; urlquery, html, js 等为模版自带函数
+func TplFuncs() template.FuncMap {
+	return template.FuncMap{
+		"json": func(val any) (template.JS, error) {
+			bts, err := json.Marshal(val)
+			return template.JS(bts), err
+		},
+		"date": func(layout string, val any) string {
+			switch tt := val.(type) {
+			case time.Time:
+				return tt.Format(layout)
+			case *time.Time:
+				if tt != nil {
+					return tt.Format(layout)
+				}
+			case int64:
+				return time.Unix(tt, 0).Format(layout)
+			case int:
+				return time.Unix(int64(tt), 0).Format(layout)
+			case string:
+				return tt
+			}
+			return ""
+		},
+		"default": func(def, val any) any {
+			if val == nil {
+				return def
+			}
+			if rv := reflect.ValueOf(val); rv.IsZero() {
+				return def
+			}
+			return val
+		},
+		"i18n": func(key string, args ...any) string {
+			return I18n(key, args...)
+		},
+		"csrf": func(token string) template.HTML {
+			return template.HTML(`<input type="hidden" name="` + CSRFField + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
 	}
 }
 
@@ -472,53 +1243,176 @@ func (aa *Tvc) Load(key string, str string) *Tpl {
 	if tpl, ok := aa.tpls[key]; ok {
 		return tpl
 	}
-	tpl := &Tpl{}
-	tpl.Key = key
-	tpl.Txt = str
-	tpl.Tpl, tpl.Err = template.New(tpl.Key).Parse(tpl.Txt)
-	if tpl.Err == nil {
-		tpl.Tpl.Funcs(aa.FuncMap)
-	}
+	tpl := aa.parse(key, str, nil)
 	aa.tpls[tpl.Key] = tpl
 	return tpl
 }
 
+// 解析模版， 先加载公共模版， 其次布局， 最后模版本身， 以便于覆盖布局中的 block
+// txts 为本次加载的模版内容， 用于查找布局
+func (aa *Tvc) parse(key, str string, txts map[string]string) *Tpl {
+	tpl := &Tpl{Key: key, Txt: str}
+	name, text := key, str
+	if mat := TplExt.FindStringSubmatch(str); mat != nil {
+		name = mat[1] // 执行布局模版
+		if txt, ok := txts[name]; ok {
+			text = txt
+		} else if ext, ok := aa.tpls[name]; ok {
+			text = ext.Txt
+		} else {
+			tpl.Err = fmt.Errorf("tpl %s extends %s: %w", key, name, ErrTplNotFound)
+			return tpl
+		}
+	}
+	root := template.New(name).Funcs(aa.FuncMap)
+	for _, skey := range slices.Sorted(maps.Keys(aa.subs)) {
+		if skey == name || skey == key {
+			continue
+		}
+		if _, tpl.Err = root.New(skey).Parse(aa.subs[skey]); tpl.Err != nil {
+			return tpl
+		}
+	}
+	if _, tpl.Err = root.Parse(text); tpl.Err != nil {
+		return tpl
+	}
+	if name != key {
+		_, tpl.Err = root.New(key).Parse(str)
+	}
+	tpl.Tpl = root
+	return tpl
+}
+
+// 加载文件夹中所有的 *.html 模版， key 为相对于 dir 的路径
 func (aa *Tvc) Preload(dir string) error {
+	return aa.PreloadFS(os.DirFS(dir), ".")
+}
+
+// 加载文件系统中所有的 *.html 模版， 支持 embed.FS, key 为相对于 dir 的路径
+func (aa *Tvc) PreloadFS(fsys fs.FS, dir string) error {
+	stat := statTpls(fsys, dir)
+	txts, err := readTpls(fsys, dir)
+	if err != nil {
+		return err
+	}
 	aa.lock.Lock()
 	defer aa.lock.Unlock()
-	// 读取 dir 文件夹中 所有的 *.html 文件
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	aa.fsys, aa.fdir, aa.stat = fsys, dir, stat
+	aa.load(txts)
+	return nil
+}
+
+// 重新加载模版， 删除来源中已经不存在的模版， 加载过程持有写锁， 渲染时不会读取到部分更新的模版
+func (aa *Tvc) Reload() error {
+	aa.lock.Lock()
+	defer aa.lock.Unlock()
+	if aa.fsys == nil {
+		return nil
+	}
+	stat := statTpls(aa.fsys, aa.fdir)
+	txts, err := readTpls(aa.fsys, aa.fdir)
+	if err != nil {
+		return err
+	}
+	for _, key := range aa.keys {
+		delete(aa.tpls, key)
+		delete(aa.subs, key)
+	}
+	aa.stat = stat
+	aa.load(txts)
+	return nil
+}
+
+// 监听模版变化(轮询文件修改时间)， 发生变化时重新加载， 返回停止函数
+func (aa *Tvc) Watch(interval time.Duration) func() {
+	aa.lock.RLock()
+	fsys, fdir := aa.fsys, aa.fdir
+	aa.lock.RUnlock()
+	if fsys == nil {
+		return func() {}
+	}
+	stop := make(chan Sem)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			aa.lock.RLock()
+			last := aa.stat
+			aa.lock.RUnlock()
+			if curr := statTpls(fsys, fdir); !maps.Equal(last, curr) {
+				if err := aa.Reload(); err != nil {
+					Logf("[_tplkit_]: reload error: %v\n", err)
+				} else {
+					Logn("[_tplkit_]: templates reloaded")
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }
+}
+
+func (aa *Tvc) load(txts map[string]string) {
+	aa.keys = slices.Sorted(maps.Keys(txts))
+	for key, txt := range txts {
+		if strings.HasPrefix(key, TplSub) {
+			aa.subs[key] = txt
+		}
+	}
+	for _, key := range aa.keys {
+		tpl := aa.parse(key, txts[key], txts)
+		aa.tpls[tpl.Key] = tpl
+		if tpl.Err != nil {
+			Logf("[_preload]: [tplkit] %s error: %v", tpl.Key, tpl.Err)
+		} else if IsDebug() {
+			Logf("[_preload]: [tplkit] %s", tpl.Key)
+		}
+	}
+}
+
+// 读取文件系统中所有的 *.html 模版， key 为相对于 dir 的路径
+func readTpls(fsys fs.FS, dir string) (map[string]string, error) {
+	txts := map[string]string{}
+	err := fs.WalkDir(fsys, dir, func(path string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), ".html") {
 			return nil
 		}
-		if !strings.HasSuffix(info.Name(), ".html") {
-			return nil
-		}
-		key := path
-		if idx := strings.IndexRune(path, '/'); idx >= 0 {
-			key = path[idx+1:]
-		}
 		// 读取文件内容
-		txt, err := os.ReadFile(path)
+		txt, err := fs.ReadFile(fsys, path)
 		if err != nil {
 			return err
 		}
-		tpl := &Tpl{}
-		tpl.Key = key
-		tpl.Txt = string(txt)
-		tpl.Tpl, tpl.Err = template.New(tpl.Key).Parse(tpl.Txt)
-		if tpl.Err == nil {
-			tpl.Tpl.Funcs(aa.FuncMap)
+		key := path
+		if dir != "." {
+			key = strings.TrimPrefix(strings.TrimPrefix(path, dir), "/")
 		}
-		aa.tpls[tpl.Key] = tpl
-		if IsDebug() {
-			Logf("[_preload]: [tplkit] %s", tpl.Key)
+		txts[key] = string(txt)
+		return nil
+	})
+	return txts, err
+}
+
+// 模版文件状态， path -> 修改时间:大小
+func statTpls(fsys fs.FS, dir string) map[string]string {
+	stat := map[string]string{}
+	fs.WalkDir(fsys, dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() || !strings.HasSuffix(de.Name(), ".html") {
+			return nil
+		}
+		if info, err := de.Info(); err == nil {
+			stat[path] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
 		}
 		return nil
 	})
+	return stat
 }
 
 // -----------------------------------------------------------------------------------
@@ -636,6 +1530,9 @@ func (aa *MuxRouter) ServeHTTP(rw http.ResponseWriter, rr *http.Request) {
 // -----------------------------------------------------------------------------------
 
 func RegisterHttpServe(zgg *Zgg) Closed {
+	if err := SetTrustedProxies(G.Server.Trusted); err != nil {
+		Exit(fmt.Sprintf("[_server_]: trusted proxy config error: %s\n", err))
+	}
 	if !HttpServeDef {
 		return nil // 不启动默认服务
 	}
@@ -646,7 +1543,10 @@ func RegisterHttpServe(zgg *Zgg) Closed {
 		addr := fmt.Sprintf("%s:%d", G.Server.Addr, G.Server.Ptls)
 		zgg.Servers.Add(NewServer("(HTTPS)", zgg, addr, zgg.TLSConf))
 	}
-	if G.Server.Port > 0 && (zgg.TLSConf == nil || G.Server.Dual) {
+	if len(G.Server.Listen) > 0 && (zgg.TLSConf == nil || G.Server.Dual) {
+		addr := strings.Join(G.Server.Listen, ",")
+		zgg.Servers.Add(NewServer("(HTTP1)", zgg, addr, nil))
+	} else if G.Server.Port > 0 && (zgg.TLSConf == nil || G.Server.Dual) {
 		addr := fmt.Sprintf("%s:%d", G.Server.Addr, G.Server.Port)
 		zgg.Servers.Add(NewServer("(HTTP1)", zgg, addr, nil))
 	}
@@ -709,23 +1609,130 @@ func GetReqType(request *http.Request) string {
 	return reqtype
 }
 
+// 默认可信代理: 只有回环地址， 入口代理(ingress)的网段需要通过 trusted 配置
+var TrustedProxyDef = []string{"127.0.0.0/8", "::1"}
+
+var (
+	trustedAll  = false
+	trustedNets = MustParseCIDRs(TrustedProxyDef)
+)
+
+func MustParseCIDRs(strs []string) []*net.IPNet {
+	nets, err := ParseCIDRs(strs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// 设置可信代理 CIDR, "*" 信任所有来源, 为空不信任任何来源
+// 只有来自可信代理的请求， 才会解析 Forwarded, X-Forwarded-For, X-Real-Ip 等头部
+func SetTrustedProxies(cidrs []string) error {
+	all, strs := false, []string{}
+	for _, str := range cidrs {
+		if str == "*" {
+			all = true
+		} else {
+			strs = append(strs, str)
+		}
+	}
+	nets, err := ParseCIDRs(strs)
+	if err != nil {
+		return err
+	}
+	trustedAll, trustedNets = all, nets
+	return nil
+}
+
+// 是否为可信代理， addr 为 ip 或者 ip:port, 非 IP 地址(unix socket)视为可信
+func IsTrustedProxy(addr string) bool {
+	if trustedAll {
+		return true
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if addr == "" || addr == "@" {
+		return true // unix socket
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ContainsIP(trustedNets, ip)
+}
+
+// 获取转发链路， 优先使用 Forwarded(RFC 7239) 的 for 参数， 其次使用 X-Forwarded-For
+// 返回的地址从左到右依次为 客户端, 代理1, 代理2...
+func GetForwardedFor(req *http.Request) []string {
+	ips := []string{}
+	for _, line := range req.Header.Values("Forwarded") {
+		for elem := range strings.SplitSeq(line, ",") {
+			for pair := range strings.SplitSeq(elem, ";") {
+				key, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					ips = append(ips, parseForwardedNode(val))
+				}
+			}
+		}
+	}
+	if len(ips) > 0 {
+		return ips
+	}
+	for _, line := range req.Header.Values("X-Forwarded-For") {
+		for ip := range strings.SplitSeq(line, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// "[2001:db8::1]:4711" -> 2001:db8::1, 192.0.2.43:47011 -> 192.0.2.43, unknown, _hidden 原样返回
+func parseForwardedNode(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			return node[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// 获取客户端 IP, 只有来自可信代理的请求才会解析转发头部
+// 转发链路从右向左查找第一个不可信的地址， 全部可信时返回最左侧的地址
 func GetRemoteIP(req *http.Request) string {
-	if ip := req.Header.Get("X-Forwarded-For"); ip != "" {
-		ip = strings.TrimSpace(strings.Split(ip, ",")[0])
-		if ip == "" {
-			ip = strings.TrimSpace(req.Header.Get("X-Real-Ip"))
+	peer := strings.TrimSpace(req.RemoteAddr)
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !IsTrustedProxy(peer) {
+		return peer
+	}
+	ips := GetForwardedFor(req)
+	if len(ips) == 0 {
+		if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); ip != "" {
+			return ip
 		}
-		if ip != "" {
+		if ip := req.Header.Get("X-Appengine-Remote-Addr"); ip != "" {
 			return ip
 		}
+		return peer
 	}
-	if ip := req.Header.Get("X-Appengine-Remote-Addr"); ip != "" {
-		return ip
-	}
-	if ip, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr)); err == nil {
-		return ip
+	for i := len(ips) - 1; i >= 0; i-- {
+		if net.ParseIP(ips[i]) == nil {
+			// 无法识别的地址(unknown, _hidden), 停止查找
+			if i < len(ips)-1 {
+				return ips[i+1]
+			}
+			return peer
+		}
+		if i == 0 || !IsTrustedProxy(ips[i]) {
+			return ips[i]
+		}
 	}
-	return ""
+	return peer
 }
 
 // request token auth
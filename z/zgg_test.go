@@ -0,0 +1,327 @@
+package z_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// go test -v z/zgg_test.go -run TestGetRemoteIP
+func TestGetRemoteIP(t *testing.T) {
+	defer z.SetTrustedProxies(z.TrustedProxyDef)
+	z.SetTrustedProxies([]string{"10.0.0.0/8"})
+
+	cases := []struct {
+		remote string
+		header map[string]string
+		expect string
+	}{
+		// 不可信来源， 忽略转发头部
+		{"1.2.3.4:80", map[string]string{"X-Forwarded-For": "9.9.9.9"}, "1.2.3.4"},
+		// 可信代理， 从右向左查找第一个不可信的地址
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 10.0.0.2"}, "5.6.7.8"},
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "5.6.7.8, 10.0.0.3, 10.0.0.2"}, "5.6.7.8"},
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.0.0.3"}, "10.0.0.3"},
+		{"10.0.0.1:80", map[string]string{"X-Real-Ip": "5.6.7.8"}, "5.6.7.8"},
+		{"10.0.0.1:80", nil, "10.0.0.1"},
+		// Forwarded 优先
+		{"10.0.0.1:80", map[string]string{
+			"Forwarded":       `for=9.9.9.9, for="[2001:db8::1]:4711";proto=https, for=10.0.0.2:8080`,
+			"X-Forwarded-For": "5.6.7.8",
+		}, "2001:db8::1"},
+		{"10.0.0.1:80", map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"}, "10.0.0.2"},
+	}
+	for i, cc := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = cc.remote
+		for k, v := range cc.header {
+			req.Header.Set(k, v)
+		}
+		if ip := z.GetRemoteIP(req); ip != cc.expect {
+			t.Errorf("case %d: %s != %s", i, ip, cc.expect)
+		}
+	}
+
+	z.SetTrustedProxies([]string{"*"})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "9.9.9.9, 5.6.7.8")
+	if ip := z.GetRemoteIP(req); ip != "9.9.9.9" {
+		t.Error(ip)
+	}
+}
+
+type testRepo struct{ log *[]string }
+
+func (r *testRepo) OnStart() error { *r.log = append(*r.log, "start repo"); return nil }
+func (r *testRepo) OnStop() error  { *r.log = append(*r.log, "stop repo"); return nil }
+
+type testSvc struct {
+	Repo *testRepo `svckit:"type"`
+	log  *[]string
+}
+
+func (s *testSvc) OnStart() error { *s.log = append(*s.log, "start svc"); return nil }
+func (s *testSvc) OnStop() error  { *s.log = append(*s.log, "stop svc"); return nil }
+
+// 只有 Start 方法的服务不会被自动启动
+type testProc struct{ log *[]string }
+
+func (p *testProc) Start() error { *p.log = append(*p.log, "start proc"); return nil }
+
+type testNamed interface{ Name() string }
+type testName string
+
+func (n testName) Name() string { return string(n) }
+
+// go test -v z/zgg_test.go -run TestSvcKitProvide
+func TestSvcKitProvide(t *testing.T) {
+	log := []string{}
+	kit := z.NewSvcKit(nil).(z.SvcProvider)
+	// 先注册服务， 后注册依赖， 依赖在启动时优先
+	svc := z.RegSvc(kit, &testSvc{log: &log})
+	z.RegSvc(kit, &testProc{log: &log})
+	kit.Provide("", func() *testRepo { return &testRepo{log: &log} })
+	kit.Inj(svc)
+	if svc.Repo == nil {
+		t.Fatal("repo not injected")
+	}
+	kit.Provide("name-b", func() testNamed { return testName("b") }, "names")
+	kit.Provide("name-a", func(repo *testRepo) (testNamed, error) { return testName("a"), nil }, "names")
+	if names := kit.Group("names"); len(names) != 2 || names[0].(testNamed).Name() != "b" {
+		t.Fatal(names)
+	}
+	// 按类型注入， 多个匹配时使用先注册的服务
+	obj := &struct {
+		Named testNamed   `svckit:"type"`
+		Names []testNamed `svckit:"group:names"`
+	}{}
+	kit.Inj(obj)
+	if obj.Named.Name() != "b" || len(obj.Names) != 2 {
+		t.Fatal(obj)
+	}
+
+	if err := kit.Start(); err != nil {
+		t.Fatal(err)
+	}
+	kit.Stop()
+	if strings.Join(log, ",") != "start repo,start svc,stop svc,stop repo" {
+		t.Fatal(log)
+	}
+
+	// 并发创建， 只创建一次
+	type C struct{}
+	calls := atomic.Int32{}
+	kit.Provide("", func() *C { calls.Add(1); return &C{} })
+	wg := sync.WaitGroup{}
+	for range 8 {
+		wg.Go(func() { kit.Get("C") })
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatal("provider calls", calls.Load())
+	}
+}
+
+// go test -v z/zgg_test.go -run TestSvcKitCycle
+func TestSvcKitCycle(t *testing.T) {
+	type A struct{}
+	type B struct{}
+	kit := z.NewSvcKit(nil).(z.SvcProvider)
+	kit.Provide("", func(*B) *A { return &A{} })
+	kit.Provide("", func(*A) *B { return &B{} })
+	err := kit.Start()
+	if err == nil || !strings.Contains(err.Error(), "A -> B -> A") {
+		t.Fatal(err)
+	}
+
+	kit = z.NewSvcKit(nil).(z.SvcProvider)
+	kit.Provide("", func() (*A, error) { return nil, errors.New("boom") })
+	if err := kit.Start(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatal(err)
+	}
+	if kit.Get("A") != nil {
+		t.Fatal("expect nil")
+	}
+}
+
+type testTx struct {
+	req    *http.Request
+	repo   *testRepo
+	closed bool
+}
+
+func (tx *testTx) Close() error { tx.closed = true; return nil }
+
+// go test -v z/zgg_test.go -run TestSvcKitScoped
+func TestSvcKitScoped(t *testing.T) {
+	log := []string{}
+	kit := z.NewSvcKit(nil).(z.SvcProvider)
+	kit.Provide("", func() *testRepo { return &testRepo{log: &log} })
+	kit.ProvideScoped("", func(req *http.Request, repo *testRepo) *testTx { return &testTx{req: req, repo: repo} })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx := z.NewCtx(kit, req, httptest.NewRecorder(), "")
+	tx1, err := z.Scoped[*testTx](ctx)
+	if err != nil || tx1.req != req || tx1.repo == nil {
+		t.Fatal(tx1, err)
+	}
+	if tx2, _ := z.Scoped[*testTx](ctx); tx2 != tx1 {
+		t.Fatal("expect same instance in scope")
+	}
+	// 全局服务
+	if repo, err := z.Scoped[*testRepo](ctx); err != nil || repo != tx1.repo {
+		t.Fatal(repo, err)
+	}
+	ctx.Clear()
+	if !tx1.closed {
+		t.Fatal("expect closed")
+	}
+
+	ctx = z.NewCtx(kit, req, httptest.NewRecorder(), "")
+	defer ctx.Clear()
+	if tx3, _ := z.Scoped[*testTx](ctx); tx3 == tx1 {
+		t.Fatal("expect new instance in new scope")
+	}
+	if _, err := z.Scoped[*testName](ctx); err == nil {
+		t.Fatal("expect error")
+	}
+
+	// 提供者中使用同一个作用域， 不会死锁
+	type testUow struct{ tx *testTx }
+	kit.ProvideScoped("", func(ctx *z.Ctx) (*testUow, error) {
+		tx, err := z.Scoped[*testTx](ctx)
+		return &testUow{tx: tx}, err
+	})
+	if uow, err := z.Scoped[*testUow](ctx); err != nil || uow.tx == nil {
+		t.Fatal(uow, err)
+	}
+}
+
+// go test -v z/zgg_test.go -run TestTplKit
+func TestTplKit(t *testing.T) {
+	fsys := fstest.MapFS{
+		"tmpl/layout.html":     {Data: []byte(`<html><title>{{block "title" .}}zgg{{end}}</title>{{template "sub/head.html" .}}{{block "body" .}}{{end}}</html>`)},
+		"tmpl/sub/head.html":   {Data: []byte(`<h1>{{i18n "hello %s" .Name}}</h1>`)},
+		"tmpl/page/index.html": {Data: []byte(`{{/* extends "layout.html" */}}{{define "body"}}<p>{{.Text}}</p><script>var d = {{json .}};</script>{{default "-" .Nick}}{{end}}`)},
+		"tmpl/date.html":       {Data: []byte(`{{date "2006-01-02" .}}`)},
+	}
+	kit := z.NewTplKit(nil)
+	if err := kit.PreloadFS(fsys, "tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	buf := &strings.Builder{}
+	data := map[string]any{"Name": "zgg", "Text": "<b>x</b>", "Nick": ""}
+	if err := kit.Render(buf, "page/index.html", data); err != nil {
+		t.Fatal(err)
+	}
+	expect := `<html><title>zgg</title><h1>hello zgg</h1><p>&lt;b&gt;x&lt;/b&gt;</p>` +
+		`<script>var d = {"Name":"zgg","Nick":"","Text":"\u003cb\u003ex\u003c/b\u003e"};</script>-</html>`
+	if buf.String() != expect {
+		t.Fatal(buf.String())
+	}
+	buf.Reset()
+	if err := kit.Render(buf, "date.html", time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)); err != nil || buf.String() != "2026-01-02" {
+		t.Fatal(buf.String(), err)
+	}
+
+	// 布局不存在
+	if tpl := kit.Load("bad.html", `{{/* extends "none.html" */}}`); tpl.Err == nil {
+		t.Fatal("expect error")
+	}
+	// 目录中的模版， key 为相对路径
+	kit = z.NewTplKit(nil)
+	if err := kit.Preload("../doc/tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"error.html", "success.html", "redirect.html", "sub/error.html"} {
+		if tpl := kit.Get(key); tpl == nil || tpl.Err != nil {
+			t.Fatal(key, tpl)
+		}
+	}
+
+	// 渲染后再写出状态码， 渲染错误使用 text/plain
+	zg := &z.Zgg{TplKit: kit}
+	rec := httptest.NewRecorder()
+	z.HTML1(zg, nil, rec, map[string]any{}, "success.html", http.StatusCreated)
+	if rec.Code != http.StatusCreated || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	z.HTML1(zg, nil, rec, nil, "none.html", http.StatusNotFound)
+	if rec.Code != http.StatusInternalServerError || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatal(rec.Code, rec.Header(), rec.Body.String())
+	}
+}
+
+// go test -v z/zgg_test.go -run TestTplKitWatch
+func TestTplKitWatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.html"), []byte(`v1 {{.}}`), 0644)
+	kit := z.NewTplKit(nil)
+	if err := kit.Preload(dir); err != nil {
+		t.Fatal(err)
+	}
+	stop := kit.(*z.Tvc).Watch(10 * time.Millisecond)
+	defer stop()
+	render := func(key string) (string, error) {
+		buf := &strings.Builder{}
+		err := kit.Render(buf, key, "x")
+		return buf.String(), err
+	}
+	waitFor := func(fn func() bool) {
+		for range 100 {
+			if fn() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		str, err := render("a.html")
+		t.Fatal("timeout:", str, err)
+	}
+	if str, _ := render("a.html"); str != "v1 x" {
+		t.Fatal(str)
+	}
+	os.WriteFile(filepath.Join(dir, "a.html"), []byte(`v2 {{.}}!`), 0644)
+	waitFor(func() bool { str, _ := render("a.html"); return str == "v2 x!" })
+	// 错误的模版， 不使用旧的模版
+	os.WriteFile(filepath.Join(dir, "a.html"), []byte(`v3 {{.`), 0644)
+	waitFor(func() bool { _, err := render("a.html"); return err != nil })
+	// 删除模版
+	os.Remove(filepath.Join(dir, "a.html"))
+	waitFor(func() bool { _, err := render("a.html"); return err == z.ErrTplNotFound })
+}
+
+// go test -v z/zgg_test.go -run TestSSE
+func TestSSE(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ctx := z.NewCtx(nil, httptest.NewRequest("GET", "/", nil), rec, "")
+	defer ctx.Clear()
+	ss, err := ctx.SSE(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if err := ss.Send(&z.SSEvent{ID: "1", Event: "msg", Data: "a\r\nb\rc"}); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); body != "id: 1\nevent: msg\ndata: a\ndata: b\ndata: c\n\n" {
+		t.Fatalf("%q", body)
+	}
+	// 换行注入
+	if err := ss.Send(&z.SSEvent{Event: "msg\ndata: x"}); err != z.ErrSSEField {
+		t.Fatal(err)
+	}
+	if err := ss.Send(&z.SSEvent{ID: "1\r\nevent: x"}); err != z.ErrSSEField {
+		t.Fatal(err)
+	}
+}
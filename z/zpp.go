@@ -0,0 +1,341 @@
+// Copyright 2026 suisrc. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// at https://github.com/suisrc/zgg/blob/main/LICENSE.
+
+package z
+
+// PROXY protocol v1/v2, 用于获取 L4 代理(haproxy, nlb, kwcat2)之后的真实客户端地址
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProxyHeader = errors.New("proxy protocol: invalid header")
+
+	// v2 签名
+	ProxySigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// 解析 CIDR 列表， 支持单个 IP 地址
+func ParseCIDRs(strs []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, str := range strs {
+		if str = strings.TrimSpace(str); str == "" {
+			continue
+		}
+		if !strings.ContainsRune(str, '/') {
+			if ip := net.ParseIP(str); ip == nil {
+				return nil, fmt.Errorf("invalid ip: %s", str)
+			} else if ip.To4() != nil {
+				str += "/32"
+			} else {
+				str += "/128"
+			}
+		}
+		_, ipn, err := net.ParseCIDR(str)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipn)
+	}
+	return nets, nil
+}
+
+// 判断 IP 是否在 CIDR 列表中
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipn := range nets {
+		if ipn.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------
+
+// PROXY protocol 头部
+type ProxyHeader struct {
+	Version byte     // 1, 2
+	Command byte     // v2: 0 LOCAL, 1 PROXY; v1 固定为 1
+	Network string   // tcp4, tcp6, udp4, udp6, unix, 空为 UNKNOWN/UNSPEC
+	Src     net.Addr // 源地址(客户端)
+	Dst     net.Addr // 目标地址(代理)
+	TLVs    []byte   // v2 扩展数据， 原样保留
+}
+
+// 创建 v2 PROXY 头部
+func NewProxyHeader(src, dst net.Addr) *ProxyHeader {
+	hdr := &ProxyHeader{Version: 2, Command: 1, Src: src, Dst: dst}
+	sa, ok1 := src.(*net.TCPAddr)
+	da, ok2 := dst.(*net.TCPAddr)
+	switch {
+	case ok1 && ok2 && sa.IP.To4() != nil && da.IP.To4() != nil:
+		hdr.Network = "tcp4"
+	case ok1 && ok2:
+		hdr.Network = "tcp6"
+	}
+	return hdr
+}
+
+// 读取 PROXY 头部， 不存在头部时返回 nil, nil
+func ReadProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	bts, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch bts[0] {
+	case 'P':
+		if bts, _ = br.Peek(6); string(bts) == "PROXY " {
+			return readProxyV1(br)
+		}
+	case '\r':
+		if bts, _ = br.Peek(len(ProxySigV2)); bytes.Equal(bts, ProxySigV2) {
+			return readProxyV2(br)
+		}
+	}
+	return nil, nil
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(br *bufio.Reader) (*ProxyHeader, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeader
+	}
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	hdr := &ProxyHeader{Version: 1, Command: 1}
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return hdr, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, ErrProxyHeader
+	}
+	sip, dip := net.ParseIP(parts[2]), net.ParseIP(parts[3])
+	sport, err1 := strconv.ParseUint(parts[4], 10, 16)
+	dport, err2 := strconv.ParseUint(parts[5], 10, 16)
+	if sip == nil || dip == nil || err1 != nil || err2 != nil {
+		return nil, ErrProxyHeader
+	}
+	hdr.Network = strings.ToLower(parts[1])
+	hdr.Src = &net.TCPAddr{IP: sip, Port: int(sport)}
+	hdr.Dst = &net.TCPAddr{IP: dip, Port: int(dport)}
+	return hdr, nil
+}
+
+// sig(12) + ver_cmd(1) + fam(1) + len(2) + addr + tlv
+func readProxyV2(br *bufio.Reader) (*ProxyHeader, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 || head[12]&0x0f > 1 {
+		return nil, ErrProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	hdr := &ProxyHeader{Version: 2, Command: head[12] & 0x0f}
+	alen := 0
+	switch head[13] {
+	case 0x11, 0x12: // TCP4, UDP4
+		if alen = 12; len(body) < alen {
+			return nil, ErrProxyHeader
+		}
+		hdr.Network = map[byte]string{0x11: "tcp4", 0x12: "udp4"}[head[13]]
+		hdr.Src, hdr.Dst = proxyAddrs(hdr.Network, body[0:4], body[4:8], body[8:10], body[10:12])
+	case 0x21, 0x22: // TCP6, UDP6
+		if alen = 36; len(body) < alen {
+			return nil, ErrProxyHeader
+		}
+		hdr.Network = map[byte]string{0x21: "tcp6", 0x22: "udp6"}[head[13]]
+		hdr.Src, hdr.Dst = proxyAddrs(hdr.Network, body[0:16], body[16:32], body[32:34], body[34:36])
+	case 0x31, 0x32: // UNIX STREAM, DGRAM
+		if alen = 216; len(body) < alen {
+			return nil, ErrProxyHeader
+		}
+		hdr.Network = "unix"
+		hdr.Src = &net.UnixAddr{Name: string(bytes.TrimRight(body[:108], "\x00")), Net: "unix"}
+		hdr.Dst = &net.UnixAddr{Name: string(bytes.TrimRight(body[108:216], "\x00")), Net: "unix"}
+	}
+	if len(body) > alen {
+		hdr.TLVs = body[alen:]
+	}
+	return hdr, nil
+}
+
+func proxyAddrs(network string, sip, dip, sport, dport []byte) (net.Addr, net.Addr) {
+	sp, dp := int(binary.BigEndian.Uint16(sport)), int(binary.BigEndian.Uint16(dport))
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: net.IP(sip), Port: sp}, &net.UDPAddr{IP: net.IP(dip), Port: dp}
+	}
+	return &net.TCPAddr{IP: net.IP(sip), Port: sp}, &net.TCPAddr{IP: net.IP(dip), Port: dp}
+}
+
+// 格式化头部， Version 为 1 时输出 v1 文本格式， 否则输出 v2 二进制格式
+func (hdr *ProxyHeader) Format() []byte {
+	sa, _ := hdr.Src.(*net.TCPAddr)
+	da, _ := hdr.Dst.(*net.TCPAddr)
+	if hdr.Version == 1 {
+		if sa == nil || da == nil || (hdr.Network != "tcp4" && hdr.Network != "tcp6") {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", strings.ToUpper(hdr.Network), sa.IP, da.IP, sa.Port, da.Port)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 16+36+len(hdr.TLVs)))
+	buf.Write(ProxySigV2)
+	buf.WriteByte(0x20 | hdr.Command&0x0f)
+	var addr []byte
+	switch {
+	case sa == nil || da == nil:
+		buf.WriteByte(0x00) // UNSPEC
+	case hdr.Network == "tcp4":
+		buf.WriteByte(0x11)
+		addr = append(append(addr, sa.IP.To4()...), da.IP.To4()...)
+	default:
+		buf.WriteByte(0x21)
+		addr = append(append(addr, sa.IP.To16()...), da.IP.To16()...)
+	}
+	if addr != nil {
+		addr = binary.BigEndian.AppendUint16(addr, uint16(sa.Port))
+		addr = binary.BigEndian.AppendUint16(addr, uint16(da.Port))
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(addr)+len(hdr.TLVs)))
+	buf.Write(addr)
+	buf.Write(hdr.TLVs)
+	return buf.Bytes()
+}
+
+// -----------------------------------------------------------------------------------
+
+// PROXY protocol 配置， 只解析可信来源的头部， 头部是可选的
+type ProxyProtocol struct {
+	Trusted    []*net.IPNet  // 可信来源， 为空不信任任何 IP 来源
+	TrustedAll bool          // 信任所有来源, 配置为 "*"
+	Timeout    time.Duration // 读取头部超时， 0 不限制
+}
+
+// trusted 为可信来源 CIDR, "*" 信任所有来源， 为空不信任任何 IP 来源
+func NewProxyProtocol(trusted []string, timeout time.Duration) (*ProxyProtocol, error) {
+	all, strs := false, []string{}
+	for _, str := range trusted {
+		if str == "*" {
+			all = true
+		} else {
+			strs = append(strs, str)
+		}
+	}
+	nets, err := ParseCIDRs(strs)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyProtocol{Trusted: nets, TrustedAll: all, Timeout: timeout}, nil
+}
+
+// 是否为可信来源， 非 IP 地址(unix socket)视为可信
+func (pp *ProxyProtocol) IsTrusted(addr net.Addr) bool {
+	if pp.TrustedAll {
+		return true
+	}
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return addr.Network() == "unix"
+	}
+	return ContainsIP(pp.Trusted, ta.IP)
+}
+
+// 包装监听器
+func (pp *ProxyProtocol) Listener(ln net.Listener) net.Listener {
+	return &ProxyListener{Listener: ln, Proxy: pp}
+}
+
+// 包装连接， 不可信来源返回原连接
+func (pp *ProxyProtocol) Conn(conn net.Conn) net.Conn {
+	if !pp.IsTrusted(conn.RemoteAddr()) {
+		return conn
+	}
+	return &ProxyConn{Conn: conn, timeout: pp.Timeout}
+}
+
+type ProxyListener struct {
+	net.Listener
+	Proxy *ProxyProtocol
+}
+
+func (ln *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ln.Proxy.Conn(conn), nil
+}
+
+// 延迟解析头部的连接， 在第一次 Read/RemoteAddr/LocalAddr 时解析， 避免阻塞 Accept
+type ProxyConn struct {
+	net.Conn
+	timeout time.Duration
+	reader  *bufio.Reader
+	header  *ProxyHeader
+	err     error
+	once    sync.Once
+}
+
+func (pc *ProxyConn) init() {
+	pc.once.Do(func() {
+		if pc.timeout > 0 {
+			pc.Conn.SetReadDeadline(time.Now().Add(pc.timeout))
+			defer pc.Conn.SetReadDeadline(time.Time{})
+		}
+		pc.reader = bufio.NewReader(pc.Conn)
+		pc.header, pc.err = ReadProxyHeader(pc.reader)
+		if pc.err == io.EOF {
+			pc.err = nil // 交给后续的 Read 处理
+		} else if pc.err != nil {
+			Logn("[_proxypp]: read header error:", pc.Conn.RemoteAddr(), pc.err)
+			pc.Conn.Close()
+		}
+	})
+}
+
+// PROXY 头部， 不存在时返回 nil
+func (pc *ProxyConn) Header() *ProxyHeader {
+	pc.init()
+	return pc.header
+}
+
+func (pc *ProxyConn) Read(b []byte) (int, error) {
+	if pc.init(); pc.err != nil {
+		return 0, pc.err
+	}
+	return pc.reader.Read(b)
+}
+
+func (pc *ProxyConn) RemoteAddr() net.Addr {
+	if hdr := pc.Header(); hdr != nil && hdr.Command == 1 && hdr.Src != nil {
+		return hdr.Src
+	}
+	return pc.Conn.RemoteAddr()
+}
+
+func (pc *ProxyConn) LocalAddr() net.Addr {
+	if hdr := pc.Header(); hdr != nil && hdr.Command == 1 && hdr.Dst != nil {
+		return hdr.Dst
+	}
+	return pc.Conn.LocalAddr()
+}
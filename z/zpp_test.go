@@ -0,0 +1,100 @@
+package z_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suisrc/zgg/z"
+)
+
+// go test -v z/zpp_test.go -run TestProxyHeader
+func TestProxyHeader(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n"))
+	hdr, err := z.ReadProxyHeader(br)
+	if err != nil || hdr == nil || hdr.Src.String() != "192.168.0.1:56324" || hdr.Dst.String() != "192.168.0.11:443" {
+		t.Fatal(hdr, err)
+	}
+	if line, _ := br.ReadString('\n'); line != "GET / HTTP/1.1\r\n" {
+		t.Fatal(line)
+	}
+
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	for _, ver := range []byte{1, 2} {
+		hd0 := z.NewProxyHeader(src, dst)
+		hd0.Version = ver
+		br = bufio.NewReader(strings.NewReader(string(hd0.Format()) + "data"))
+		hd1, err := z.ReadProxyHeader(br)
+		if err != nil || hd1 == nil || hd1.Version != ver || hd1.Network != "tcp6" || hd1.Src.String() != src.String() {
+			t.Fatal(ver, hd1, err)
+		}
+		if rest, _ := io.ReadAll(br); string(rest) != "data" {
+			t.Fatal(string(rest))
+		}
+	}
+
+	// 没有头部， 原样返回
+	br = bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n"))
+	if hdr, err := z.ReadProxyHeader(br); hdr != nil || err != nil {
+		t.Fatal(hdr, err)
+	}
+	// 错误的头部
+	br = bufio.NewReader(strings.NewReader("PROXY TCP4 a b c d\r\n"))
+	if _, err := z.ReadProxyHeader(br); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+// go test -v z/zpp_test.go -run TestProxyListener
+func TestProxyListener(t *testing.T) {
+	accept := func(trusted ...string) (net.Conn, net.Conn) {
+		pp, err := z.NewProxyProtocol(trusted, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer ln.Close()
+		ln = pp.Listener(ln)
+		cli, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5678}
+		cli.Write(z.NewProxyHeader(src, ln.Addr()).Format())
+		cli.Write([]byte("hello"))
+		srv, _ := ln.Accept()
+		return cli, srv
+	}
+
+	cli, srv := accept("127.0.0.0/8")
+	if srv.RemoteAddr().String() != "10.1.2.3:5678" {
+		t.Fatal(srv.RemoteAddr())
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(srv, buf); err != nil || string(buf) != "hello" {
+		t.Fatal(string(buf), err)
+	}
+	cli.Close()
+	srv.Close()
+
+	// 不可信来源， 不解析头部
+	for _, trusted := range [][]string{{"10.0.0.0/8"}, nil} {
+		cli, srv = accept(trusted...)
+		if strings.HasPrefix(srv.RemoteAddr().String(), "10.1.2.3") {
+			t.Fatal(trusted, srv.RemoteAddr())
+		}
+		cli.Close()
+		srv.Close()
+	}
+	// 信任所有来源
+	cli, srv = accept("*")
+	if srv.RemoteAddr().String() != "10.1.2.3:5678" {
+		t.Fatal(srv.RemoteAddr())
+	}
+	cli.Close()
+	srv.Close()
+}